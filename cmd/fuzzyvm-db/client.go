@@ -3,8 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/cockroachdb/pebble"
 )
@@ -15,73 +18,285 @@ import (
 // as a reproducible fuzz crash.
 var errSocket = errors.New("socketDB transport error")
 
+var (
+	// errFailed wraps the message of a request the server failed.
+	errFailed = errors.New("server failed the request")
+	// errVersion is returned when client and server speak different versions
+	// of the protocol.
+	errVersion = errors.New("protocol version mismatch")
+)
+
+const (
+	// requestTimeout bounds one request, well below the 10s after which go
+	// test's fuzzing coordinator declares a worker hung.
+	requestTimeout = 5 * time.Second
+	// minBackoff and maxBackoff bound how long a client waits before dialing
+	// a server it could not reach again. Requests in the meantime fail at
+	// once, so the worker skips inputs rather than hanging on them.
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
 // socketDB is a db implementation backed by the generate server over a Unix
-// socket. It lets the fuzz worker reuse run()/hasCode() unchanged: Get becomes a
-// HAS query (returning pebble.ErrNotFound when absent, so the pre-minimize skip
-// still works) and Set/SetBatch become PUT messages to the DB-owning server.
+// socket or, for remote workers, TCP. It lets the fuzz worker reuse
+// run()/hasCode() unchanged, turning Has, Get and Iterate into HAS, GET and
+// ITER requests and Set/SetBatch into PUT messages to the DB-owning server;
+// the commands reading a live campaign go through it as well. Unlike a
+// pebbleDB, it never overwrites a key.
+//
+// A broken connection is dialed again, so a worker outlives a restart of the
+// server: a request that fails on an established connection is retried once
+// on a new one, and while the server can't be reached, dialing backs off.
 type socketDB struct {
 	mu   sync.Mutex // one request/response at a time per connection
-	conn net.Conn
+	conn net.Conn   // nil while disconnected
+	dial func() (net.Conn, error)
+
+	backoff time.Duration
+	retryAt time.Time
 }
 
 func dialSocketDB(addr string) (*socketDB, error) {
-	conn, err := net.Dial("unix", addr)
+	s := &socketDB{dial: func() (net.Conn, error) { return net.Dial("unix", addr) }}
+	conn, err := s.connect()
 	if err != nil {
 		return nil, err
 	}
-	return &socketDB{conn: conn}, nil
+	s.conn = conn
+	return s, nil
 }
 
-// Get returns (non-nil, nil) if the code hash is present and
-// (nil, pebble.ErrNotFound) if not, mirroring pebbleDB.Get closely enough for
-// hasCode. The actual value is never needed by callers, so we don't ship it.
-func (s *socketDB) Get(key []byte) ([]byte, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// dialServer connects to the server of a running campaign at addr: the path of
+// its Unix socket, or the TCP address it accepts remote workers on, which
+// takes the secret of the campaign.
+func dialServer(addr, secret string) (*socketDB, error) {
+	if fi, err := os.Stat(addr); err == nil && fi.Mode().Type() == os.ModeSocket {
+		return dialSocketDB(addr)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("%v is no socket, and reaching a server over TCP needs its --secret", addr)
+	}
+	s := dialRemoteDB(addr, []byte(secret))
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
 
-	if err := writeFrame(s.conn, opHas, key); err != nil {
-		return nil, fmt.Errorf("%w: %w", errSocket, err)
+func (s *socketDB) Has(key []byte) (bool, error) {
+	resp, _, err := s.request(opHas, key)
+	if err != nil {
+		return false, err
+	}
+	switch resp {
+	case respPresent:
+		return true, nil
+	case respAbsent:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: unexpected HAS response %q", errSocket, resp)
 	}
-	resp, err := readByte(s.conn)
+}
+
+// Get returns (nil, pebble.ErrNotFound) for a missing key, like pebbleDB.Get.
+func (s *socketDB) Get(key []byte) ([]byte, error) {
+	resp, val, err := s.request(opGet, key)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", errSocket, err)
+		return nil, err
 	}
 	switch resp {
 	case respPresent:
-		return []byte{}, nil
+		return val, nil
 	case respAbsent:
 		return nil, pebble.ErrNotFound
 	default:
-		return nil, fmt.Errorf("%w: unexpected HAS response %q", errSocket, resp)
+		return nil, fmt.Errorf("%w: unexpected GET response %q", errSocket, resp)
 	}
 }
 
 func (s *socketDB) Set(key, value []byte) error {
-	// The server keys by hash of the code, so only the code (value) is shipped.
-	return s.putBlobs(value)
+	return s.putBlobs(key, value)
 }
 
 func (s *socketDB) SetBatch(keys, values [][]byte) error {
-	return s.putBlobs(values...)
+	blobs := make([][]byte, 0, 2*len(keys))
+	for i, key := range keys {
+		blobs = append(blobs, key, values[i])
+	}
+	return s.putBlobs(blobs...)
 }
 
 func (s *socketDB) putBlobs(blobs ...[]byte) error {
+	resp, _, err := s.request(opPut, encodeBlobs(blobs...))
+	if err != nil {
+		return err
+	}
+	if resp != respAck {
+		return fmt.Errorf("%w: unexpected PUT response %q", errSocket, resp)
+	}
+	return nil
+}
+
+// Stats returns the counters of the server and the number of codes it holds.
+func (s *socketDB) Stats() (serverStats, error) {
+	resp, payload, err := s.request(opStats, nil)
+	if err != nil {
+		return serverStats{}, err
+	}
+	if resp != respAck {
+		return serverStats{}, fmt.Errorf("%w: unexpected STATS response %q", errSocket, resp)
+	}
+	return decodeStats(payload)
+}
+
+// Iterate streams the keys with the given prefix from the server. It does so on
+// a connection of its own, so fn may make requests of s, and doesn't retry: a
+// broken stream fails the iteration.
+func (s *socketDB) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	s.mu.Lock()
+	conn, err := s.connect()
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSocket, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err := writeFrame(conn, opIter, prefix); err != nil {
+		return fmt.Errorf("%w: %w", errSocket, err)
+	}
+	for {
+		// The server only waits for the client, so every item gets the time
+		// of a request.
+		conn.SetDeadline(time.Now().Add(requestTimeout))
+		resp, payload, err := readReply(conn)
+		if err != nil {
+			return err
+		}
+		switch resp {
+		case respAck:
+			return nil
+		case respPresent:
+			item, err := decodeBlobs(payload)
+			if err != nil || len(item) != 2 {
+				return fmt.Errorf("%w: malformed ITER item", errSocket)
+			}
+			if err := fn(item[0], item[1]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: unexpected ITER response %q", errSocket, resp)
+		}
+	}
+}
+
+// request sends a request and returns the server's reply. Requests are
+// idempotent, so one whose connection broke is sent again on a new one. A
+// request the server failed is not retried.
+func (s *socketDB) request(op byte, payload []byte) (byte, []byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := writeFrame(s.conn, opPut, encodeBlobs(blobs...)); err != nil {
-		return fmt.Errorf("%w: %w", errSocket, err)
+	for retried := false; ; retried = true {
+		if s.conn == nil {
+			if err := s.reconnect(); err != nil {
+				return 0, nil, fmt.Errorf("%w: %w", errSocket, err)
+			}
+		}
+		resp, reply, err := s.exchange(op, payload)
+		if err == nil || errors.Is(err, errFailed) {
+			return resp, reply, err
+		}
+		s.conn.Close()
+		s.conn = nil
+		if retried {
+			return 0, nil, err
+		}
+	}
+}
+
+// exchange sends one request on the current connection and reads the reply.
+func (s *socketDB) exchange(op byte, payload []byte) (byte, []byte, error) {
+	s.conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err := writeFrame(s.conn, op, payload); err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", errSocket, err)
 	}
-	resp, err := readByte(s.conn)
+	return readReply(s.conn)
+}
+
+// readReply reads the reply to a request, turning a failure into an error
+// wrapping errFailed.
+func readReply(conn net.Conn) (byte, []byte, error) {
+	resp, payload, err := readFrame(conn)
 	if err != nil {
-		return fmt.Errorf("%w: %w", errSocket, err)
+		return 0, nil, fmt.Errorf("%w: %w", errSocket, err)
 	}
-	if resp != respAck {
-		return fmt.Errorf("%w: unexpected PUT response %q", errSocket, resp)
+	if resp == respFailed {
+		return 0, nil, fmt.Errorf("%w: %s", errFailed, payload)
+	}
+	return resp, payload, nil
+}
+
+// connect dials the server and agrees on the protocol version.
+func (s *socketDB) connect() (net.Conn, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := clientHello(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// clientHello tells the server which protocol version the client speaks.
+func clientHello(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := writeFrame(conn, opHello, encodeVersion(protocolVersion)); err != nil {
+		return err
+	}
+	resp, payload, err := readFrame(conn)
+	if errors.Is(err, io.EOF) {
+		// Servers from before HELLO hang up on the unknown request.
+		return fmt.Errorf("%w: server closed the connection; is it older than protocol version %d?", errVersion, protocolVersion)
+	} else if err != nil {
+		return err
+	}
+	if resp == respFailed {
+		return fmt.Errorf("%w: %s", errVersion, payload)
+	}
+	if version, err := decodeVersion(payload); err != nil || resp != respAck || version != protocolVersion {
+		return fmt.Errorf("%w: unexpected HELLO reply", errVersion)
 	}
 	return nil
 }
 
+// reconnect dials the server, unless the last attempt failed too recently.
+func (s *socketDB) reconnect() error {
+	if wait := time.Until(s.retryAt); wait > 0 {
+		return fmt.Errorf("server unreachable, next attempt in %v", wait.Round(time.Millisecond))
+	}
+	conn, err := s.connect()
+	if err != nil {
+		s.backoff = min(max(2*s.backoff, minBackoff), maxBackoff)
+		s.retryAt = time.Now().Add(s.backoff)
+		return err
+	}
+	s.conn, s.backoff = conn, 0
+	return nil
+}
+
 func (s *socketDB) Close() error {
-	return s.conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dial = func() (net.Conn, error) { return nil, net.ErrClosed }
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
 }
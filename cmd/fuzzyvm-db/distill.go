@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+	"github.com/cockroachdb/pebble"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/urfave/cli/v2"
+)
+
+var distillCommand = &cli.Command{
+	Name:   "distill",
+	Usage:  "write the smallest subset of the stored codes that keeps their coverage to a new database",
+	Action: distill,
+	Flags: []cli.Flag{
+		dbFlag,
+		serverFlag,
+		secretFlag,
+		&cli.StringFlag{
+			Name:     "out",
+			Usage:    "path to the database to write the distilled corpus to, which must not hold codes yet",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "signal",
+			Usage: "what coverage is: trace (opcodes, opcode pairs, errors, calls and precompiles a tracer sees) or cover (core/vm statement coverage, needs a binary built with -cover)",
+			Value: "trace",
+		},
+		&cli.IntFlag{
+			Name:    "workers",
+			Aliases: []string{"w"},
+			Usage:   "number of parallel replay workers (0 = one per CPU; cover replays one code at a time)",
+		},
+	},
+}
+
+// feature is one thing the replay of a code covers. The top byte holds its
+// kind, the rest what it is about.
+type feature uint64
+
+const (
+	featOp         feature = iota + 1 // an opcode executed
+	featEdge                          // an opcode executed right after another in the same frame
+	featOpError                       // an opcode failing with an error
+	featCall                          // a call of some type at some depth
+	featHalt                          // a frame ending with an error
+	featPrecompile                    // a precompile succeeding or failing
+	featBlock                         // a block of Go statements executed
+)
+
+func newFeature(kind feature, a, b uint64) feature {
+	return kind<<56 | feature(a&0xffffff)<<24 | feature(b&0xffffff)
+}
+
+// measureFunc replays a code, and the test it was imported with if any, and returns
+// the features the replay covers.
+type measureFunc func(code []byte, imp *importedTest) ([]feature, error)
+
+// distill replays every stored code and writes the smallest subset it can find
+// that covers everything the whole corpus covers to a new database, together
+// with the records and imported tests of the codes.
+func distill(ctx *cli.Context) error {
+	out, err := filepath.Abs(ctx.String("out"))
+	if err != nil {
+		return err
+	}
+	if ctx.String(serverFlag.Name) == "" {
+		if in, err := filepath.Abs(ctx.String(dbFlag.Name)); err != nil {
+			return err
+		} else if in == out {
+			return fmt.Errorf("cannot distill %v into itself", in)
+		}
+	}
+	workers := ctx.Int("workers")
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var measure measureFunc
+	switch name := ctx.String("signal"); name {
+	case "trace":
+		precompiles, err := forkPrecompiles()
+		if err != nil {
+			return err
+		}
+		measure = traceFeatures(precompiles)
+	case "cover":
+		// The coverage counters are global to the binary, so only one code
+		// may run at a time.
+		if err := fuzzer.ClearCoverage(); err != nil {
+			return fmt.Errorf("the cover signal needs a binary built with -cover: %w", err)
+		}
+		measure, workers = coverFeatures, 1
+	default:
+		return fmt.Errorf("unknown signal %q", name)
+	}
+	src, err := openReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := createDB(out)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if n := countKeys(dst.db); n > 0 {
+		return fmt.Errorf("%v already holds %d codes", out, n)
+	}
+
+	res, err := distillCodes(src, measure, workers)
+	if err != nil {
+		return err
+	}
+	if err := copyCodes(dst.db, src, res.keep); err != nil {
+		return err
+	}
+	fmt.Printf("Kept %d of %d codes, dropped %d; the kept ones cover all %d features\n", len(res.keep), res.codes, res.codes-len(res.keep), res.features)
+	if res.unmeasured > 0 {
+		fmt.Printf("%d codes failed to replay or could not be measured and were kept as they are\n", res.unmeasured)
+	}
+	return nil
+}
+
+// distillResult is what distilling a corpus found.
+type distillResult struct {
+	keep       [][]byte // hashes of the codes to keep
+	codes      int      // codes in the corpus
+	features   int      // features the corpus covers
+	unmeasured int      // codes that failed to replay or weren't measured, which are kept
+}
+
+// candidate is a code that is, for now, the smallest to cover a feature.
+type candidate struct {
+	hash     []byte
+	size     int
+	features []feature
+	refs     int // features it is the smallest code for
+}
+
+// smaller orders codes by size, and codes of the same size by hash, so the
+// outcome doesn't depend on the order the workers finish in.
+func (c *candidate) smaller(o *candidate) bool {
+	if c.size != o.size {
+		return c.size < o.size
+	}
+	return bytes.Compare(c.hash, o.hash) < 0
+}
+
+// distillCodes measures every code in db and picks a small subset of them that
+// covers every feature any of them covers. It remembers the smallest code for
+// every feature, like afl-cmin, and then greedily covers the features with
+// those candidates, most new features first.
+func distillCodes(db db, measure measureFunc, workers int) (*distillResult, error) {
+	type job struct {
+		hash, code []byte
+	}
+	type result struct {
+		candidate
+		err error
+	}
+	var (
+		jobs    = make(chan job, workers*4)
+		results = make(chan result, workers*4)
+		iterErr = make(chan error, 1) // the producer's error, once it is done
+		wg      sync.WaitGroup
+	)
+	go func() {
+		err := db.Iterate([]byte(codePrefix), func(key, code []byte) error {
+			jobs <- job{bytes.Clone(codeHash(key)), bytes.Clone(code)}
+			return nil
+		})
+		close(jobs)
+		iterErr <- err
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r := result{candidate: candidate{hash: j.hash, size: len(j.code)}}
+				imp, err := loadImport(db, j.hash)
+				if err == nil {
+					r.features, err = measure(j.code, imp)
+				}
+				r.err = err
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		res  = new(distillResult)
+		best = make(map[feature]*candidate)
+	)
+	for r := range results {
+		if res.codes++; res.codes%10000 == 0 {
+			log.Printf("Replayed %d codes, %d features so far", res.codes, len(best))
+		}
+		if r.err != nil {
+			log.Printf("keeping code %x, which could not be measured: %v", r.hash, r.err)
+			res.keep = append(res.keep, r.hash)
+			res.unmeasured++
+			continue
+		}
+		c := &r.candidate
+		for _, f := range c.features {
+			if old, ok := best[f]; ok {
+				if !c.smaller(old) {
+					continue
+				}
+				// Only the candidates that are still the smallest for some
+				// feature hold on to their features.
+				if old.refs--; old.refs == 0 {
+					old.features = nil
+				}
+			}
+			best[f] = c
+			c.refs++
+		}
+	}
+	if err := <-iterErr; err != nil {
+		return nil, err
+	}
+	res.features = len(best)
+	res.keep = append(res.keep, greedyCover(best)...)
+	return res, nil
+}
+
+// greedyCover picks candidates out of best, the smallest candidate for every
+// feature, until the picked ones cover every feature: each time the one that
+// covers the most features not covered yet, the smallest one of those. It
+// returns the hashes of the picked candidates.
+func greedyCover(best map[feature]*candidate) [][]byte {
+	var queue coverQueue
+	seen := make(map[*candidate]bool)
+	for _, c := range best {
+		if !seen[c] {
+			seen[c] = true
+			queue = append(queue, &coverItem{c, len(c.features)})
+		}
+	}
+	heap.Init(&queue)
+	var (
+		covered = make(map[feature]bool, len(best))
+		picked  [][]byte
+	)
+	for queue.Len() > 0 {
+		// The gain of a candidate only shrinks as others are picked, so the
+		// one on top is the best pick once its gain is up to date.
+		item := queue[0]
+		gain := 0
+		for _, f := range item.features {
+			if !covered[f] {
+				gain++
+			}
+		}
+		if gain == 0 {
+			heap.Pop(&queue)
+			continue
+		}
+		if gain < item.gain {
+			item.gain = gain
+			heap.Fix(&queue, 0)
+			continue
+		}
+		heap.Pop(&queue)
+		for _, f := range item.features {
+			covered[f] = true
+		}
+		picked = append(picked, item.hash)
+	}
+	return picked
+}
+
+// coverItem is a candidate with an upper bound of the features it would add.
+type coverItem struct {
+	*candidate
+	gain int
+}
+
+// coverQueue is a max-heap of candidates by gain, then smallness.
+type coverQueue []*coverItem
+
+func (q coverQueue) Len() int { return len(q) }
+func (q coverQueue) Less(i, j int) bool {
+	if q[i].gain != q[j].gain {
+		return q[i].gain > q[j].gain
+	}
+	return q[i].smaller(q[j].candidate)
+}
+func (q coverQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *coverQueue) Push(x any)   { *q = append(*q, x.(*coverItem)) }
+func (q *coverQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// copyCodes copies the codes with the given hashes from src to dst, each with
+// its record and imported test.
+func copyCodes(dst *pebble.DB, src db, hashes [][]byte) error {
+	batch := dst.NewBatch()
+	defer batch.Close()
+	for i, hash := range hashes {
+		code, err := src.Get(append([]byte(codePrefix), hash...))
+		if err != nil {
+			return fmt.Errorf("code %x: %w", hash, err)
+		}
+		if err := batch.Set(codeKey(code), code, nil); err != nil {
+			return err
+		}
+		if err := copyRecords(batch, src, hash); err != nil {
+			return err
+		}
+		if (i+1)%batchSize == 0 {
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// traceFeatures measures codes by replaying them under a featureTracer, which
+// tells calls to the given precompiles, those of the replayed fork, apart.
+func traceFeatures(precompiles map[common.Address]bool) measureFunc {
+	return func(code []byte, imp *importedTest) ([]feature, error) {
+		t := newFeatureTracer(precompiles)
+		hooks := t.hooks()
+		// A replay that timed out may still be running, so t is only read
+		// after the ones that returned.
+		if err := replayCode(code, hooks); err != nil {
+			return nil, err
+		}
+		if imp != nil {
+			if err := replayImport(imp, hooks); err != nil {
+				return nil, err
+			}
+		}
+		return t.list(), nil
+	}
+}
+
+// featureTracer collects what an execution covers, in terms that mean the same
+// in every code: which opcodes ran, and which right after which in the same
+// frame, which failed how, which calls were made how deep, which frames halted
+// how, and which precompiles succeeded or failed.
+type featureTracer struct {
+	features    map[feature]struct{}
+	frames      []traceFrame
+	precompiles map[common.Address]bool // of the fork replayed
+}
+
+// traceFrame is what a featureTracer tracks of a call frame.
+type traceFrame struct {
+	prev       uint64 // the last opcode executed plus one, 0 before the first
+	precompile uint64 // the address of the precompile called, 0 for code
+}
+
+func newFeatureTracer(precompiles map[common.Address]bool) *featureTracer {
+	return &featureTracer{features: make(map[feature]struct{}), precompiles: precompiles}
+}
+
+func (t *featureTracer) add(kind feature, a, b uint64) {
+	t.features[newFeature(kind, a, b)] = struct{}{}
+}
+
+func (t *featureTracer) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnEnter: func(depth int, typ byte, _, to common.Address, _ []byte, _ uint64, _ *big.Int) {
+			t.add(featCall, uint64(typ), uint64(depth))
+			frame := traceFrame{}
+			if t.precompiles[to] {
+				frame.precompile = uint64(binary.BigEndian.Uint16(to[18:]))
+			}
+			t.frames = append(t.frames, frame)
+		},
+		OnExit: func(_ int, _ []byte, _ uint64, err error, _ bool) {
+			if len(t.frames) == 0 {
+				return
+			}
+			frame := t.frames[len(t.frames)-1]
+			t.frames = t.frames[:len(t.frames)-1]
+			if frame.precompile != 0 {
+				ok := uint64(0)
+				if err == nil {
+					ok = 1
+				}
+				t.add(featPrecompile, frame.precompile, ok)
+			}
+			if err != nil {
+				t.add(featHalt, errorID(err), 0)
+			}
+		},
+		OnOpcode: func(_ uint64, op byte, _, _ uint64, _ tracing.OpContext, _ []byte, _ int, err error) {
+			t.add(featOp, uint64(op), 0)
+			if len(t.frames) > 0 {
+				frame := &t.frames[len(t.frames)-1]
+				t.add(featEdge, frame.prev, uint64(op))
+				frame.prev = uint64(op) + 1
+			}
+			if err != nil {
+				t.add(featOpError, uint64(op), errorID(err))
+			}
+		},
+	}
+}
+
+// list returns the features in order.
+func (t *featureTracer) list() []feature {
+	features := make([]feature, 0, len(t.features))
+	for f := range t.features {
+		features = append(features, f)
+	}
+	slices.Sort(features)
+	return features
+}
+
+// interned numbers strings, such as errors and coverage blocks, so features can
+// refer to them.
+var interned = struct {
+	sync.Mutex
+	ids map[string]uint64
+}{ids: make(map[string]uint64)}
+
+func intern(s string) uint64 {
+	interned.Lock()
+	defer interned.Unlock()
+	id, ok := interned.ids[s]
+	if !ok {
+		id = uint64(len(interned.ids)) + 1
+		interned.ids[s] = id
+	}
+	return id
+}
+
+// errorID interns the kind of err.
+func errorID(err error) uint64 {
+	return intern(errorKind(err))
+}
+
+// errorKind names the kind of err. The details some EVM errors carry, like the
+// stack height of a stack underflow, are left out.
+func errorKind(err error) string {
+	var (
+		underflow *vm.ErrStackUnderflow
+		overflow  *vm.ErrStackOverflow
+	)
+	switch {
+	case errors.As(err, &underflow):
+		return "stack underflow"
+	case errors.As(err, &overflow):
+		return "stack limit reached"
+	}
+	msg := err.Error()
+	if i := strings.IndexAny(msg, "(:"); i > 0 {
+		msg = msg[:i]
+	}
+	return strings.TrimSpace(msg)
+}
+
+// errStrayReplay is returned for a code measured while a replay that timed out
+// still runs.
+var errStrayReplay = errors.New("a replay that timed out still runs")
+
+// coverFeatures measures a code by the Go statements of the instrumented
+// packages its replay executes, read off the coverage counters of the binary.
+// While a replay that timed out still runs, the counters are not the code's
+// alone, so it is not measured at all.
+func coverFeatures(code []byte, imp *importedTest) ([]feature, error) {
+	if strayReplays.Load() > 0 {
+		return nil, errStrayReplay
+	}
+	if err := fuzzer.ClearCoverage(); err != nil {
+		return nil, err
+	}
+	if err := replayCode(code, nil); err != nil {
+		return nil, err
+	}
+	if imp != nil {
+		if err := replayImport(imp, nil); err != nil {
+			return nil, err
+		}
+	}
+	profile, err := fuzzer.ReadCoverage()
+	if err != nil {
+		return nil, err
+	}
+	var features []feature
+	for _, b := range profile {
+		if b.Count > 0 {
+			block := fmt.Sprintf("%s:%d.%d,%d.%d", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+			features = append(features, newFeature(featBlock, intern(block), 0))
+		}
+	}
+	slices.Sort(features)
+	return slices.Compact(features), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// TestGreedyCover checks that the cover takes the candidates adding the most
+// features first, the smaller of equal ones, and leaves out those whose
+// features are covered by then.
+func TestGreedyCover(t *testing.T) {
+	var (
+		big   = &candidate{hash: []byte{1}, size: 30, features: []feature{1, 2, 3, 4}}
+		left  = &candidate{hash: []byte{2}, size: 10, features: []feature{1, 2}}
+		right = &candidate{hash: []byte{3}, size: 10, features: []feature{3, 4, 5}}
+		same  = &candidate{hash: []byte{4}, size: 20, features: []feature{3, 4, 5}}
+	)
+	for _, tt := range []struct {
+		best map[feature]*candidate
+		want [][]byte
+	}{
+		// big covers what left does and more, right adds the rest.
+		{map[feature]*candidate{1: left, 2: big, 3: big, 4: big, 5: right}, [][]byte{{1}, {3}}},
+		{map[feature]*candidate{1: left, 2: left, 3: right, 4: right, 5: right}, [][]byte{{2}, {3}}},
+		{map[feature]*candidate{3: same, 4: right, 5: same}, [][]byte{{3}}},
+	} {
+		got := greedyCover(tt.best)
+		slices.SortFunc(got, bytes.Compare)
+		if !slices.EqualFunc(got, tt.want, bytes.Equal) {
+			t.Errorf("picked %x, want %x", got, tt.want)
+		}
+	}
+}
+
+// TestDistill distills a corpus in which one program only executes a part of
+// what another does.
+func TestDistill(t *testing.T) {
+	dir := t.TempDir()
+	src, err := createDB(dir + "/src.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	var (
+		// PUSH1 1 PUSH1 2 ADD STOP
+		part = hexutil.MustDecode("0x600160020100")
+		// PUSH1 1 PUSH1 2 ADD PUSH1 1 PUSH1 2 ADD STOP
+		whole = hexutil.MustDecode("0x60016002016001600201" + "00")
+		// INVALID
+		other = hexutil.MustDecode("0xfe")
+	)
+	for _, code := range [][]byte{part, whole, other} {
+		origin := codeInfo{Source: "test"}
+		if err := src.SetBatch(
+			[][]byte{codeKey(code), infoKey(makeKey(code))},
+			[][]byte{code, origin.record("full", nil)},
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	precompiles, err := forkPrecompiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := distillCodes(src, traceFeatures(precompiles), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.codes != 3 || len(res.keep) != 2 || res.unmeasured != 0 {
+		t.Fatalf("kept %d of %d codes (%d unmeasured), want 2 of 3", len(res.keep), res.codes, res.unmeasured)
+	}
+	dst, err := createDB(dir + "/dst.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := copyCodes(dst.db, src, res.keep); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		code []byte
+		kept bool
+	}{{"part", part, false}, {"whole", whole, true}, {"other", other, true}} {
+		if have, err := hasCode(dst, tt.code); err != nil || have != tt.kept {
+			t.Errorf("distilled corpus has %s: (%v, %v), want %v", tt.name, have, err, tt.kept)
+		}
+		// Records travel with their codes.
+		if info, err := loadInfo(dst, makeKey(tt.code)); err != nil || (info != nil) != tt.kept {
+			t.Errorf("record of %s: (%+v, %v)", tt.name, info, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/sink"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
+)
+
+var exportCommand = &cli.Command{
+	Name:   "export",
+	Usage:  "write the stored codes out as state tests, hex or JSON lines",
+	Action: export,
+	Flags: []cli.Flag{
+		dbFlag,
+		serverFlag,
+		secretFlag,
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "statetest (a filled state test per code), hex (one code per line) or jsonl (one JSON object per code, with its record)",
+			Value: "hex",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "file to write hex or jsonl to (default stdout), or directory to write state tests to",
+		},
+		&cli.StringFlag{
+			Name:  "seed",
+			Usage: "hex filler seed the environment of the state tests is made from (default all zero, as in replay)",
+		},
+		&cli.StringFlag{
+			Name:  "pre",
+			Usage: "state test file whose pre-state and transaction the state tests use instead of the seed's",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "export at most this many codes (0 = all)",
+		},
+		&cli.IntFlag{
+			Name:  "min-size",
+			Usage: "skip codes shorter than this many bytes",
+		},
+		&cli.IntFlag{
+			Name:  "max-size",
+			Usage: "skip codes longer than this many bytes (0 = no limit)",
+		},
+		&cli.BoolFlag{
+			Name:  "minimized",
+			Usage: "only export minimized codes, and full ones that did not shrink",
+		},
+	},
+}
+
+// exportFilter selects the codes to export.
+type exportFilter struct {
+	limit            int
+	minSize, maxSize int
+	minimized        bool
+}
+
+// keep reports whether the code with the given hash and record passes f.
+func (f exportFilter) keep(hash, code []byte, info *codeInfo) bool {
+	if len(code) < f.minSize || (f.maxSize > 0 && len(code) > f.maxSize) {
+		return false
+	}
+	if f.minimized {
+		// Migrated codes don't know their form, so they are never minimized.
+		return info != nil && (info.Form == "minimized" || (info.Form == "full" && bytes.Equal(info.Minimized, hash)))
+	}
+	return true
+}
+
+// exportedCode is a code as the jsonl format writes it.
+type exportedCode struct {
+	Hash hexutil.Bytes `json:"hash"`
+	Code hexutil.Bytes `json:"code"`
+	Info *codeInfo     `json:"info,omitempty"`
+}
+
+// exportFunc writes out one code.
+type exportFunc func(hash, code []byte, info *codeInfo) error
+
+// export writes the codes that pass the filter flags out in the chosen format.
+func export(ctx *cli.Context) error {
+	db, err := openReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	filter := exportFilter{
+		limit:     ctx.Int("limit"),
+		minSize:   ctx.Int("min-size"),
+		maxSize:   ctx.Int("max-size"),
+		minimized: ctx.Bool("minimized"),
+	}
+	out := ctx.String("out")
+
+	var write exportFunc
+	// finish flushes and closes the output once the codes are written.
+	finish := func() error { return nil }
+	switch format := ctx.String("format"); format {
+	case "hex", "jsonl":
+		w := io.Writer(os.Stdout)
+		closeOut := func() error { return nil }
+		if out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			w, closeOut = f, f.Close
+		}
+		buf := bufio.NewWriter(w)
+		finish = func() error { return errors.Join(buf.Flush(), closeOut()) }
+		if format == "hex" {
+			write = exportHex(buf)
+		} else {
+			write = exportJSONL(buf)
+		}
+	case "statetest":
+		if out == "" {
+			return fmt.Errorf("--out must name the directory to write state tests to")
+		}
+		maker, err := exportMaker(db, ctx.String("seed"), ctx.String("pre"))
+		if err != nil {
+			return err
+		}
+		write = exportStateTests(sink.NewDir(out), maker)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	n, err := exportCodes(db, filter, write)
+	err = errors.Join(err, finish())
+	fmt.Fprintf(os.Stderr, "Exported %d codes\n", n)
+	return err
+}
+
+// exportCodes calls write for every code in db that passes filter, and returns
+// how many it wrote.
+func exportCodes(db db, filter exportFilter, write exportFunc) (int, error) {
+	n := 0
+	err := db.Iterate([]byte(codePrefix), func(key, value []byte) error {
+		if filter.limit > 0 && n >= filter.limit {
+			return errStop
+		}
+		// A state test outlives the iteration step if it times out filling.
+		hash, code := bytes.Clone(codeHash(key)), bytes.Clone(value)
+		info, err := loadInfo(db, hash)
+		if err != nil {
+			return err
+		}
+		if !filter.keep(hash, code, info) {
+			return nil
+		}
+		if err := write(hash, code, info); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if errors.Is(err, errStop) {
+		err = nil
+	}
+	return n, err
+}
+
+// exportHex writes every code as a line of hex.
+func exportHex(w io.Writer) exportFunc {
+	return func(_, code []byte, _ *codeInfo) error {
+		_, err := fmt.Fprintf(w, "%x\n", code)
+		return err
+	}
+}
+
+// exportJSONL writes every code as a line of JSON, with its hash and record.
+func exportJSONL(w io.Writer) exportFunc {
+	enc := json.NewEncoder(w)
+	return func(hash, code []byte, info *codeInfo) error {
+		return enc.Encode(exportedCode{Hash: hash, Code: code, Info: info})
+	}
+}
+
+// exportMaker returns what builds the state test of a code: the pre-state and
+// transaction of the test in the file pre, if given. Otherwise an imported code
+// keeps its own, and every other code gets the environment the filler makes
+// from seed.
+func exportMaker(db db, seed, pre string) (func(hash, code []byte) (*fuzzing.GstMaker, error), error) {
+	if pre != "" {
+		imported, skipped, err := readStateTests(pre)
+		if err != nil {
+			return nil, err
+		}
+		if len(skipped) > 0 {
+			return nil, errors.Join(skipped...)
+		}
+		if len(imported) != 1 {
+			return nil, fmt.Errorf("%v holds %d state tests, want one", pre, len(imported))
+		}
+		return func(_, code []byte) (*fuzzing.GstMaker, error) {
+			return imported[0].gstMaker(code), nil
+		}, nil
+	}
+	fill := replaySeed
+	if seed != "" {
+		var err error
+		if fill, err = hexutil.Decode(seed); err != nil {
+			return nil, fmt.Errorf("invalid seed: %w", err)
+		}
+	}
+	return func(hash, code []byte) (*fuzzing.GstMaker, error) {
+		imp, err := loadImport(db, hash)
+		if err != nil {
+			return nil, err
+		}
+		if imp != nil {
+			return imp.gstMaker(code), nil
+		}
+		return generator.CreateGstMaker(filler.NewFiller(fill), code), nil
+	}, nil
+}
+
+// exportStateTests fills the state test maker builds of every code and stores
+// it in out, with the record of the code as its metadata. A code whose test
+// fails to fill is skipped.
+func exportStateTests(out sink.Sink, maker func(hash, code []byte) (*fuzzing.GstMaker, error)) exportFunc {
+	return func(hash, code []byte, info *codeInfo) error {
+		gst, err := maker(hash, code)
+		if err != nil {
+			return err
+		}
+		if err := guardReplay(func() error { return gst.Fill(nil, 0) }); err != nil {
+			log.Printf("skipping code %x: %v", hash, err)
+			return nil
+		}
+		name := fmt.Sprintf("FuzzyVM-%x", hash)
+		data, err := json.Marshal(gst.ToGeneralStateTest(name))
+		if err != nil {
+			return err
+		}
+		var meta []byte
+		if info != nil {
+			if meta, err = json.Marshal(info); err != nil {
+				return err
+			}
+		}
+		_, err = out.Store(sink.Test{Name: name, Hash: hash, JSON: data, Meta: meta})
+		return err
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/sink"
+)
+
+// TestExport stores a generated program and an imported test, and exports
+// them in every format.
+func TestExport(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/test.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	input := []byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf")
+	gst, code, _ := generator.RecordProgram(filler.NewFiller(input))
+	if _, err := storeProgram(db, gst, code, codeInfo{Source: "generate", Input: input}); err != nil {
+		t.Fatal(err)
+	}
+	imported, _, err := readStateTests("../../interesting_inputs/BenchTest-16.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storeImport(db, imported[0]); err != nil {
+		t.Fatal(err)
+	}
+	total := countKeys(db.db)
+
+	// Every code as hex, and only the minimized ones.
+	var out bytes.Buffer
+	if n, err := exportCodes(db, exportFilter{}, exportHex(&out)); err != nil || n != total || strings.Count(out.String(), "\n") != total {
+		t.Fatalf("hex export of %d codes = (%d, %v):\n%s", total, n, err, out.String())
+	}
+	out.Reset()
+	n, err := exportCodes(db, exportFilter{minimized: true}, exportJSONL(&out))
+	if err != nil || n != 1 {
+		t.Fatalf("minimized export = (%d, %v), want 1", n, err)
+	}
+	var exported exportedCode
+	if err := json.Unmarshal(out.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Info == nil || exported.Info.Source != "generate" || !bytes.Equal(makeKey(exported.Code), exported.Hash) {
+		t.Fatalf("unexpected export %s", out.String())
+	}
+	// Size filters and the limit.
+	if n, _ := exportCodes(db, exportFilter{maxSize: len(exported.Code)}, exportHex(&out)); n != 1 {
+		t.Errorf("max-size export of %d codes, want 1", n)
+	}
+	if n, _ := exportCodes(db, exportFilter{minSize: 1 << 20}, exportHex(&out)); n != 0 {
+		t.Errorf("min-size export of %d codes, want 0", n)
+	}
+	if n, _ := exportCodes(db, exportFilter{limit: 1}, exportHex(&out)); n != 1 {
+		t.Errorf("limited export of %d codes, want 1", n)
+	}
+
+	// Filled state tests, with the records next to them.
+	dir := t.TempDir()
+	maker, err := exportMaker(db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := exportCodes(db, exportFilter{}, exportStateTests(sink.NewDir(dir), maker)); err != nil || n != total {
+		t.Fatalf("state test export = (%d, %v), want %d", n, err, total)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	var tests int
+	for _, path := range files {
+		if strings.HasSuffix(path, ".meta.json") {
+			continue
+		}
+		tests++
+		if _, _, err := readStateTests(path); err != nil {
+			t.Errorf("exported test %v does not read back: %v", path, err)
+		}
+	}
+	if tests != total || len(files) != 2*total {
+		t.Fatalf("exported %d tests and %d records, want %d", tests, len(files)-tests, total)
+	}
+}
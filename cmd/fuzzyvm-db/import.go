@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/cockroachdb/pebble"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
+)
+
+// importPrefix namespaces imported state tests in the database. An imported
+// test is keyed by importPrefix plus the hash of its target code, so the code
+// can be looked up from either side.
+const importPrefix = "import/"
+
+// minGasPrice matches the gas price of generated transactions, which clears the
+// base fee of GstMaker's environment.
+var minGasPrice = big.NewInt(0x80)
+
+var importCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "import GeneralStateTest JSON files (ours or ethereum/tests) as seeds",
+	ArgsUsage: "<file or directory>...",
+	Action:    importTests,
+	Flags:     []cli.Flag{dbFlag},
+}
+
+// importedTest is what an import keeps of a state test: everything needed to
+// run the target code again in its original surroundings. The transaction is
+// reduced to its first data/gas/value combination, the one GstMaker runs.
+type importedTest struct {
+	Name   string                `json:"name"`
+	Source string                `json:"source"`
+	Pre    fuzzing.GenesisAlloc  `json:"pre"`
+	Tx     fuzzing.StTransaction `json:"transaction"`
+	// Target is the account the transaction calls, whose code is the one
+	// stored in the corpus. It is nil for a creation transaction, where the
+	// target code is the init code in the transaction data.
+	Target *common.Address `json:"target,omitempty"`
+}
+
+// prefixRange returns iterator bounds covering exactly the keys that start with
+// prefix.
+func prefixRange(prefix []byte) *pebble.IterOptions {
+	upper := bytes.Clone(prefix)
+	upper[len(upper)-1]++
+	return &pebble.IterOptions{LowerBound: prefix, UpperBound: upper}
+}
+
+func importKey(code []byte) []byte {
+	return append([]byte(importPrefix), makeKey(code)...)
+}
+
+// importTests imports every state test found in the given files and
+// directories. Each subtest's target code is stored like any generated code, so
+// mutation and replay pick it up, and the surrounding pre-state and transaction
+// are stored next to it.
+func importTests(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return fmt.Errorf("no state test files given")
+	}
+	pdb, err := createDB(ctx.String(dbFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer pdb.Close()
+
+	var tests, codes int
+	for _, root := range ctx.Args().Slice() {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".json") {
+				return nil
+			}
+			imported, skipped, err := readStateTests(path)
+			if err != nil {
+				// ethereum/tests mixes state tests with other fixtures; one
+				// unreadable file shouldn't abort a directory import.
+				fmt.Fprintf(os.Stderr, "skipping %v: %v\n", path, err)
+				return nil
+			}
+			for _, err := range skipped {
+				fmt.Fprintf(os.Stderr, "skipping %v: %v\n", path, err)
+			}
+			for _, imp := range imported {
+				isNew, kept, err := storeImport(pdb, imp)
+				if err != nil {
+					return err
+				}
+				if kept != nil {
+					fmt.Fprintf(os.Stderr, "skipping %v: test %q has the code of test %q in %v, which is kept\n", path, imp.Name, kept.Name, kept.Source)
+					continue
+				}
+				tests++
+				if isNew {
+					codes++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Imported %d tests (%d new codes)\n", tests, codes)
+	return nil
+}
+
+// readStateTests parses a GeneralStateTest file and extracts one importedTest
+// per named test in it, in name order. Tests that can't be imported are left
+// out and returned as errors in skipped; err is only set if the file is not a
+// state test file at all.
+func readStateTests(path string) (tests []*importedTest, skipped []error, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only the pre-state and transaction are decoded: the post section is
+	// rewritten on every run anyway, and older fillers wrote its hashes
+	// without the 0x prefix the full GeneralStateTest decoder insists on.
+	var gst map[string]*struct {
+		Pre fuzzing.GenesisAlloc  `json:"pre"`
+		Tx  fuzzing.StTransaction `json:"transaction"`
+	}
+	if err := json.Unmarshal(data, &gst); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(gst)) {
+		imp, err := readStateTest(path, name, gst[name].Pre, gst[name].Tx)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		tests = append(tests, imp)
+	}
+	if len(tests) == 0 && len(skipped) > 0 {
+		return nil, nil, errors.Join(skipped...)
+	}
+	return tests, skipped, nil
+}
+
+// readStateTest extracts the importedTest of the test name in path.
+func readStateTest(path, name string, pre fuzzing.GenesisAlloc, tx fuzzing.StTransaction) (*importedTest, error) {
+	if len(pre) == 0 {
+		return nil, fmt.Errorf("test %q has no pre-state, not a state test", name)
+	}
+	tx.Data = firstOf(tx.Data)
+	tx.Value = firstOf(tx.Value)
+	tx.GasLimit = firstOf(tx.GasLimit)
+	if len(tx.Data) == 0 || len(tx.Value) == 0 || len(tx.GasLimit) == 0 {
+		return nil, fmt.Errorf("test %q has an incomplete transaction", name)
+	}
+	// Older tests only carry the secret key. GstMaker always writes the
+	// sender field, and geth trusts it over the key, so fill it in.
+	if tx.Sender == (common.Address{}) && len(tx.PrivateKey) > 0 {
+		key, err := crypto.ToECDSA(tx.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("test %q: %w", name, err)
+		}
+		tx.Sender = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	imp := &importedTest{Name: name, Source: path, Pre: pre, Tx: tx}
+	if tx.To != "" {
+		to := common.HexToAddress(tx.To)
+		imp.Target = &to
+	}
+	code, err := imp.code()
+	if err != nil {
+		return nil, fmt.Errorf("test %q: %w", name, err)
+	}
+	// The code is what gets stored, mutated and replayed: there is nothing to
+	// work on in an empty one, or in a delegation, which only points at the
+	// code that runs.
+	if len(code) == 0 {
+		return nil, fmt.Errorf("test %q has no target code", name)
+	}
+	if _, ok := types.ParseDelegation(code); ok {
+		return nil, fmt.Errorf("test %q targets a delegation designator, not code", name)
+	}
+	return imp, nil
+}
+
+func firstOf[T any](s []T) []T {
+	if len(s) > 1 {
+		return s[:1]
+	}
+	return s
+}
+
+// code returns the imported test's target code: the code of the called
+// account, or the init code of a creation transaction.
+func (imp *importedTest) code() ([]byte, error) {
+	if imp.Target == nil {
+		return hexutil.Decode(imp.Tx.Data[0])
+	}
+	return imp.Pre[*imp.Target].Code, nil
+}
+
+// storeImport stores an imported test and its target code, with a record of the
+// code if it is new to the database. It reports whether it was. A code keeps
+// the test it was first imported with: if another test already holds it, imp
+// is not stored and that test is returned as kept.
+func storeImport(db *pebbleDB, imp *importedTest) (isNew bool, kept *importedTest, err error) {
+	code, err := imp.code()
+	if err != nil {
+		return false, nil, err
+	}
+	prev, err := loadImport(db, makeKey(code))
+	if err != nil {
+		return false, nil, err
+	}
+	if prev != nil && (prev.Name != imp.Name || prev.Source != imp.Source) {
+		return false, prev, nil
+	}
+	have, err := hasCode(db, code)
+	if err != nil {
+		return false, nil, err
+	}
+	enc, err := json.Marshal(imp)
+	if err != nil {
+		return false, nil, err
+	}
+	keys, values := [][]byte{importKey(code)}, [][]byte{enc}
+	if !have {
+		// Imported codes are stored as they are, and not executed until they
+		// are replayed or mutated.
+		origin := codeInfo{Source: "import"}
+		keys = append(keys, codeKey(code), infoKey(makeKey(code)))
+		values = append(values, code, origin.record("full", nil))
+	}
+	return !have, nil, db.SetBatch(keys, values)
+}
+
+// loadImport returns the imported test stored for the code with the given key,
+// or nil if the code was not imported.
+func loadImport(db db, key []byte) (*importedTest, error) {
+	enc, err := db.Get(append([]byte(importPrefix), key...))
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	imp := new(importedTest)
+	if err := json.Unmarshal(enc, imp); err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// forEachImport iterates the imported tests in db.
+func forEachImport(db db, fn func(imp *importedTest) error) error {
+	return db.Iterate([]byte(importPrefix), func(key, value []byte) error {
+		imp := new(importedTest)
+		if err := json.Unmarshal(value, imp); err != nil {
+			return fmt.Errorf("corrupt import %x: %w", key, err)
+		}
+		return fn(imp)
+	})
+}
+
+// gstMaker rebuilds a runnable test from the import with code substituted for
+// the target code, so a mutated or minimized program still runs against the
+// pre-state and transaction it was imported with. The environment is
+// GstMaker's default and the fork is the generator's, so imported programs run
+// under the same rules as generated ones.
+func (imp *importedTest) gstMaker(code []byte) *fuzzing.GstMaker {
+	gst := fuzzing.NewGstMaker()
+	gst.EnableFork(generator.Fork())
+	for addr, acc := range imp.Pre {
+		acc.Code = bytes.Clone(acc.Code)
+		acc.Storage = maps.Clone(acc.Storage)
+		if acc.Storage == nil {
+			acc.Storage = make(map[common.Hash]common.Hash)
+		}
+		if acc.Balance == nil {
+			acc.Balance = new(big.Int)
+		}
+		gst.AddAccount(addr, acc)
+	}
+	tx := imp.Tx
+	// Pre-London tests often price gas at 1 wei, below the environment's base
+	// fee; lift them to the price the generator uses.
+	if tx.GasPrice != nil && tx.GasPrice.Cmp(minGasPrice) < 0 {
+		tx.GasPrice = minGasPrice
+	}
+	if imp.Target != nil {
+		gst.SetCode(*imp.Target, bytes.Clone(code))
+	} else {
+		tx.Data = []string{hexutil.Encode(code)}
+	}
+	gst.SetTx(&tx)
+	return gst
+}
+
+// importedChunks returns the target code of every imported test, to seed the
+// generator's verbatim-chunk strategy.
+func importedChunks(db db) ([][]byte, error) {
+	var codes [][]byte
+	err := forEachImport(db, func(imp *importedTest) error {
+		code, err := imp.code()
+		if err != nil {
+			return err
+		}
+		codes = append(codes, code)
+		return nil
+	})
+	return codes, err
+}
+
+// writeChunks writes the imported codes to path, one hex string per line, for
+// the `generate` workers to load. It returns the path, or "" if nothing has been
+// imported.
+func writeChunks(db db, path string) (string, error) {
+	codes, err := importedChunks(db)
+	if err != nil || len(codes) == 0 {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, code := range codes {
+		fmt.Fprintf(&buf, "%x\n", code)
+	}
+	return path, os.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// readChunks loads a file written by writeChunks.
+func readChunks(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var codes [][]byte
+	for _, line := range strings.Fields(string(data)) {
+		code, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
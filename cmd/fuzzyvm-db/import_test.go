@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestImportStateTest imports a state test from an earlier campaign and checks
+// that its target code lands in the corpus, that the pre-state and transaction
+// can be found from the code, and that the rebuilt test executes.
+func TestImportStateTest(t *testing.T) {
+	imported, _, err := readStateTests("../../interesting_inputs/BenchTest-16.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 {
+		t.Fatalf("got %d tests, want 1", len(imported))
+	}
+	imp := imported[0]
+	if imp.Target == nil || imp.Tx.Sender == ([20]byte{}) {
+		t.Fatalf("target %v / sender %v not extracted", imp.Target, imp.Tx.Sender)
+	}
+
+	db, err := createDB(t.TempDir() + "/test.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if isNew, kept, err := storeImport(db, imp); err != nil || !isNew || kept != nil {
+		t.Fatalf("storeImport = (%v, %v, %v), want (true, nil, nil)", isNew, kept, err)
+	}
+	code, err := imp.code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := hasCode(db, code); err != nil || !have {
+		t.Fatalf("target code not stored: (%v, %v)", have, err)
+	}
+	if n := countKeys(db.db); n != 1 {
+		t.Fatalf("countKeys = %d, want 1 (the import record is not a code)", n)
+	}
+	loaded, err := loadImport(db, makeKey(code))
+	if err != nil || loaded == nil {
+		t.Fatalf("loadImport = (%v, %v)", loaded, err)
+	}
+	if err := replayImport(loaded, nil); err != nil {
+		t.Fatalf("replaying import: %v", err)
+	}
+	chunks, err := importedChunks(db)
+	if err != nil || len(chunks) != 1 || !bytes.Equal(chunks[0], code) {
+		t.Fatalf("importedChunks = (%d chunks, %v)", len(chunks), err)
+	}
+}
+
+// TestImportPartialFile imports a file where one test is broken and two share
+// their code: the broken one is skipped, and the code keeps the first test.
+func TestImportPartialFile(t *testing.T) {
+	data, err := os.ReadFile("../../interesting_inputs/BenchTest-16.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+	var test json.RawMessage
+	for _, test = range tests {
+		break // the file holds one test
+	}
+	path := t.TempDir() + "/tests.json"
+	data, err = json.Marshal(map[string]json.RawMessage{"a": test, "b": test, "broken": json.RawMessage(`{"pre":{}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	imported, skipped, err := readStateTests(path)
+	if err != nil || len(imported) != 2 || len(skipped) != 1 {
+		t.Fatalf("readStateTests = (%d tests, %v, %v), want 2 tests and broken skipped", len(imported), skipped, err)
+	}
+
+	db, err := createDB(t.TempDir() + "/test.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if isNew, kept, err := storeImport(db, imported[0]); err != nil || !isNew || kept != nil {
+		t.Fatalf("storing a = (%v, %v, %v), want (true, nil, nil)", isNew, kept, err)
+	}
+	if _, kept, err := storeImport(db, imported[1]); err != nil || kept == nil || kept.Name != "a" {
+		t.Fatalf("storing b = (%v, %v), want a kept", kept, err)
+	}
+	// Importing a test again just rewrites it.
+	if _, kept, err := storeImport(db, imported[0]); err != nil || kept != nil {
+		t.Fatalf("storing a again = (%v, %v), want it stored", kept, err)
+	}
+}
+
+// TestImportRejectsNoCode checks that tests whose target has no code of its
+// own, empty or only a 7702 delegation, are not imported.
+func TestImportRejectsNoCode(t *testing.T) {
+	imported, _, err := readStateTests("../../interesting_inputs/BenchTest-16.json")
+	if err != nil || len(imported) != 1 {
+		t.Fatalf("readStateTests = (%d tests, %v)", len(imported), err)
+	}
+	imp := imported[0]
+	delegation := append([]byte{0xef, 0x01, 0x00}, bytes.Repeat([]byte{0xaa}, 20)...)
+	for _, code := range [][]byte{nil, delegation} {
+		account := imp.Pre[*imp.Target]
+		account.Code = code
+		imp.Pre[*imp.Target] = account
+		_, err := readStateTest(imp.Source, "bad", imp.Pre, imp.Tx)
+		if err == nil || !strings.Contains(err.Error(), `"bad"`) {
+			t.Errorf("code %x: readStateTest error = %v, want one naming the test", code, err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/urfave/cli/v2"
+)
+
+var inspectCommand = &cli.Command{
+	Name:   "inspect",
+	Usage:  "print statistics about an existing database",
+	Action: inspect,
+	Flags: []cli.Flag{
+		dbFlag,
+		serverFlag,
+		secretFlag,
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the statistics as JSON, with every opcode",
+		},
+		&cli.IntFlag{
+			Name:  "top",
+			Usage: "print this many of the most frequent opcodes (0 = all)",
+			Value: 20,
+		},
+	},
+}
+
+// inspectReport holds the statistics of a database.
+type inspectReport struct {
+	Path          string  `json:"path,omitempty"`
+	Server        string  `json:"server,omitempty"`
+	SchemaVersion int     `json:"schemaVersion,omitempty"`
+	DiskUsage     uint64  `json:"diskUsage,omitempty"`
+	Campaign      *uint64 `json:"stored,omitempty"`   // codes the campaign stored so far
+	Received      *uint64 `json:"received,omitempty"` // codes its workers sent
+
+	Codes       int              `json:"codes"`
+	Bytes       int              `json:"bytes"`
+	Imports     int              `json:"imports"`
+	Sizes       []sizeBucket     `json:"sizes"`
+	Opcodes     []opcodeCount    `json:"opcodes"`
+	Precompiles []precompileCall `json:"precompiles"`
+	Forms       map[string]int   `json:"forms"`
+	Shrank      int              `json:"shrank"` // full codes whose minimized form is smaller
+	Sources     map[string]int   `json:"sources"`
+	Growth      []growthBucket   `json:"growth,omitempty"`
+}
+
+// sizeBucket counts the codes of at most Max bytes, and more than the Max of
+// the bucket before.
+type sizeBucket struct {
+	Max   int `json:"max"`
+	Codes int `json:"codes"`
+}
+
+// opcodeCount counts how often an opcode occurs in the stored codes.
+type opcodeCount struct {
+	Op    string `json:"op"`
+	Count int    `json:"count"`
+}
+
+// precompileCall counts the calls to a precompile in the stored codes.
+type precompileCall struct {
+	Address common.Address `json:"address"`
+	Name    string         `json:"name"`
+	Calls   int            `json:"calls"`
+}
+
+// growthBucket counts the codes first seen in the period starting at Start.
+type growthBucket struct {
+	Start time.Time `json:"start"`
+	Added int       `json:"added"`
+	Total int       `json:"total"`
+}
+
+// inspect prints statistics about an existing database.
+func inspect(ctx *cli.Context) error {
+	var (
+		report = new(inspectReport)
+		src    db
+	)
+	if addr := ctx.String(serverFlag.Name); addr != "" {
+		s, err := dialServer(addr, ctx.String(secretFlag.Name))
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		stats, err := s.Stats()
+		if err != nil {
+			return err
+		}
+		report.Server = addr
+		report.Campaign, report.Received = &stats.stored, &stats.received
+		src = s
+	} else {
+		path := ctx.String(dbFlag.Name)
+		// Open read-only so the stats command doesn't create an empty db.
+		db, err := pebble.Open(path, &pebble.Options{ReadOnly: true, ErrorIfNotExists: true})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := checkSchema(db, true); err != nil {
+			return err
+		}
+		report.Path = path
+		report.SchemaVersion = schemaVersion
+		report.DiskUsage = db.Metrics().DiskSpaceUsage()
+		src = &pebbleDB{db: db}
+	}
+	if err := report.collect(src); err != nil {
+		return err
+	}
+	if ctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.print(ctx.Int("top"))
+	return nil
+}
+
+// collect gathers the statistics of the codes, records and imports in db.
+func (r *inspectReport) collect(db db) error {
+	var (
+		sizes       = make(map[int]int)
+		opcodes     = make(map[vm.OpCode]int)
+		precompiles = make(map[common.Address]int)
+	)
+	err := forEachCode(db, 0, func(code []byte) error {
+		r.Codes++
+		r.Bytes += len(code)
+		sizes[sizeClass(len(code))]++
+		countOpcodes(code, opcodes, precompiles)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// The histogram runs from the smallest class with codes to the largest.
+	for max := 1; len(sizes) > 0; max *= 2 {
+		if n := sizes[max]; n > 0 || len(r.Sizes) > 0 {
+			r.Sizes = append(r.Sizes, sizeBucket{Max: max, Codes: n})
+		}
+		delete(sizes, max)
+	}
+	for op, n := range opcodes {
+		r.Opcodes = append(r.Opcodes, opcodeCount{Op: opName(op), Count: n})
+	}
+	slices.SortFunc(r.Opcodes, func(a, b opcodeCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Op, b.Op)
+	})
+	for addr, n := range precompiles {
+		r.Precompiles = append(r.Precompiles, precompileCall{Address: addr, Name: vm.PrecompiledContractsOsaka[addr].Name(), Calls: n})
+	}
+	slices.SortFunc(r.Precompiles, func(a, b precompileCall) int {
+		return bytes.Compare(a.Address[:], b.Address[:])
+	})
+
+	r.Forms, r.Sources = make(map[string]int), make(map[string]int)
+	var seen []time.Time
+	err = db.Iterate([]byte(infoPrefix), func(key, value []byte) error {
+		var info codeInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return fmt.Errorf("corrupt record of %x: %w", codeHash(key), err)
+		}
+		form := info.Form
+		if form == "" {
+			form = "unknown"
+		}
+		r.Forms[form]++
+		if info.Form == "full" && info.Minimized != nil && !bytes.Equal(info.Minimized, codeHash(key)) {
+			r.Shrank++
+		}
+		r.Sources[info.Source]++
+		if !info.FirstSeen.IsZero() {
+			seen = append(seen, info.FirstSeen)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.Growth = growth(seen)
+	return forEachImport(db, func(*importedTest) error { r.Imports++; return nil })
+}
+
+// sizeClass returns the power of two a code of size bytes is counted under:
+// the smallest one that is at least size.
+func sizeClass(size int) int {
+	class := 1
+	for class < size {
+		class *= 2
+	}
+	return class
+}
+
+// countOpcodes counts the opcodes of code into opcodes, skipping push data,
+// and its calls to precompiles into precompiles. The address of a call is
+// known if it was pushed right before the gas, as the generator does.
+func countOpcodes(code []byte, opcodes map[vm.OpCode]int, precompiles map[common.Address]int) {
+	// pushed holds the values pushed since the last opcode that was no push,
+	// nil for the ones GAS pushed.
+	var pushed [][]byte
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+		opcodes[op]++
+		switch {
+		case op.IsPush():
+			end := min(pc+1+int(op-vm.PUSH0), len(code))
+			pushed = append(pushed, code[pc+1:end])
+			pc = end - 1
+		case op == vm.GAS:
+			pushed = append(pushed, nil)
+		case op == vm.CALL || op == vm.CALLCODE || op == vm.DELEGATECALL || op == vm.STATICCALL:
+			if len(pushed) >= 2 {
+				if arg := pushed[len(pushed)-2]; arg != nil && len(arg) <= common.AddressLength {
+					addr := common.BytesToAddress(arg)
+					if _, ok := vm.PrecompiledContractsOsaka[addr]; ok {
+						precompiles[addr]++
+					}
+				}
+			}
+			pushed = pushed[:0]
+		default:
+			pushed = pushed[:0]
+		}
+	}
+}
+
+// opName names an opcode, and an undefined one by its value.
+func opName(op vm.OpCode) string {
+	if name := op.String(); !strings.HasPrefix(name, "opcode ") {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", byte(op))
+}
+
+// growth counts the codes first seen at the given times by day, or by hour if
+// they span less than two days.
+func growth(seen []time.Time) []growthBucket {
+	if len(seen) == 0 {
+		return nil
+	}
+	slices.SortFunc(seen, time.Time.Compare)
+	period := 24 * time.Hour
+	if seen[len(seen)-1].Sub(seen[0]) < 2*period {
+		period = time.Hour
+	}
+	var buckets []growthBucket
+	for i, t := range seen {
+		start := t.UTC().Truncate(period)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+			buckets = append(buckets, growthBucket{Start: start})
+		}
+		buckets[len(buckets)-1].Added++
+		buckets[len(buckets)-1].Total = i + 1
+	}
+	return buckets
+}
+
+// print writes the report as text, with the top most frequent opcodes.
+func (r *inspectReport) print(top int) {
+	if r.Server != "" {
+		fmt.Printf("Reading the campaign serving on %v\n", r.Server)
+		fmt.Printf("Protocol version: %v\n", protocolVersion)
+	} else {
+		fmt.Printf("Reading metrics for %v\n", r.Path)
+		fmt.Printf("Schema version: %v\n", r.SchemaVersion)
+		fmt.Printf("Estimated disk usage: %.2fM\n", float64(r.DiskUsage)/1024/1024)
+	}
+	fmt.Printf("Key count: %v\n", r.Codes)
+	if r.Campaign != nil {
+		fmt.Printf("Stored by the campaign: %v new codes (%v candidates received)\n", *r.Campaign, *r.Received)
+	}
+	fmt.Printf("Imported tests: %v\n", r.Imports)
+	if r.Codes == 0 {
+		return
+	}
+	fmt.Printf("Code bytes: %v (%v on average)\n", r.Bytes, r.Bytes/r.Codes)
+
+	fmt.Println("Code sizes:")
+	for _, b := range r.Sizes {
+		fmt.Printf("  <= %-8d %8d %6s\n", b.Max, b.Codes, share(b.Codes, r.Codes))
+	}
+	fmt.Printf("Forms: %d full (%d of them shrank), %d minimized, %d unknown\n", r.Forms["full"], r.Shrank, r.Forms["minimized"], r.Forms["unknown"])
+	if full := r.Forms["full"]; full > 0 {
+		fmt.Printf("Full to minimized: %.2f minimized codes per full one\n", float64(r.Forms["minimized"])/float64(full))
+	}
+	sources := make([]string, 0, len(r.Sources))
+	for source, n := range r.Sources {
+		sources = append(sources, fmt.Sprintf("%s %d", source, n))
+	}
+	slices.Sort(sources)
+	fmt.Printf("Sources: %s\n", strings.Join(sources, ", "))
+
+	ops := 0
+	for _, c := range r.Opcodes {
+		ops += c.Count
+	}
+	shown := r.Opcodes
+	if top > 0 && len(shown) > top {
+		shown = shown[:top]
+	}
+	fmt.Printf("Opcodes (%d most frequent of %d):\n", len(shown), ops)
+	for _, c := range shown {
+		fmt.Printf("  %-16s %8d %6s\n", c.Op, c.Count, share(c.Count, ops))
+	}
+	if len(r.Precompiles) > 0 {
+		fmt.Println("Precompile calls:")
+		for _, p := range r.Precompiles {
+			fmt.Printf("  %-6s %-20s %8d\n", shortAddress(p.Address), p.Name, p.Calls)
+		}
+	}
+	if len(r.Growth) > 0 {
+		fmt.Println("Growth:")
+		for _, g := range r.Growth {
+			fmt.Printf("  %s %8d %8d total\n", g.Start.Format("2006-01-02 15:04"), g.Added, g.Total)
+		}
+	}
+}
+
+// share formats n as a percentage of total.
+func share(n, total int) string {
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// shortAddress formats a precompile address without its leading zeros.
+func shortAddress(addr common.Address) string {
+	return fmt.Sprintf("%#x", new(big.Int).SetBytes(addr[:]))
+}
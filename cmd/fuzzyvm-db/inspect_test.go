@@ -0,0 +1,98 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// TestCountOpcodes checks that push data isn't counted as opcodes and that
+// calls count toward the precompile their address was pushed for.
+func TestCountOpcodes(t *testing.T) {
+	for _, tt := range []struct {
+		code        string
+		opcodes     map[vm.OpCode]int
+		precompiles map[common.Address]int
+	}{
+		// PUSH2 0x6001 STOP: the data looks like PUSH1 1.
+		{"0x61600100", map[vm.OpCode]int{vm.PUSH2: 1, vm.STOP: 1}, nil},
+		// A PUSH2 cut short by the end of the code.
+		{"0x6160", map[vm.OpCode]int{vm.PUSH2: 1}, nil},
+		// PUSH1 0 DUP1 DUP1 DUP1 DUP1 PUSH1 2 GAS CALL: sha256.
+		{"0x60008080808060025af1", map[vm.OpCode]int{vm.PUSH1: 2, vm.DUP1: 4, vm.GAS: 1, vm.CALL: 1},
+			map[common.Address]int{common.BytesToAddress([]byte{2}): 1}},
+		// PUSH2 0x0100 PUSH3 0xffffff STATICCALL: P256VERIFY.
+		{"0x61010062fffffffa", map[vm.OpCode]int{vm.PUSH2: 1, vm.PUSH3: 1, vm.STATICCALL: 1},
+			map[common.Address]int{common.BytesToAddress([]byte{1, 0}): 1}},
+		// PUSH1 2 CALLER GAS CALL: the address is no constant.
+		{"0x6002335af1", map[vm.OpCode]int{vm.PUSH1: 1, vm.CALLER: 1, vm.GAS: 1, vm.CALL: 1}, nil},
+		// PUSH1 0x30 GAS DELEGATECALL: no precompile lives at 0x30.
+		{"0x60305af4", map[vm.OpCode]int{vm.PUSH1: 1, vm.GAS: 1, vm.DELEGATECALL: 1}, nil},
+	} {
+		opcodes, precompiles := make(map[vm.OpCode]int), make(map[common.Address]int)
+		countOpcodes(hexutil.MustDecode(tt.code), opcodes, precompiles)
+		if !maps.Equal(opcodes, tt.opcodes) {
+			t.Errorf("%v: opcodes %v, want %v", tt.code, opcodes, tt.opcodes)
+		}
+		if len(precompiles) != 0 || len(tt.precompiles) != 0 {
+			if !maps.Equal(precompiles, tt.precompiles) {
+				t.Errorf("%v: precompile calls %v, want %v", tt.code, precompiles, tt.precompiles)
+			}
+		}
+	}
+}
+
+// TestInspectReport collects the statistics of a small database.
+func TestInspectReport(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var (
+		// PUSH1 0 DUP1 DUP1 DUP1 DUP1 PUSH1 1 GAS CALL STOP
+		full = hexutil.MustDecode("0x60008080808060015af100")
+		// STOP
+		minimized = hexutil.MustDecode("0x00")
+		origin    = codeInfo{Source: "generate"}
+		shrunk    = codeInfo{Source: "generate", Minimized: makeKey(minimized)}
+	)
+	for _, tt := range []struct {
+		code, record []byte
+	}{
+		{full, shrunk.record("full", nil)},
+		{minimized, origin.record("minimized", nil)},
+	} {
+		if err := db.SetBatch([][]byte{codeKey(tt.code), infoKey(makeKey(tt.code))}, [][]byte{tt.code, tt.record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := new(inspectReport)
+	if err := r.collect(db); err != nil {
+		t.Fatal(err)
+	}
+	if r.Codes != 2 || r.Bytes != len(full)+len(minimized) {
+		t.Errorf("counted %d codes of %d bytes", r.Codes, r.Bytes)
+	}
+	wantSizes := []sizeBucket{{1, 1}, {2, 0}, {4, 0}, {8, 0}, {16, 1}}
+	if !slices.Equal(r.Sizes, wantSizes) {
+		t.Errorf("sizes %v, want %v", r.Sizes, wantSizes)
+	}
+	if r.Opcodes[0] != (opcodeCount{"DUP1", 4}) || r.Opcodes[1] != (opcodeCount{"PUSH1", 2}) || r.Opcodes[2] != (opcodeCount{"STOP", 2}) {
+		t.Errorf("most frequent opcodes %v", r.Opcodes[:3])
+	}
+	if len(r.Precompiles) != 1 || r.Precompiles[0].Name != "ECREC" || r.Precompiles[0].Calls != 1 {
+		t.Errorf("precompile calls %+v", r.Precompiles)
+	}
+	if r.Forms["full"] != 1 || r.Forms["minimized"] != 1 || r.Shrank != 1 || r.Sources["generate"] != 2 {
+		t.Errorf("forms %v (%d shrank), sources %v", r.Forms, r.Shrank, r.Sources)
+	}
+	if len(r.Growth) != 1 || r.Growth[0].Added != 2 || r.Growth[0].Total != 2 {
+		t.Errorf("growth %+v", r.Growth)
+	}
+}
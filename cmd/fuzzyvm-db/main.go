@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -12,13 +13,18 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/MariusVanDerWijden/FuzzyVM/filler"
 	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
 	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/sink"
 	"github.com/cockroachdb/pebble"
+	"github.com/holiman/goevmlab/evms"
+	"github.com/holiman/goevmlab/fuzzing"
 	"github.com/urfave/cli/v2"
 )
 
@@ -36,6 +42,22 @@ const (
 	// flag to the workers through it (the workers, not the parent, do the
 	// generating).
 	debugEnvKey = "FUZZYVM_DEBUG"
+	// chunksEnvKey names the env var carrying the path of a file with the
+	// imported codes (one hex string per line). `generate` writes it so the
+	// workers' verbatim-chunk strategy can draw from imported tests, which the
+	// workers can't read from the database themselves.
+	chunksEnvKey = "FUZZYVM_CHUNKS"
+	// budgetEnvKey and gasBudgetEnvKey carry the --budget and --gas-budget of
+	// `generate` to the workers, and quarantineEnvKey the directory the inputs
+	// that run over them are saved to.
+	budgetEnvKey     = "FUZZYVM_BUDGET"
+	gasBudgetEnvKey  = "FUZZYVM_GAS_BUDGET"
+	quarantineEnvKey = "FUZZYVM_QUARANTINE"
+	// serverEnvKey names the env var carrying the TCP address of a remote
+	// server, which `worker` passes to its FuzzEVM workers instead of a
+	// socket, and secretEnvKey the secret they authenticate with.
+	serverEnvKey = "FUZZYVM_SERVER"
+	secretEnvKey = "FUZZYVM_SECRET"
 )
 
 // debugFlag enables logging of the chosen generation strategies to the console.
@@ -50,11 +72,46 @@ var dbFlag = &cli.StringFlag{
 	Value: defaultDBFile,
 }
 
-var inspectCommand = &cli.Command{
-	Name:   "inspect",
-	Usage:  "print statistics about an existing database",
-	Action: inspect,
-	Flags:  []cli.Flag{dbFlag},
+// sinkFlag selects where the state tests of newly stored programs go.
+var sinkFlag = &cli.StringFlag{
+	Name:  "sink",
+	Usage: "also store the state test of every new program: dir:<path>, pebble (this database), pebble:<path>, tar:<prefix> (rolling .tar.zst archives) or ndjson (stdout)",
+}
+
+// evmFlag names external EVMs every newly stored program is run on.
+var evmFlag = &cli.StringSliceFlag{
+	Name:  "evm",
+	Usage: "external EVM to run every new program on, as kind=/path/to/binary (kind is geth, besu, erigon, evmone, eels, nethermind, nimbus or revme)",
+}
+
+// budgetFlag and gasBudgetFlag bound what one input may cost the workers.
+var (
+	budgetFlag = &cli.DurationFlag{
+		Name:  "budget",
+		Usage: "time one input may take to generate and minimize before it is aborted and quarantined (0 = no limit)",
+		Value: fuzzer.DefaultBudget.Time,
+	}
+	gasBudgetFlag = &cli.Uint64Flag{
+		Name:  "gas-budget",
+		Usage: "gas all executions of one input may use before it is aborted and quarantined (0 = no limit)",
+		Value: fuzzer.DefaultBudget.Gas,
+	}
+)
+
+// secretFlag is the secret remote workers authenticate with. It is best passed
+// through the environment, where other users can't read it off the process
+// list.
+var secretFlag = &cli.StringFlag{
+	Name:    "secret",
+	Usage:   "shared secret of the server and its remote workers",
+	EnvVars: []string{secretEnvKey},
+}
+
+// serverFlag points a command reading the database at a running campaign,
+// which holds the database locked.
+var serverFlag = &cli.StringFlag{
+	Name:  "server",
+	Usage: "read the database of a running generate campaign instead: the path of its socket, or the TCP address it accepts remote workers on (needs --secret)",
 }
 
 var generateCommand = &cli.Command{
@@ -74,10 +131,31 @@ var generateCommand = &cli.Command{
 			Usage: "how long to fuzz for (0 = until interrupted)",
 			Value: 0,
 		},
+		budgetFlag,
+		gasBudgetFlag,
+		evmFlag,
+		sinkFlag,
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "also accept remote workers (fuzzyvm-db worker) on this TCP address, e.g. :7777; requires --secret; only the handshake is authenticated, so only listen on a trusted network",
+		},
+		secretFlag,
 		debugFlag,
 	},
 }
 
+// externalVMs, crashDir, testSink, budget and quarantineDir are set in the
+// FuzzEVM workers from the environment `generate` passes down; discrepancies
+// found on externalVMs go to crashDir, the tests of new programs to testSink,
+// and the inputs that run over budget to quarantineDir.
+var (
+	externalVMs   []evms.Evm
+	crashDir      string
+	testSink      sink.Sink
+	budget        = fuzzer.DefaultBudget
+	quarantineDir string
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "fuzzyvm-db"
@@ -85,7 +163,15 @@ func main() {
 	app.Commands = []*cli.Command{
 		inspectCommand,
 		generateCommand,
+		mutateCommand,
+		importCommand,
 		replayCommand,
+		whocoversCommand,
+		exportCommand,
+		mergeCommand,
+		distillCommand,
+		migrateCommand,
+		workerCommand,
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
@@ -99,24 +185,6 @@ func socketAddr() string {
 	return os.Getenv(sockEnvKey)
 }
 
-// inspect prints statistics about an existing database.
-func inspect(ctx *cli.Context) error {
-	path := ctx.String(dbFlag.Name)
-	// Open read-only so the stats command neither creates an empty db
-	// nor contends with a running fuzzer for the directory lock.
-	db, err := pebble.Open(path, &pebble.Options{ReadOnly: true, ErrorIfNotExists: true})
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	metrics := db.Metrics()
-	fmt.Printf("Reading metrics for %v\n", path)
-	fmt.Printf("Estimated disk usage: %.2fM\n", float64(metrics.DiskSpaceUsage())/1024/1024)
-	fmt.Printf("Key count: %v\n", countKeys(db))
-	return nil
-}
-
 // generate runs coverage-guided fuzzing that fills the database with EVM
 // bytecodes, scaling across CPU cores.
 //
@@ -140,6 +208,22 @@ func generate(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	evmSpecs := ctx.StringSlice(evmFlag.Name)
+	if _, err := fuzzer.ParseEVMs(evmSpecs); err != nil {
+		return err
+	}
+	// Discrepancies found on external EVMs go next to the database.
+	crashes := filepath.Join(filepath.Dir(dbPath), "crashes")
+	if len(evmSpecs) > 0 {
+		if err := os.MkdirAll(crashes, 0755); err != nil {
+			return err
+		}
+	}
+	// So do the inputs that run over budget.
+	quarantine := filepath.Join(filepath.Dir(dbPath), "quarantine")
+	if err := os.MkdirAll(quarantine, 0755); err != nil {
+		return err
+	}
 	pkgDir, err := packageDir()
 	if err != nil {
 		return err
@@ -165,28 +249,67 @@ func generate(ctx *cli.Context) error {
 		return err
 	}
 
+	chunks, err := writeChunks(pdb, filepath.Join(sockDir, "chunks.txt"))
+	if err != nil {
+		pdb.Close()
+		return err
+	}
+
 	srv := newServer(pdb, ln)
 	go srv.serve()
-
-	args := []string{
-		"test",
-		"-run=^$",         // don't run unit tests, only fuzz
-		"-fuzz=^FuzzEVM$", // the harness that fills the db
-		fmt.Sprintf("-parallel=%d", procs),
-		// Raise the per-input hang deadline: some generated programs (heavy
-		// BLS/KZG precompile calls, then minimization) legitimately take a while,
-		// and the default would flag them as hangs.
-		"-timeout=30s",
+	// The database is locked while we run, so this is how to read it.
+	log.Printf("Serving the database on %v", sockPath)
+	if addr := ctx.String("listen"); addr != "" {
+		tcpLn, err := listenRemote(addr, ctx.String(secretFlag.Name))
+		if err != nil {
+			srv.shutdown()
+			pdb.Close()
+			return err
+		}
+		srv.listen(tcpLn, []byte(ctx.String(secretFlag.Name)))
+		log.Printf("Accepting remote workers on %v", tcpLn.Addr())
 	}
-	if d := ctx.Duration("time"); d > 0 {
-		args = append(args, fmt.Sprintf("-fuzztime=%s", d))
+
+	// The workers send the tests of new programs to the sink over a socket of
+	// its own.
+	var (
+		sinkSrv  *sink.Server
+		sinkPath string
+	)
+	if spec := ctx.String(sinkFlag.Name); spec != "" {
+		out, err := openSink(spec, pdb)
+		if err != nil {
+			srv.shutdown()
+			pdb.Close()
+			return err
+		}
+		sinkPath = filepath.Join(sockDir, "sink.sock")
+		sinkLn, err := net.Listen("unix", sinkPath)
+		if err != nil {
+			srv.shutdown()
+			out.Close()
+			pdb.Close()
+			return err
+		}
+		sinkSrv = sink.Serve(sinkLn, out)
+		defer out.Close()
 	}
-	args = append(args, pkgDir)
 
-	cmd := exec.Command("go", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := fuzzCommand(pkgDir, procs, ctx.Duration("time"))
 	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", sockEnvKey, sockPath))
+	cmd.Env = append(cmd.Env, budgetEnv(ctx, quarantine)...)
+	if chunks != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", chunksEnvKey, chunks))
+	}
+	if sinkPath != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", fuzzer.SinkEnvKey, sinkPath))
+	}
+	if len(evmSpecs) > 0 {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("%s=%s", fuzzer.EVMEnvKey, strings.Join(evmSpecs, ",")),
+			fmt.Sprintf("%s=%s", fuzzer.CrashEnvKey, crashes),
+		)
+	}
 	if ctx.Bool(debugFlag.Name) {
 		// The workers, not this process, do the generating, so pass the flag
 		// down. With multiple parallel workers the strategy logs will interleave.
@@ -199,15 +322,8 @@ func generate(ctx *cli.Context) error {
 	// sequence below, which flushes the database. Without this, the default
 	// SIGINT action would kill us before pdb.Close() runs and every unsynced
 	// (NoSync) write would be lost.
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	defer signal.Stop(sigCh)
-	var interrupted atomic.Bool
-	go func() {
-		if _, ok := <-sigCh; ok {
-			interrupted.Store(true)
-		}
-	}()
+	interrupted, stop := catchInterrupt()
+	defer stop()
 
 	fmt.Printf("Fuzzing into %v with %d workers\n", dbPath, procs)
 	err = cmd.Run()
@@ -216,10 +332,13 @@ func generate(ctx *cli.Context) error {
 	// closes the listener and waits for every connection handler to finish, so
 	// no PUT is still writing when we Close the db.
 	srv.shutdown()
+	if sinkSrv != nil {
+		sinkSrv.Shutdown()
+	}
 	if cerr := pdb.Close(); cerr != nil && err == nil {
 		err = cerr
 	}
-	fmt.Printf("Stored %d new codes (%d candidates received)\n", srv.stored.Load(), srv.received.Load())
+	log.Printf("Stored %d new codes (%d candidates received)", srv.stored.Load(), srv.received.Load())
 	if interrupted.Load() {
 		// A user-initiated Ctrl-C is a clean stop, not a failure. (A genuine
 		// fuzz-found crash exits without a signal, so its error still surfaces.)
@@ -228,18 +347,80 @@ func generate(ctx *cli.Context) error {
 	return err
 }
 
+// fuzzCommand returns the `go test -fuzz` run of the FuzzEVM harness in pkgDir
+// with procs workers, for d if non-zero. Its env is left for the caller to
+// fill in.
+func fuzzCommand(pkgDir string, procs int, d time.Duration) *exec.Cmd {
+	args := []string{
+		"test",
+		"-run=^$",         // don't run unit tests, only fuzz
+		"-fuzz=^FuzzEVM$", // the harness that fills the db
+		fmt.Sprintf("-parallel=%d", procs),
+		// The workers abort inputs that run over --budget themselves; this is
+		// only the backstop for a worker that hangs outside of the EVM.
+		"-timeout=30s",
+	}
+	if d > 0 {
+		args = append(args, fmt.Sprintf("-fuzztime=%s", d))
+	}
+	args = append(args, pkgDir)
+
+	cmd := exec.Command("go", args...)
+	// The ndjson sink owns stdout.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// budgetEnv passes the budget flags and the quarantine directory down to the
+// FuzzEVM workers.
+func budgetEnv(ctx *cli.Context, quarantine string) []string {
+	return []string{
+		fmt.Sprintf("%s=%s", budgetEnvKey, ctx.Duration(budgetFlag.Name)),
+		fmt.Sprintf("%s=%d", gasBudgetEnvKey, ctx.Uint64(gasBudgetFlag.Name)),
+		fmt.Sprintf("%s=%s", quarantineEnvKey, quarantine),
+	}
+}
+
+// catchInterrupt catches Ctrl-C and SIGTERM until stop is called, and records
+// whether one arrived.
+func catchInterrupt() (interrupted *atomic.Bool, stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	interrupted = new(atomic.Bool)
+	go func() {
+		if _, ok := <-sigCh; ok {
+			interrupted.Store(true)
+		}
+	}()
+	return interrupted, func() { signal.Stop(sigCh) }
+}
+
+// packagePath is the import path of this command.
+const packagePath = "github.com/MariusVanDerWijden/FuzzyVM/cmd/fuzzyvm-db"
+
 // packageDir returns the directory of this command's Go package, so `go test`
 // can be pointed at it from any working directory.
 func packageDir() (string, error) {
-	out, err := exec.Command("go", "list", "-f", "{{.Dir}}", "github.com/MariusVanDerWijden/FuzzyVM/cmd/fuzzyvm-db").Output()
+	out, err := exec.Command("go", "list", "-f", "{{.Dir}}", packagePath).Output()
 	if err != nil {
 		return "", fmt.Errorf("locating fuzzyvm-db package (is the Go toolchain available?): %w", err)
 	}
 	return string(bytes.TrimSpace(out)), nil
 }
 
+// openSink opens the sink spec selects. A bare "pebble" stores the tests in
+// the campaign database itself, which no second handle could open; their keys
+// are outside of codePrefix, so they don't count as codes.
+func openSink(spec string, pdb *pebbleDB) (sink.Sink, error) {
+	if spec == "pebble" {
+		return sink.NewPebble(pdb.db), nil
+	}
+	return sink.Open(spec)
+}
+
 func countKeys(db *pebble.DB) int {
-	iter, err := db.NewIter(&pebble.IterOptions{})
+	iter, err := db.NewIter(prefixRange([]byte(codePrefix)))
 	if err != nil {
 		panic(err)
 	}
@@ -251,11 +432,17 @@ func countKeys(db *pebble.DB) int {
 	return keys
 }
 
+// createDB opens the database at file read-write, creating it with the current
+// schema if missing.
 func createDB(file string) (*pebbleDB, error) {
 	pdb, err := pebble.Open(file, &pebble.Options{})
 	if err != nil {
 		return nil, err
 	}
+	if err := checkSchema(pdb, false); err != nil {
+		pdb.Close()
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
 	return &pebbleDB{db: pdb}, nil
 }
 
@@ -264,8 +451,14 @@ func makeKey(code []byte) []byte {
 	return hash[:]
 }
 
+// db is a database of codes: a pebble handle of its own, or the one of a
+// running campaign through its server.
 type db interface {
+	Has(key []byte) (bool, error)
 	Get(key []byte) ([]byte, error)
+	// Iterate calls fn for every key with the given prefix, in order, until fn
+	// returns an error. key and value are only valid during the call.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
 	Set(key, value []byte) error
 	SetBatch(keys, values [][]byte) error
 	Close() error
@@ -275,6 +468,10 @@ type pebbleDB struct {
 	db *pebble.DB
 }
 
+func (db *pebbleDB) Has(key []byte) (bool, error) {
+	return hasKey(db.db, key)
+}
+
 func (db *pebbleDB) Get(key []byte) ([]byte, error) {
 	val, closer, err := db.db.Get(key)
 	if err != nil {
@@ -285,6 +482,20 @@ func (db *pebbleDB) Get(key []byte) ([]byte, error) {
 	return bytes.Clone(val), nil
 }
 
+func (db *pebbleDB) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	iter, err := db.db.NewIter(prefixRange(prefix))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
 func (db *pebbleDB) Set(key, value []byte) error {
 	return db.db.Set(key, value, pebble.NoSync)
 }
@@ -310,17 +521,24 @@ func isNotFound(err error) bool {
 	return errors.Is(err, pebble.ErrNotFound)
 }
 
-func hasCode(db db, code []byte) (bool, error) {
-	if _, err := db.Get(makeKey(code)); isNotFound(err) {
+// hasKey reports whether r holds key.
+func hasKey(r pebble.Reader, key []byte) (bool, error) {
+	_, closer, err := r.Get(key)
+	if isNotFound(err) {
 		return false, nil
 	} else if err != nil {
 		return false, err
 	}
+	closer.Close()
 	return true, nil
 }
 
+func hasCode(db db, code []byte) (bool, error) {
+	return db.Has(codeKey(code))
+}
+
 func putCode(db db, code []byte) error {
-	return db.Set(makeKey(code), code)
+	return db.Set(codeKey(code), code)
 }
 
 func run(db db, input []byte) error {
@@ -328,35 +546,122 @@ func run(db db, input []byte) error {
 	if len(input) < 32 {
 		return nil
 	}
-	f := filler.NewFiller(input)
-	gst, bytecode := generator.GenerateProgram(f)
-	if have, err := hasCode(db, bytecode); err != nil {
+	var (
+		gst         *fuzzing.GstMaker
+		bytecode    []byte
+		invocations []generator.Invocation
+		isNew       bool
+	)
+	// Generating and minimizing a program is bounded by budget, so that a
+	// pathological input is set aside rather than taking the worker down.
+	w, err := fuzzer.Watched(budget, "generate", func(w *fuzzer.Watch) error {
+		gst, bytecode, invocations = generator.RecordProgram(filler.NewFiller(input))
+		w.Phase("minimize")
+		var err error
+		isNew, err = storeProgram(db, gst, bytecode, codeInfo{Source: "generate", Input: input})
+		return err
+	})
+	if errors.Is(err, fuzzer.ErrBudgetExceeded) {
+		log.Printf("input %x exceeded its %v budget after %v executions", makeKey(input), w.Exceeded, w.Executions)
+		if quarantineDir != "" {
+			return fuzzer.Quarantine(quarantineDir, input, w)
+		}
+		return nil
+	}
+	if err != nil || !isNew {
+		return err
+	}
+	name := fmt.Sprintf("FuzzyVM-%x", makeKey(bytecode))
+	if testSink != nil {
+		if err := storeTest(gst, name, makeKey(bytecode), input, invocations, bytecode); err != nil {
+			log.Printf("could not store test %v: %v", name, err)
+		}
+	}
+	if len(externalVMs) == 0 {
+		return nil
+	}
+	// Only new programs are worth the external runs; gst now holds the
+	// minimized form, which makes for shorter traces to compare.
+	if _, err := fuzzer.RunExternal(externalVMs, crashDir, gst.ToGeneralStateTest(name), name, input); err != nil {
+		log.Printf("could not run external evms: %v", err)
+	}
+	return nil
+}
+
+// storeTest sends the state test of a new program to testSink, together with
+// the provenance of the program generated from input.
+func storeTest(gst *fuzzing.GstMaker, name string, hash, input []byte, invocations []generator.Invocation, bytecode []byte) error {
+	test := gst.ToGeneralStateTest(name)
+	data, err := json.Marshal(test)
+	if err != nil {
 		return err
+	}
+	meta, err := json.Marshal(fuzzer.NewProvenance(input, invocations, bytecode, test, name))
+	if err != nil {
+		return err
+	}
+	_, err = testSink.Store(sink.Test{Name: name, Hash: hash, JSON: data, Meta: meta})
+	return err
+}
+
+// storeProgram minimizes a program and stores it together with its minimized
+// form, unless the database already has it. It is shared by every way of
+// producing programs (generation, mutation), so they all dedupe and minimize
+// identically. Every code stored gets a record of where it came from, origin,
+// linked to the record of its other form. It reports whether the program was
+// new; gst is minimized in place.
+func storeProgram(db db, gst *fuzzing.GstMaker, bytecode []byte, origin codeInfo) (bool, error) {
+	if have, err := hasCode(db, bytecode); err != nil {
+		return false, err
 	} else if have {
 		// already have this code in our db, skip
-		return nil
+		return false, nil
 	}
-	_, minCode, err := fuzzer.MinimizeProgram(gst)
+	_, minCode, run, err := fuzzer.MinimizeProgramSummarized(gst)
+	return storeMinimized(db, bytecode, origin, minCode, run, err)
+}
+
+// storeMinimized is storeProgram for a program new to db, which
+// fuzzer.MinimizeProgramSummarized minimized to minCode, with the summary run,
+// or failed to with err.
+func storeMinimized(db db, bytecode []byte, origin codeInfo, minCode []byte, run *fuzzer.ExecSummary, err error) (bool, error) {
 	if errors.Is(err, fuzzer.ErrTraceTooLarge) {
 		// The trace is too large to run, so there's no way to minimize it.
-		// Still worth keeping: store the full bytecode as-is.
-		return putCode(db, bytecode)
+		// Still worth keeping: store the full bytecode as-is, without the
+		// summary of yet another long execution.
+		return true, db.SetBatch(
+			[][]byte{codeKey(bytecode), infoKey(makeKey(bytecode))},
+			[][]byte{bytecode, origin.record("full", nil)},
+		)
 	} else if err != nil {
 		// A program that fails to minimize is not worth stopping a campaign for.
 		log.Printf("skipping program that failed to minimize: %v", err)
-		return nil
+		return false, nil
+	}
+	// The minimized program executes the same instructions as the full one,
+	// so the summary holds for both.
+	summary := (*execSummary)(run)
+	full := origin
+	full.Minimized = makeKey(minCode)
+	keys := [][]byte{codeKey(bytecode), infoKey(makeKey(bytecode))}
+	values := [][]byte{bytecode, full.record("full", summary)}
+	if bytes.Equal(minCode, bytecode) {
+		return true, db.SetBatch(keys, values)
 	}
 	if have, err := hasCode(db, minCode); err != nil {
-		return err
+		return false, err
 	} else if have {
-		// a different program already minimized to this code
-		return putCode(db, bytecode)
+		// a different program already minimized to this code, whose record
+		// keeps pointing to that one
+		return true, db.SetBatch(keys, values)
 	}
 	// Store both codes atomically so a failure between the writes can't leave
 	// the full code present (and thus skipped forever) without its minimized
 	// counterpart.
-	return db.SetBatch(
-		[][]byte{makeKey(bytecode), makeKey(minCode)},
-		[][]byte{bytecode, minCode},
+	minimized := origin
+	minimized.Full = makeKey(bytecode)
+	return true, db.SetBatch(
+		append(keys, codeKey(minCode), infoKey(makeKey(minCode))),
+		append(values, minCode, minimized.record("minimized", summary)),
 	)
 }
@@ -3,11 +3,17 @@ package main
 import (
 	"errors"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
 	"github.com/MariusVanDerWijden/FuzzyVM/generator"
 	"github.com/MariusVanDerWijden/FuzzyVM/generator/precompiles"
+	"github.com/MariusVanDerWijden/FuzzyVM/sink"
 )
 
 // The db handle is created lazily inside the fuzz callback. Under `generate`,
@@ -29,8 +35,52 @@ func FuzzEVM(f *testing.F) {
 			if os.Getenv(debugEnvKey) == "1" {
 				generator.Debug = true
 			}
-			// Connect to the server.
-			if addr := socketAddr(); addr != "" {
+			// Imported codes feed the verbatim-chunk strategy.
+			if path := os.Getenv(chunksEnvKey); path != "" {
+				codes, err := readChunks(path)
+				if err != nil {
+					panic(err)
+				}
+				generator.AddVerbatimChunks(codes...)
+			}
+			// `generate --evm` propagates through these env vars.
+			if specs := os.Getenv(fuzzer.EVMEnvKey); specs != "" {
+				vms, err := fuzzer.ParseEVMs(strings.Split(specs, ","))
+				if err != nil {
+					panic(err)
+				}
+				externalVMs = vms
+				crashDir = os.Getenv(fuzzer.CrashEnvKey)
+			}
+			// `generate --sink` propagates through this env var.
+			if addr := os.Getenv(fuzzer.SinkEnvKey); addr != "" {
+				remote, err := sink.Dial(addr)
+				if err != nil {
+					panic(err)
+				}
+				testSink = remote
+			}
+			// `generate --budget --gas-budget` propagate through these env vars.
+			if d := os.Getenv(budgetEnvKey); d != "" {
+				t, err := time.ParseDuration(d)
+				if err != nil {
+					panic(err)
+				}
+				budget.Time = t
+			}
+			if gas := os.Getenv(gasBudgetEnvKey); gas != "" {
+				g, err := strconv.ParseUint(gas, 10, 64)
+				if err != nil {
+					panic(err)
+				}
+				budget.Gas = g
+			}
+			quarantineDir = os.Getenv(quarantineEnvKey)
+			// Connect to the server: a remote one for `worker`, else the one
+			// `generate` runs.
+			if addr := os.Getenv(serverEnvKey); addr != "" {
+				fuzzDB = dialRemoteDB(addr, []byte(os.Getenv(secretEnvKey)))
+			} else if addr := socketAddr(); addr != "" {
 				db, err := dialSocketDB(addr)
 				if err != nil {
 					panic(err)
@@ -86,3 +136,31 @@ func TestRepro(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestQuarantine checks that an input that runs over its budget is set aside
+// in the quarantine rather than failing or being stored.
+func TestQuarantine(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/test.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer func(b fuzzer.Budget, dir string) { budget, quarantineDir = b, dir }(budget, quarantineDir)
+	budget, quarantineDir = fuzzer.Budget{Gas: 1}, t.TempDir()
+
+	input := []byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf")
+	if err := run(db, input); err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := fuzzer.ReadQuarantine(quarantineDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || string(inputs[0].Seed) != string(input) || inputs[0].Watch.Exceeded != "gas" {
+		t.Fatalf("unexpected quarantine %+v", inputs)
+	}
+	_, code, _ := generator.RecordProgram(filler.NewFiller(input))
+	if have, err := hasCode(db, code); err != nil || have {
+		t.Errorf("quarantined program stored: %v, %v", have, err)
+	}
+}
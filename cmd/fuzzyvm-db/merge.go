@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/urfave/cli/v2"
+)
+
+var mergeCommand = &cli.Command{
+	Name:      "merge",
+	Usage:     "merge the codes of databases from other campaigns into one",
+	ArgsUsage: "<source db>...",
+	Action:    merge,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "into",
+			Usage:    "path to the database to merge into, created if missing",
+			Required: true,
+		},
+	},
+}
+
+// mergeStats is what merging one source contributed.
+type mergeStats struct {
+	codes int // codes in the source
+	added int // codes the destination did not have yet
+	// unique counts the codes neither the destination had before the merge
+	// nor any other source has.
+	unique int
+}
+
+// merge copies the codes of every source database into the destination, with
+// their records and imported tests. A code the destination already has keeps
+// its records there.
+func merge(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return fmt.Errorf("no databases to merge given")
+	}
+	into, err := filepath.Abs(ctx.String("into"))
+	if err != nil {
+		return err
+	}
+	var sources []*pebble.DB
+	defer func() {
+		for _, db := range sources {
+			db.Close()
+		}
+	}()
+	for _, path := range ctx.Args().Slice() {
+		if abs, err := filepath.Abs(path); err != nil {
+			return err
+		} else if abs == into {
+			return fmt.Errorf("cannot merge %v into itself", path)
+		}
+		db, err := openCorpus(path)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, db)
+	}
+	pdb, err := createDB(into)
+	if err != nil {
+		return err
+	}
+	defer pdb.Close()
+
+	stats, err := mergeDBs(pdb.db, sources)
+	for i, s := range stats {
+		fmt.Printf("%v: %d codes, %d new, %d unique\n", ctx.Args().Get(i), s.codes, s.added, s.unique)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged into %v, which now holds %d codes\n", into, countKeys(pdb.db))
+	return nil
+}
+
+// mergeDBs merges sources into dst in order, and returns what each
+// contributed.
+func mergeDBs(dst *pebble.DB, sources []*pebble.DB) ([]mergeStats, error) {
+	// Uniqueness is judged against the destination as it was, so it doesn't
+	// depend on the order the sources are merged in.
+	before := dst.NewSnapshot()
+	defer before.Close()
+	stats := make([]mergeStats, len(sources))
+	for i, src := range sources {
+		err := mergeDB(dst, src, &stats[i], func(key []byte) (bool, error) {
+			if ok, err := hasKey(before, key); ok || err != nil {
+				return false, err
+			}
+			for j, other := range sources {
+				if j == i {
+					continue
+				}
+				if ok, err := hasKey(other, key); ok || err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return stats[:i+1], err
+		}
+	}
+	return stats, nil
+}
+
+// mergeDB copies the codes of src dst does not have into it, each with its
+// record and imported test, and counts them into stats.
+func mergeDB(dst, src *pebble.DB, stats *mergeStats, unique func(key []byte) (bool, error)) error {
+	batch := dst.NewBatch()
+	defer batch.Close()
+	records := &pebbleDB{db: src}
+	err := forEachCode(records, 0, func(code []byte) error {
+		stats.codes++
+		hash := makeKey(code)
+		key := codeKey(code)
+		if ok, err := unique(key); err != nil {
+			return err
+		} else if ok {
+			stats.unique++
+		}
+		if ok, err := hasKey(dst, key); ok || err != nil {
+			return err
+		}
+		if err := batch.Set(key, code, nil); err != nil {
+			return err
+		}
+		if err := copyRecords(batch, records, hash); err != nil {
+			return err
+		}
+		if stats.added++; stats.added%batchSize == 0 {
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// copyRecords adds the record and the imported test of the code with the given
+// hash in src, those it has, to batch.
+func copyRecords(batch *pebble.Batch, src db, hash []byte) error {
+	for _, key := range [][]byte{infoKey(hash), append([]byte(importPrefix), hash...)} {
+		val, err := src.Get(key)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := batch.Set(key, val, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
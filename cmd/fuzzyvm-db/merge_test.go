@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// TestMerge merges two campaigns into a database that shares a code with each,
+// and checks the codes, records and imports that arrive, and what each source
+// is reported to have contributed.
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string, codes ...string) *pebbleDB {
+		t.Helper()
+		db, err := createDB(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range codes {
+			origin := codeInfo{Source: name}
+			if err := db.SetBatch(
+				[][]byte{codeKey([]byte(code)), infoKey(makeKey([]byte(code)))},
+				[][]byte{[]byte(code), origin.record("full", nil)},
+			); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return db
+	}
+	dst := open("dst", "shared")
+	defer dst.Close()
+	a := open("a", "shared", "only-a", "imported")
+	defer a.Close()
+	if err := a.Set(importKey([]byte("imported")), []byte(`{"name":"imported"}`)); err != nil {
+		t.Fatal(err)
+	}
+	b := open("b", "imported", "only-b")
+	defer b.Close()
+
+	stats, err := mergeDBs(dst.db, []*pebble.DB{a.db, b.db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mergeStats{{codes: 3, added: 2, unique: 1}, {codes: 2, added: 1, unique: 1}}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("source %d contributed %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+	if n := countKeys(dst.db); n != 4 {
+		t.Fatalf("countKeys = %d, want 4", n)
+	}
+	// Records travel with their codes; the destination keeps its own.
+	for code, source := range map[string]string{"shared": "dst", "only-a": "a", "imported": "a", "only-b": "b"} {
+		if info, err := loadInfo(dst, makeKey([]byte(code))); err != nil || info == nil || info.Source != source {
+			t.Errorf("record of %s = (%+v, %v), want source %s", code, info, err, source)
+		}
+	}
+	if imp, err := loadImport(dst, makeKey([]byte("imported"))); err != nil || imp == nil {
+		t.Errorf("import not merged: (%v, %v)", imp, err)
+	}
+}
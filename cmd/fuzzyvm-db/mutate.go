@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator/precompiles"
+	"github.com/MariusVanDerWijden/FuzzyVM/mutator"
+	"github.com/cockroachdb/pebble"
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
+)
+
+// mutateSeedLen is the number of random filler bytes drawn per mutation. The
+// filler drives both the mutations and the transaction CreateGstMaker builds
+// around the result; a few hundred bytes covers both without wrapping.
+const mutateSeedLen = 256
+
+var mutateCommand = &cli.Command{
+	Name:   "mutate",
+	Usage:  "mutate stored codes at the bytecode level and store the interesting results",
+	Action: mutate,
+	Flags: []cli.Flag{
+		dbFlag,
+		&cli.IntFlag{
+			Name:    "procs",
+			Aliases: []string{"p"},
+			Usage:   "number of parallel mutation workers (0 = one per CPU)",
+			Value:   0,
+		},
+		&cli.DurationFlag{
+			Name:  "time",
+			Usage: "how long to mutate for (0 = until interrupted or --count is reached)",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "count",
+			Usage: "stop after this many mutated programs (0 = no limit)",
+			Value: 0,
+		},
+		debugFlag,
+	},
+}
+
+// mutate runs a mutational campaign seeded from the database: it picks stored
+// codes, applies EVM-aware mutations (see the mutator package), and pushes every
+// result through storeProgram, the same dedupe/minimize/store path `generate`
+// uses. Unlike `generate` it runs in-process: there is no Go coverage guidance
+// to borrow, so there is no reason to go through `go test -fuzz`, and this
+// process can hold the one read-write pebble handle itself. Programs that make
+// the EVM panic are saved as state tests in crashes/ next to the database.
+func mutate(ctx *cli.Context) error {
+	procs := ctx.Int("procs")
+	if procs <= 0 {
+		if procs = runtime.NumCPU() - 2; procs < 1 {
+			procs = 1
+		}
+	}
+	dbPath, err := filepath.Abs(ctx.String(dbFlag.Name))
+	if err != nil {
+		return err
+	}
+	pdb, err := createDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer pdb.Close()
+	// Programs that crash the EVM go next to the database, like generate's.
+	crashes := filepath.Join(filepath.Dir(dbPath), "crashes")
+	if err := os.MkdirAll(crashes, 0755); err != nil {
+		return err
+	}
+
+	keys, err := codeKeys(pdb.db)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("database %v holds no codes to mutate; run generate first", dbPath)
+	}
+	generator.Debug = ctx.Bool(debugFlag.Name)
+	precompiles.WarmupKZG()
+
+	// Stop on Ctrl-C, on --time, or once --count programs have been tried.
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	halt := func() { stopOnce.Do(func() { close(stop) }) }
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			halt()
+		case <-stop:
+		}
+	}()
+	if d := ctx.Duration("time"); d > 0 {
+		timer := time.AfterFunc(d, halt)
+		defer timer.Stop()
+	}
+
+	before := len(keys)
+	fmt.Printf("Mutating %d codes in %v with %d workers\n", before, dbPath, procs)
+	var (
+		limit    = int64(ctx.Int("count"))
+		mutated  atomic.Int64
+		firstErr error
+		errOnce  sync.Once
+		wg       sync.WaitGroup
+	)
+	for i := range procs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if n := mutated.Add(1); limit > 0 && n > limit {
+					halt()
+					return
+				}
+				if err := mutateOne(pdb, keys, rng, crashes); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					halt()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	after := countKeys(pdb.db)
+	tried := mutated.Load()
+	if limit > 0 && tried > limit {
+		tried = limit
+	}
+	fmt.Printf("Mutated %d programs, stored %d new codes\n", tried, after-before)
+	return firstErr
+}
+
+// mutateOne mutates one randomly chosen stored code, splicing from a second
+// one, and stores the result if it is new. A panic while executing the mutated
+// program doesn't kill the campaign: the state test it panicked in is saved to
+// crashes with the seed of the mutation, and the remaining workers keep going.
+func mutateOne(db *pebbleDB, keys [][]byte, rng *rand.Rand, crashes string) error {
+	key := keys[rng.Intn(len(keys))]
+	code, err := db.Get(key)
+	if err != nil {
+		return err
+	}
+	imp, err := loadImport(db, codeHash(key))
+	if err != nil {
+		return err
+	}
+	donor, err := db.Get(keys[rng.Intn(len(keys))])
+	if err != nil {
+		return err
+	}
+	seed := make([]byte, mutateSeedLen)
+	rng.Read(seed)
+	f := filler.NewFiller(seed)
+	out, applied := mutator.Mutate(f, code, donor)
+	if generator.Debug {
+		fmt.Fprintf(os.Stderr, "mutations: %v\nmutated %d -> %d bytes: %x\n", applied, len(code), len(out), out)
+	}
+	if have, err := hasCode(db, out); err != nil || have {
+		return err
+	}
+	// An imported code keeps running against the pre-state and transaction it
+	// was imported with; anything else gets the generator's usual surroundings.
+	gst := generator.CreateGstMaker(f, out)
+	if imp != nil {
+		gst = imp.gstMaker(out)
+	}
+	// Minimizing changes gst, so the reproducer is taken beforehand.
+	name := fmt.Sprintf("FuzzyVM-mutate-%x", makeKey(out))
+	test, err := json.MarshalIndent(gst.ToGeneralStateTest(name), "", "  ")
+	if err != nil {
+		return err
+	}
+	minCode, run, crash, err := minimizeMutant(gst)
+	if crash != "" {
+		log.Printf("panic running mutated code, saved as %v: %v", name, crash)
+		report := fmt.Sprintf("panic running a mutant of %x\nseed: %x\n\n%v", codeHash(key), seed, crash)
+		return saveCrash(crashes, name, test, report)
+	}
+	origin := codeInfo{Source: "mutate", Input: seed, Parent: codeHash(key)}
+	_, err = storeMinimized(db, out, origin, minCode, run, err)
+	return err
+}
+
+// minimizeMutant minimizes a mutated program like storeProgram, and returns a
+// panic of the EVM while executing it, with its stack, as crash.
+func minimizeMutant(gst *fuzzing.GstMaker) (minCode []byte, run *fuzzer.ExecSummary, crash string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash = fmt.Sprintf("%v\n%s", r, debug.Stack())
+		}
+	}()
+	_, minCode, run, err = fuzzer.MinimizeProgramSummarized(gst)
+	return minCode, run, "", err
+}
+
+// saveCrash writes the state test a program crashed in to dir, with the
+// report next to it.
+func saveCrash(dir, name string, test []byte, report string) error {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path+".json", test, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".txt", []byte(report), 0644)
+}
+
+// codeKeys returns the key of every stored code. Only the keys are held in
+// memory; codes are fetched on demand, so a corpus of millions of programs
+// costs tens of megabytes here rather than gigabytes.
+func codeKeys(db *pebble.DB) ([][]byte, error) {
+	iter, err := db.NewIter(prefixRange([]byte(codePrefix)))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var keys [][]byte
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	return keys, iter.Error()
+}
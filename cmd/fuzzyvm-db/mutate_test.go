@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+)
+
+// TestMutateOne seeds a database with two small programs and checks that a
+// round of mutations runs them through storeProgram without error, and that
+// at least one mutant is new enough to be stored.
+func TestMutateOne(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/test.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	p := program.New()
+	_, loop := p.Jumpdest()
+	p.Push(1).Push(2).Op(vm.ADD).Sstore(0, 1)
+	p.JumpIf(loop, big.NewInt(0))
+	seeds := [][]byte{
+		p.Bytes(),
+		program.New().Push(42).Push(0).Op(vm.MSTORE).Return(0, 32).Bytes(),
+	}
+	for _, code := range seeds {
+		if err := putCode(db, code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := codeKeys(db.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for range 20 {
+		if err := mutateOne(db, keys, rng, t.TempDir()); err != nil {
+			t.Fatalf("mutateOne: %v", err)
+		}
+	}
+	if n := countKeys(db.db); n <= len(seeds) {
+		t.Fatalf("no mutant was stored: %d keys", n)
+	}
+}
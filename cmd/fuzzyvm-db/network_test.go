@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startRemote serves db to remote workers on a loopback address.
+func startRemote(t *testing.T, db db, addr string, secret []byte) *dbServer {
+	t.Helper()
+	unix, err := net.Listen("unix", t.TempDir()+"/db.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(db, unix)
+	go srv.serve()
+	srv.listen(tcp, secret)
+	return srv
+}
+
+// TestRemoteHandshake checks that remote workers get in with the right secret
+// only.
+func TestRemoteHandshake(t *testing.T) {
+	pdb, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	srv := startRemote(t, pdb, "127.0.0.1:0", []byte("secret"))
+	defer srv.shutdown()
+	addr := srv.remote[0].Addr().String()
+
+	cli := dialRemoteDB(addr, []byte("secret"))
+	defer cli.Close()
+	code := []byte("remote-code")
+	if err := putCode(cli, code); err != nil {
+		t.Fatalf("putCode: %v", err)
+	}
+	if have, err := hasCode(pdb, code); err != nil || !have {
+		t.Fatalf("code not stored: (%v, %v)", have, err)
+	}
+
+	wrong := dialRemoteDB(addr, []byte("guess"))
+	defer wrong.Close()
+	if _, err := hasCode(wrong, code); !errors.Is(err, errSocket) || !errors.Is(err, errHandshake) {
+		t.Fatalf("wrong secret: hasCode error %v, want a rejected handshake", err)
+	}
+}
+
+// TestRemoteReconnect restarts the server under a connected worker, which
+// skips requests while it is gone and picks up again once it is back.
+func TestRemoteReconnect(t *testing.T) {
+	pdb, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	secret := []byte("secret")
+	srv := startRemote(t, pdb, "127.0.0.1:0", secret)
+	addr := srv.remote[0].Addr().String()
+
+	cli := dialRemoteDB(addr, secret)
+	defer cli.Close()
+	if err := putCode(cli, []byte("before")); err != nil {
+		t.Fatalf("putCode: %v", err)
+	}
+
+	// While the server is down, requests fail without waiting for it, and
+	// dialing backs off.
+	srv.shutdown()
+	if err := putCode(cli, []byte("during")); !errors.Is(err, errSocket) {
+		t.Fatalf("putCode while down: %v, want a socket error", err)
+	}
+	start := time.Now()
+	if err := putCode(cli, []byte("during")); !errors.Is(err, errSocket) || time.Since(start) > minBackoff {
+		t.Fatalf("putCode while backing off: %v after %v", err, time.Since(start))
+	}
+
+	// Back up on the same address, the worker reconnects after its backoff.
+	srv = startRemote(t, pdb, addr, secret)
+	defer srv.shutdown()
+	time.Sleep(maxBackoff / 10)
+	deadline := time.Now().Add(2 * maxBackoff)
+	for {
+		err := putCode(cli, []byte("after"))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker did not reconnect: %v", err)
+		}
+		time.Sleep(minBackoff)
+	}
+	for _, code := range []string{"before", "after"} {
+		if have, err := hasCode(pdb, []byte(code)); err != nil || !have {
+			t.Errorf("%s not stored: (%v, %v)", code, have, err)
+		}
+	}
+}
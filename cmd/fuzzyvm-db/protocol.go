@@ -7,28 +7,56 @@ import (
 )
 
 // Wire protocol between the generate server (which owns the pebble database)
-// and the fuzz-worker clients. It runs over a Unix domain socket.
+// and its clients: the fuzz workers, which run over a Unix domain socket or,
+// remote ones, TCP, and the commands that read a live campaign.
 //
 // Every message is: 1-byte opcode, then a 4-byte big-endian frame length, then
-// that many payload bytes.
+// that many payload bytes. Every reply is a frame too, whose opcode is one of
+// the resp codes. A failed request is answered with respFailed and the error
+// message as payload.
 //
-//	HAS: payload is a 32-byte code hash. The server replies with a single byte,
-//	     respPresent or respAbsent.
-//	PUT: payload is one or more code blobs, each a 4-byte big-endian length
-//	     followed by the bytes. The server stores any it doesn't already have
-//	     and replies with a single respAck byte (so the worker sees write errors
-//	     and gets natural backpressure).
+//	HELLO: payload is the client's protocolVersion as a 4-byte big-endian
+//	       number. It has to be the first request on a connection; the server
+//	       replies respAck with its own version if it speaks the client's, or
+//	       respFailed and closes the connection if not.
+//	HAS:   payload is a key. The server replies respPresent or respAbsent.
+//	GET:   payload is a key. The server replies respPresent with the value, or
+//	       respAbsent.
+//	PUT:   payload is one or more key/value pairs, each key and value a blob: a
+//	       4-byte big-endian length followed by the bytes. The server stores the
+//	       pairs whose keys it doesn't already have, so the first record of a
+//	       code sticks, atomically, and replies respAck (so the worker sees
+//	       write errors and gets natural backpressure). Only codes, keyed by
+//	       their hash, and records of codes are accepted; a PUT with any other
+//	       pair is turned down as a whole.
+//	STATS: no payload. The server replies respAck with its stored and received
+//	       counters and the number of codes in the database, each an 8-byte
+//	       big-endian number.
+//	ITER:  payload is a key prefix. The server streams a respPresent frame per
+//	       key with the prefix, in order, holding the key and value as two
+//	       blobs, and ends with respAck. A client that wants no more closes the
+//	       connection.
 const (
-	opHas byte = 'H'
-	opPut byte = 'P'
+	// protocolVersion is bumped on every incompatible change of the protocol.
+	// The unversioned protocol before HELLO was version 0.
+	protocolVersion = 1
+
+	opHello byte = 'V'
+	opHas   byte = 'H'
+	opGet   byte = 'G'
+	opPut   byte = 'P'
+	opStats byte = 'S'
+	opIter  byte = 'I'
 
 	respAbsent  byte = 0
 	respPresent byte = 1
 	respAck     byte = 2
+	respFailed  byte = 3
 
 	// maxFrame bounds a single frame to guard against a corrupt length header
 	// turning into a huge allocation. Programs are capped at ~10KB by the
-	// generator, plus framing overhead; 1 MiB is comfortably above that.
+	// generator, plus framing overhead; imported tests, which carry their
+	// pre-state, are the largest values, and 1 MiB is comfortably above those.
 	maxFrame = 1 << 20
 )
 
@@ -47,7 +75,7 @@ func writeFrame(w io.Writer, op byte, payload []byte) error {
 	return err
 }
 
-// readByte reads a single response byte (the server's reply to HAS/PUT).
+// readByte reads a single byte, as the handshake of remote workers sends.
 func readByte(r io.Reader) (byte, error) {
 	var b [1]byte
 	if _, err := io.ReadFull(r, b[:]); err != nil {
@@ -73,7 +101,8 @@ func readFrame(r io.Reader) (op byte, payload []byte, err error) {
 	return hdr[0], payload, nil
 }
 
-// encodeBlobs concatenates code blobs as length-prefixed chunks for a PUT.
+// encodeBlobs concatenates blobs as length-prefixed chunks for a PUT or an
+// ITER item.
 func encodeBlobs(blobs ...[]byte) []byte {
 	size := 0
 	for _, b := range blobs {
@@ -89,7 +118,7 @@ func encodeBlobs(blobs ...[]byte) []byte {
 	return out
 }
 
-// decodeBlobs splits a PUT payload back into individual code blobs.
+// decodeBlobs splits a PUT payload or an ITER item back into individual blobs.
 func decodeBlobs(payload []byte) ([][]byte, error) {
 	var blobs [][]byte
 	for len(payload) > 0 {
@@ -106,3 +135,40 @@ func decodeBlobs(payload []byte) ([][]byte, error) {
 	}
 	return blobs, nil
 }
+
+// serverStats is the reply to a STATS request.
+type serverStats struct {
+	stored   uint64 // codes the server newly wrote
+	received uint64 // codes PUT, including the ones it already had
+	codes    uint64 // codes in the database
+}
+
+func (s serverStats) encode() []byte {
+	out := make([]byte, 0, 24)
+	out = binary.BigEndian.AppendUint64(out, s.stored)
+	out = binary.BigEndian.AppendUint64(out, s.received)
+	return binary.BigEndian.AppendUint64(out, s.codes)
+}
+
+func decodeStats(payload []byte) (serverStats, error) {
+	if len(payload) != 24 {
+		return serverStats{}, fmt.Errorf("malformed STATS reply of %d bytes", len(payload))
+	}
+	return serverStats{
+		stored:   binary.BigEndian.Uint64(payload[0:]),
+		received: binary.BigEndian.Uint64(payload[8:]),
+		codes:    binary.BigEndian.Uint64(payload[16:]),
+	}, nil
+}
+
+// encodeVersion encodes a protocol version as HELLO and its reply carry it.
+func encodeVersion(version uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, version)
+}
+
+func decodeVersion(payload []byte) (uint32, error) {
+	if len(payload) != 4 {
+		return 0, fmt.Errorf("malformed protocol version of %d bytes", len(payload))
+	}
+	return binary.BigEndian.Uint32(payload), nil
+}
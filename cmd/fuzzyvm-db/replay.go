@@ -19,6 +19,7 @@ import (
 // can reference them — a test file is not part of the non-test build.
 const (
 	replayDBEnv      = "FUZZYVM_REPLAY_DB"
+	replayServerEnv  = "FUZZYVM_REPLAY_SERVER"
 	replayLimitEnv   = "FUZZYVM_REPLAY_LIMIT"
 	replayWorkersEnv = "FUZZYVM_REPLAY_WORKERS"
 )
@@ -33,6 +34,8 @@ var replayCommand = &cli.Command{
 	Action: replay,
 	Flags: []cli.Flag{
 		dbFlag,
+		serverFlag,
+		secretFlag,
 		&cli.StringFlag{
 			Name:  "coverpkg",
 			Usage: "package pattern to instrument for coverage",
@@ -58,6 +61,18 @@ var replayCommand = &cli.Command{
 			Usage:   "number of parallel replay workers (0 = one per CPU)",
 			Value:   0,
 		},
+		&cli.StringFlag{
+			Name:  "attribution",
+			Usage: "also record which codes cover each coverage block to this file, for whocovers; needs a binary built with -cover and replays one code at a time",
+		},
+		&cli.StringFlag{
+			Name:  "diff",
+			Usage: "instead of measuring coverage, execute every code under two configurations and list those that differ: scheme (hash against path), prestate (empty against seeded) or fork:<a>[,<b>] (the generator's fork or a against b)",
+		},
+		&cli.BoolFlag{
+			Name:  "semantic",
+			Usage: "instead of measuring Go coverage, trace every code in this process and print the combinations no code reaches: of an opcode with the call type and call depth of its frame and, if it accesses a slot or account, with the access being cold or warm; of an opcode with its halting error; of a call type with a call depth; and of a precompile with its outcome",
+		},
 		&cli.DurationFlag{
 			Name:  "timeout",
 			Usage: "test timeout; large corpora may need hours",
@@ -84,6 +99,15 @@ func replay(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if ctx.Bool("semantic") {
+		return replaySemantic(ctx)
+	}
+	if spec := ctx.String("diff"); spec != "" {
+		return replayDiff(ctx, spec)
+	}
+	if attribution := ctx.String("attribution"); attribution != "" {
+		return replayAttributed(ctx, profile, attribution)
+	}
 	pkgDir, err := packageDir()
 	if err != nil {
 		return err
@@ -110,6 +134,20 @@ func replay(ctx *cli.Context) error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	env := append(os.Environ(), fmt.Sprintf("%s=%s", replayDBEnv, dbPath))
+	corpus := dbPath
+	if addr := ctx.String(serverFlag.Name); addr != "" {
+		// A socket path is resolved like dbPath, as the test runs elsewhere.
+		if _, err := os.Stat(addr); err == nil {
+			if addr, err = filepath.Abs(addr); err != nil {
+				return err
+			}
+		}
+		env = append(env,
+			fmt.Sprintf("%s=%s", replayServerEnv, addr),
+			fmt.Sprintf("%s=%s", secretEnvKey, ctx.String(secretFlag.Name)),
+		)
+		corpus = addr
+	}
 	if l := ctx.Int("limit"); l > 0 {
 		env = append(env, fmt.Sprintf("%s=%d", replayLimitEnv, l))
 	}
@@ -118,16 +156,52 @@ func replay(ctx *cli.Context) error {
 	}
 	cmd.Env = env
 
-	fmt.Printf("Replaying corpus %v (coverpkg=%s)\n", dbPath, ctx.String("coverpkg"))
+	fmt.Printf("Replaying corpus %v (coverpkg=%s)\n", corpus, ctx.String("coverpkg"))
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("replay test failed: %w", err)
 	}
 
-	if err := summarize(profile); err != nil {
+	return report(profile, ctx.String("html"))
+}
+
+// replayAttributed replays the corpus in this process, which must be built
+// with -cover, and attributes the coverage to the codes replayed. The counters
+// of a go test binary can't be read before it exits, so this can't go through
+// TestReplayCorpus; it writes the profile of the whole corpus itself.
+func replayAttributed(ctx *cli.Context, profile, attribution string) error {
+	db, err := openReader(ctx)
+	if err != nil {
 		return err
 	}
+	defer db.Close()
+	fmt.Printf("Replaying and attributing corpus one code at a time\n")
+	a, replayed, failed, err := attributeCorpus(db, ctx.Int("limit"))
+	if err != nil {
+		return fmt.Errorf("attributing coverage: %w", err)
+	}
+	fmt.Printf("Replayed %d codes (%d failed/panicked)\n", replayed, failed)
+	if a.unattributed > 0 {
+		fmt.Printf("%d codes replayed alongside a timed-out replay are left unattributed\n", a.unattributed)
+	}
+	if err := writeAttribution(attribution, a); err != nil {
+		return err
+	}
+	if err := a.writeProfile(profile); err != nil {
+		return err
+	}
+	if err := report(profile, ctx.String("html")); err != nil {
+		return err
+	}
+	fmt.Printf("Attribution written to %v; look lines up with whocovers\n", attribution)
+	return nil
+}
 
-	if html := ctx.String("html"); html != "" {
+// report summarizes a coverage profile and renders it to html if not empty.
+func report(profile, html string) error {
+	if err := summarize(profile); err != nil {
+		return err
+	}
+	if html != "" {
 		htmlAbs, err := filepath.Abs(html)
 		if err != nil {
 			return err
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
+)
+
+// replayConfig is a configuration replay --diff executes codes under.
+type replayConfig struct {
+	fork   string // empty for the generator's fork
+	scheme string // trie scheme: rawdb.HashScheme or rawdb.PathScheme
+	seeded bool   // with the seeded pre-state instead of the generator's
+}
+
+func (c replayConfig) String() string {
+	fork, pre := c.fork, "empty"
+	if fork == "" {
+		fork = generator.Fork()
+	}
+	if c.seeded {
+		pre = "seeded"
+	}
+	return fmt.Sprintf("%s, %s scheme, %s pre-state", fork, c.scheme, pre)
+}
+
+// parseDiff parses the two configurations a --diff spec compares: scheme
+// (hash against path), prestate (empty against seeded), or fork:<a>[,<b>] (the
+// generator's fork or a against b).
+func parseDiff(spec string) (replayConfig, replayConfig, error) {
+	a := replayConfig{scheme: rawdb.HashScheme}
+	b := a
+	switch {
+	case spec == "scheme":
+		b.scheme = rawdb.PathScheme
+	case spec == "prestate":
+		b.seeded = true
+	case strings.HasPrefix(spec, "fork:"):
+		forks := strings.Split(strings.TrimPrefix(spec, "fork:"), ",")
+		if len(forks) > 2 {
+			return a, b, fmt.Errorf("--diff compares two forks, not %d", len(forks))
+		}
+		if len(forks) == 2 {
+			a.fork = forks[0]
+		}
+		b.fork = forks[len(forks)-1]
+		for _, fork := range []string{a.fork, b.fork} {
+			if fork == "" {
+				continue
+			}
+			if _, _, err := tests.GetChainConfig(fork); err != nil {
+				return a, b, fmt.Errorf("unknown fork %q, want one of %v", fork, tests.AvailableForks())
+			}
+		}
+	default:
+		return a, b, fmt.Errorf("unknown --diff %q, want scheme, prestate or fork:<a>[,<b>]", spec)
+	}
+	return a, b, nil
+}
+
+// replayOutcome is what the execution of a code came to.
+type replayOutcome struct {
+	root    common.Hash
+	gasUsed uint64
+	// halt is the error the outermost call frame ended with, or why the
+	// transaction is invalid; failure why the code could not be replayed.
+	halt    string
+	failure string
+}
+
+// replayUnder executes code in the generator's surroundings under cfg.
+func replayUnder(code []byte, cfg replayConfig) replayOutcome {
+	return runUnder(func() *fuzzing.GstMaker {
+		gst := generator.CreateGstMaker(filler.NewFiller(replaySeed), code)
+		if cfg.seeded {
+			seedPreState(gst)
+		}
+		return gst
+	}, cfg)
+}
+
+// replayImportUnder executes code in the test it was imported with under cfg.
+// The test keeps its own pre-state, seeded or not.
+func replayImportUnder(code []byte, imp *importedTest, cfg replayConfig) replayOutcome {
+	return runUnder(func() *fuzzing.GstMaker { return imp.gstMaker(code) }, cfg)
+}
+
+// runUnder executes the test makeGst makes under the fork and scheme of cfg.
+func runUnder(makeGst func() *fuzzing.GstMaker, cfg replayConfig) replayOutcome {
+	var out replayOutcome
+	err := guardReplay(func() error {
+		gst := makeGst()
+		var halt string
+		tracer := &tracing.Hooks{
+			OnExit: func(depth int, _ []byte, _ uint64, err error, _ bool) {
+				if depth == 0 && err != nil {
+					halt = err.Error()
+				}
+			},
+		}
+		root, gasUsed, err := runState(gst, cfg.fork, cfg.scheme, tracer)
+		if err != nil {
+			halt = "invalid transaction: " + err.Error()
+		}
+		out = replayOutcome{root: root, gasUsed: gasUsed, halt: halt}
+		return nil
+	})
+	if err != nil {
+		// A replay that timed out may still write out, so it is not read.
+		return replayOutcome{failure: err.Error()}
+	}
+	return out
+}
+
+// diffOutcomes describes how b differs from a, empty if it doesn't. Roots are
+// only compared if they are expected to match.
+func diffOutcomes(a, b replayOutcome, roots bool) string {
+	var diffs []string
+	if a.failure != b.failure {
+		diffs = append(diffs, fmt.Sprintf("failure %q != %q", a.failure, b.failure))
+	}
+	if a.halt != b.halt {
+		diffs = append(diffs, fmt.Sprintf("halt %q != %q", a.halt, b.halt))
+	}
+	if a.gasUsed != b.gasUsed {
+		diffs = append(diffs, fmt.Sprintf("gas used %d != %d", a.gasUsed, b.gasUsed))
+	}
+	if roots && a.root != b.root {
+		diffs = append(diffs, fmt.Sprintf("root %x != %x", a.root, b.root))
+	}
+	return strings.Join(diffs, "; ")
+}
+
+// codeDiff is a code that executed differently under two configurations.
+type codeDiff struct {
+	hash []byte
+	diff string
+}
+
+// diffCodes executes the codes in db under a and b, each also in the test it
+// was imported with if any, and returns those that differ, in hash order, and
+// how many it executed.
+func diffCodes(db db, a, b replayConfig, limit, workers int) ([]codeDiff, int, error) {
+	type job struct {
+		hash, code []byte
+	}
+	var (
+		jobs    = make(chan job, workers*4)
+		results = make(chan codeDiff, workers*4)
+		iterErr = make(chan error, 1)
+		wg      sync.WaitGroup
+		// Seeding the pre-state changes the root by itself.
+		roots = a.seeded == b.seeded
+	)
+	go func() {
+		err := forEachCode(db, limit, func(code []byte) error {
+			code = bytes.Clone(code)
+			jobs <- job{makeKey(code), code}
+			return nil
+		})
+		close(jobs)
+		iterErr <- err
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- codeDiff{j.hash, diffCode(db, j.hash, j.code, a, b, roots)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		diffs    []codeDiff
+		replayed int
+	)
+	for r := range results {
+		replayed++
+		if r.diff != "" {
+			diffs = append(diffs, r)
+		}
+	}
+	if err := <-iterErr; err != nil {
+		return nil, replayed, err
+	}
+	slices.SortFunc(diffs, func(x, y codeDiff) int { return bytes.Compare(x.hash, y.hash) })
+	return diffs, replayed, nil
+}
+
+// diffCode describes how code executes differently under a and b, empty if it
+// doesn't.
+func diffCode(db db, hash, code []byte, a, b replayConfig, roots bool) string {
+	var diffs []string
+	if d := diffOutcomes(replayUnder(code, a), replayUnder(code, b), roots); d != "" {
+		diffs = append(diffs, d)
+	}
+	imp, err := loadImport(db, hash)
+	if err != nil {
+		diffs = append(diffs, fmt.Sprintf("imported test unreadable: %v", err))
+	} else if imp != nil {
+		// The imported test brings its own pre-state, so its roots always
+		// compare.
+		if d := diffOutcomes(replayImportUnder(code, imp, a), replayImportUnder(code, imp, b), true); d != "" {
+			diffs = append(diffs, "imported test: "+d)
+		}
+	}
+	return strings.Join(diffs, "; ")
+}
+
+// replayDiff executes the corpus under the two configurations the --diff flag
+// names, lists the codes that execute differently, and fails if there are any.
+func replayDiff(ctx *cli.Context, spec string) error {
+	a, b, err := parseDiff(spec)
+	if err != nil {
+		return err
+	}
+	db, err := openReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	workers := ctx.Int("workers")
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	fmt.Printf("Replaying corpus under\n  a: %v\n  b: %v\n", a, b)
+	diffs, replayed, err := diffCodes(db, a, b, ctx.Int("limit"), workers)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		fmt.Printf("%x: %s\n", d.hash, d.diff)
+	}
+	fmt.Printf("Replayed %d codes, %d of them differ\n", replayed, len(diffs))
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d codes execute differently", len(diffs))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+func TestParseDiff(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		a, b replayConfig
+	}{
+		{"scheme", replayConfig{scheme: rawdb.HashScheme}, replayConfig{scheme: rawdb.PathScheme}},
+		{"prestate", replayConfig{scheme: rawdb.HashScheme}, replayConfig{scheme: rawdb.HashScheme, seeded: true}},
+		{"fork:Osaka", replayConfig{scheme: rawdb.HashScheme}, replayConfig{fork: "Osaka", scheme: rawdb.HashScheme}},
+		{"fork:Prague,Osaka", replayConfig{fork: "Prague", scheme: rawdb.HashScheme}, replayConfig{fork: "Osaka", scheme: rawdb.HashScheme}},
+	} {
+		a, b, err := parseDiff(tt.spec)
+		if err != nil || a != tt.a || b != tt.b {
+			t.Errorf("%v: (%+v, %+v, %v), want (%+v, %+v)", tt.spec, a, b, err, tt.a, tt.b)
+		}
+	}
+	for _, spec := range []string{"", "forks", "fork:Nope", "fork:Prague,Osaka,Amsterdam"} {
+		if _, _, err := parseDiff(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+// TestDiffCodes diffs a code whose gas depends on the storage it finds against
+// one whose gas doesn't.
+func TestDiffCodes(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var (
+		// PUSH1 1 PUSH1 0 SSTORE STOP: slot 0 holds 1 in the seeded pre-state.
+		store = hexutil.MustDecode("0x600160005500")
+		// PUSH1 1 PUSH1 2 ADD STOP
+		add = hexutil.MustDecode("0x600160020100")
+	)
+	for _, code := range [][]byte{store, add} {
+		if err := db.Set(codeKey(code), code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		spec string
+		diff [][]byte
+	}{
+		{"scheme", nil},
+		{"prestate", [][]byte{makeKey(store)}},
+	} {
+		a, b, err := parseDiff(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diffs, replayed, err := diffCodes(db, a, b, 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayed != 2 || len(diffs) != len(tt.diff) {
+			t.Fatalf("%v: %d of %d codes differ: %+v", tt.spec, len(diffs), replayed, diffs)
+		}
+		for i, d := range diffs {
+			if !bytes.Equal(d.hash, tt.diff[i]) {
+				t.Errorf("%v: %x differs, want %x", tt.spec, d.hash, tt.diff[i])
+			}
+		}
+	}
+}
+
+// TestDiffImport diffs a code that only executes CLZ, which Prague lacks, if
+// it finds slot 0 set, as it does in the test it was imported with.
+func TestDiffImport(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	imported, _, err := readStateTests("../../interesting_inputs/BenchTest-16.json")
+	if err != nil || len(imported) != 1 {
+		t.Fatalf("readStateTests = (%d tests, %v)", len(imported), err)
+	}
+	imp := imported[0]
+	// PUSH1 0 SLOAD PUSH1 7 JUMPI STOP JUMPDEST PUSH1 1 CLZ STOP
+	code := hexutil.MustDecode("0x600054600757005b60011e00")
+	account := imp.Pre[*imp.Target]
+	account.Code = code
+	account.Storage = map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))}
+	imp.Pre[*imp.Target] = account
+	if _, _, err := storeImport(db, imp); err != nil {
+		t.Fatal(err)
+	}
+	a, b, err := parseDiff("fork:Prague,Osaka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, replayed, err := diffCodes(db, a, b, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 || len(diffs) != 1 || !strings.HasPrefix(diffs[0].diff, "imported test: ") {
+		t.Fatalf("%d of %d codes differ: %+v, want the imported test to", len(diffs), replayed, diffs)
+	}
+}
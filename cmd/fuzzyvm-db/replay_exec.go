@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"sync/atomic"
 	"time"
 
 	"github.com/MariusVanDerWijden/FuzzyVM/filler"
@@ -11,9 +13,11 @@ import (
 	"github.com/cockroachdb/pebble"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/tracing"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/tests"
 	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
 )
 
 // replaySeed is the fixed filler seed used to build the (throwaway) transaction
@@ -34,18 +38,10 @@ const replayTimeout = 60 * time.Second
 
 // replayCode replays one stored bytecode through the same state-test path the
 // fuzzer uses (generator.CreateGstMaker -> tests.StateTest -> RunNoVerify), so
-// the coverage it produces reflects the exact EVM surface FuzzyVM exercises. It
-// runs the execution in a goroutine under replayTimeout and recovers from panics
-// there, so neither a slow program nor a pathological one can abort or stall the
-// replay.
-func replayCode(code []byte) error {
-	done := make(chan error, 1) // buffered: a timed-out goroutine can still send and exit
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				done <- fmt.Errorf("panic replaying code: %v", r)
-			}
-		}()
+// the coverage it produces reflects the exact EVM surface FuzzyVM exercises.
+// Every execution is traced by tracer, if not nil.
+func replayCode(code []byte, tracer *tracing.Hooks) error {
+	return guardReplay(func() error {
 		// Replay twice: once with the fuzzer's own (empty-storage) pre-state, and
 		// once with committed non-zero storage. The generator can only ever build
 		// two accounts with empty storage, which makes the whole `original != 0`
@@ -55,20 +51,61 @@ func replayCode(code []byte) error {
 		// stores. The first error wins; a failure in one variant shouldn't hide
 		// the other.
 		gst := generator.CreateGstMaker(filler.NewFiller(replaySeed), code)
-		err := executeState(gst)
+		err := executeState(gst, tracer)
 
 		warm := generator.CreateGstMaker(filler.NewFiller(replaySeed), code)
 		seedPreState(warm)
-		if werr := executeState(warm); err == nil {
+		if werr := executeState(warm, tracer); err == nil {
 			err = werr
 		}
-		done <- err
+		return err
+	})
+}
+
+// replayImport replays an imported state test with its own pre-state and
+// transaction, which reach state the generator's fixed surroundings never
+// produce (other accounts' code and storage, access lists, creation txs).
+func replayImport(imp *importedTest, tracer *tracing.Hooks) error {
+	return guardReplay(func() error {
+		code, err := imp.code()
+		if err != nil {
+			return err
+		}
+		return executeState(imp.gstMaker(code), tracer)
+	})
+}
+
+// errReplayTimeout is returned for a replay that exceeded replayTimeout.
+var errReplayTimeout = fmt.Errorf("replay exceeded %s", replayTimeout)
+
+// strayReplays counts the replays that timed out but still run, as nothing can
+// stop them; whatever they execute meanwhile isn't theirs to the coverage
+// counters.
+var strayReplays atomic.Int32
+
+// guardReplay runs fn in a goroutine under replayTimeout and turns a panic into
+// an error, so neither a slow program nor a pathological one can abort or stall
+// the replay.
+func guardReplay(fn func() error) error {
+	done := make(chan error, 1) // buffered: a timed-out goroutine can still send and exit
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic replaying code: %v", r)
+			}
+		}()
+		done <- fn()
 	}()
 	select {
 	case err := <-done:
 		return err
 	case <-time.After(replayTimeout):
-		return fmt.Errorf("replay exceeded %s", replayTimeout)
+		strayReplays.Add(1)
+		go func() {
+			<-done
+			strayReplays.Add(-1)
+		}()
+		return errReplayTimeout
 	}
 }
 
@@ -124,60 +161,106 @@ func codeOf(gst *fuzzing.GstMaker, addr common.Address) []byte {
 }
 
 // executeState mirrors fuzzer.MinimizeProgram's run path: marshal the GstMaker's
-// single subtest to a geth tests.StateTest and RunNoVerify it. Unlike the fuzzer
-// it closes the StateTestState it gets back — over a corpus of millions of codes
-// the trie DB (and any snapshot goroutine) would otherwise leak.
-func executeState(gst *fuzzing.GstMaker) error {
+// single subtest to a geth tests.StateTest and RunNoVerify it, under tracer if
+// not nil. Unlike the fuzzer it closes the StateTestState it gets back — over a
+// corpus of millions of codes the trie DB (and any snapshot goroutine) would
+// otherwise leak.
+func executeState(gst *fuzzing.GstMaker, tracer *tracing.Hooks) error {
+	_, _, err := runState(gst, "", rawdb.HashScheme, tracer)
+	return err
+}
+
+// runState executes gst like executeState, but under fork instead of the fork
+// it is enabled for unless that is empty, with the given trie scheme, and
+// returns the post-state root and the gas the transaction used.
+func runState(gst *fuzzing.GstMaker, fork, scheme string, tracer *tracing.Hooks) (common.Hash, uint64, error) {
 	name := ""
 	gstPtr := gst.ToGeneralStateTest(name)
 	sub := (*gstPtr)[name]
+	if fork != "" {
+		for enabled, posts := range sub.Post {
+			delete(sub.Post, enabled)
+			sub.Post[fork] = posts
+			break
+		}
+	}
 
 	data, err := json.Marshal(sub)
 	if err != nil {
-		return err
+		return common.Hash{}, 0, err
 	}
 	var stateTest tests.StateTest
 	if err := json.Unmarshal(data, &stateTest); err != nil {
-		return err
+		return common.Hash{}, 0, err
 	}
 	subtests := stateTest.Subtests()
 	if len(subtests) == 0 {
-		return fmt.Errorf("state test produced no subtests")
+		return common.Hash{}, 0, fmt.Errorf("state test produced no subtests")
 	}
-	state, _, _, err := stateTest.RunNoVerify(subtests[0], vm.Config{}, false, rawdb.HashScheme)
+	state, root, gasUsed, err := stateTest.RunNoVerify(subtests[0], vm.Config{Tracer: tracer}, false, scheme)
 	// Close is nil-safe (guards TrieDB != nil), so it is fine to call even after
 	// an error return that left state at its zero value.
 	state.Close()
-	return err
+	return root, gasUsed, err
 }
 
-// forEachCode iterates a read-only view of the corpus, invoking fn for each
-// stored bytecode. If limit > 0 it stops after limit codes. The value slice
-// pebble hands back is only valid until the next iteration step, so callers that
-// retain it must clone (forEachCode itself does not clone — see the replay test,
-// which clones before handing values to worker goroutines).
-func forEachCode(db *pebble.DB, limit int, fn func(code []byte) error) error {
-	iter, err := db.NewIter(&pebble.IterOptions{})
-	if err != nil {
-		return err
-	}
-	defer iter.Close()
+// errStop ends an iteration early without failing it.
+var errStop = errors.New("stop iterating")
+
+// forEachCode iterates the codes in db, invoking fn for each stored bytecode. If
+// limit > 0 it stops after limit codes. The value slice pebble hands back is
+// only valid until the next iteration step, so callers that retain it must
+// clone (forEachCode itself does not clone — see the replay test, which clones
+// before handing values to worker goroutines).
+func forEachCode(db db, limit int, fn func(code []byte) error) error {
 	n := 0
-	for iter.First(); iter.Valid(); iter.Next() {
+	err := db.Iterate([]byte(codePrefix), func(_, code []byte) error {
 		if limit > 0 && n >= limit {
-			break
-		}
-		if err := fn(iter.Value()); err != nil {
-			return err
+			return errStop
 		}
 		n++
+		return fn(code)
+	})
+	if errors.Is(err, errStop) {
+		return nil
 	}
-	return iter.Error()
+	return err
 }
 
-// openCorpus opens the pebble database read-only. Pebble takes no exclusive
-// directory lock in read-only mode, so a replay can run against a database a
-// generate campaign is still writing to.
+// openCorpus opens the pebble database read-only. Pebble still locks the
+// directory, so a database a generate campaign is writing to can only be read
+// through its server; see openReader.
 func openCorpus(path string) (*pebble.DB, error) {
-	return pebble.Open(path, &pebble.Options{ReadOnly: true, ErrorIfNotExists: true})
+	db, err := pebble.Open(path, &pebble.Options{ReadOnly: true, ErrorIfNotExists: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(db, true); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return db, nil
+}
+
+// openDB opens the database to read: that of the campaign serving on server if
+// given, with its secret if that is a TCP address, or else the one at path.
+func openDB(path, server, secret string) (db, error) {
+	if server != "" {
+		s, err := dialServer(server, secret)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	pdb, err := openCorpus(path)
+	if err != nil {
+		return nil, err
+	}
+	return &pebbleDB{db: pdb}, nil
+}
+
+// openReader opens the database a reading command is pointed at by its --db, or
+// --server and --secret flags.
+func openReader(ctx *cli.Context) (db, error) {
+	return openDB(ctx.String(dbFlag.Name), ctx.String(serverFlag.Name), ctx.String(secretFlag.Name))
 }
@@ -12,12 +12,12 @@ import (
 
 // TestReplayCorpus is the coverage entry point invoked by `fuzzyvm-db replay`.
 // It reads its parameters from the environment (see replay.go), opens the corpus
-// read-only, and replays every stored bytecode through the EVM over a worker
+// read-only or through the server of a running campaign, and replays every stored bytecode through the EVM over a worker
 // pool. It self-skips when no corpus is configured, so an ordinary
 // `go test ./...` stays fast and green.
 func TestReplayCorpus(t *testing.T) {
-	dbPath := os.Getenv(replayDBEnv)
-	if dbPath == "" {
+	dbPath, server := os.Getenv(replayDBEnv), os.Getenv(replayServerEnv)
+	if dbPath == "" && server == "" {
 		t.Skip("no corpus configured (set " + replayDBEnv + "); skipping replay")
 	}
 	limit := envInt(replayLimitEnv, 0)
@@ -26,16 +26,20 @@ func TestReplayCorpus(t *testing.T) {
 		workers = 1
 	}
 
-	db, err := openCorpus(dbPath)
+	db, err := openDB(dbPath, server, os.Getenv(secretEnvKey))
 	if err != nil {
-		t.Fatalf("opening corpus %q: %v", dbPath, err)
+		corpus := dbPath
+		if server != "" {
+			corpus = server
+		}
+		t.Fatalf("opening corpus %q: %v", corpus, err)
 	}
 	defer db.Close()
 
 	// Iteration is single-goroutine (the pebble iterator is not concurrent-safe
 	// and its value is only valid until the next step), so one goroutine reads
 	// and clones each code onto a channel that the workers drain.
-	codes := make(chan []byte, workers*4)
+	jobs := make(chan func() error, workers*4)
 	var (
 		replayed atomic.Int64
 		failed   atomic.Int64
@@ -45,8 +49,8 @@ func TestReplayCorpus(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for code := range codes {
-				if err := replayCode(code); err != nil {
+			for job := range jobs {
+				if err := job(); err != nil {
 					failed.Add(1)
 				}
 				replayed.Add(1)
@@ -55,10 +59,18 @@ func TestReplayCorpus(t *testing.T) {
 	}
 
 	iterErr := forEachCode(db, limit, func(code []byte) error {
-		codes <- bytes.Clone(code)
+		code = bytes.Clone(code)
+		jobs <- func() error { return replayCode(code, nil) }
 		return nil
 	})
-	close(codes)
+	// Imported tests additionally replay in their original surroundings.
+	if iterErr == nil {
+		iterErr = forEachImport(db, func(imp *importedTest) error {
+			jobs <- func() error { return replayImport(imp, nil) }
+			return nil
+		})
+	}
+	close(jobs)
 	wg.Wait()
 
 	if iterErr != nil {
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/cockroachdb/pebble"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/urfave/cli/v2"
+)
+
+// Key schema of the database. Version 0, the layout before there was a
+// version, keyed every code by its bare 32-byte hash. Version 1 namespaces the
+// keys:
+//
+//	schema              the schema version, in decimal
+//	code/<hash>         a code
+//	info/<hash>         the codeInfo record of that code, as JSON
+//	import/<hash>       the imported test the code came from, see import.go
+//
+// Tests stored by `generate --sink pebble` live under the prefixes of the sink
+// package.
+const (
+	schemaVersion = 1
+	schemaKey     = "schema"
+	codePrefix    = "code/"
+	infoPrefix    = "info/"
+
+	// batchSize is how many codes a bulk write (migrate, merge) puts in one
+	// batch.
+	batchSize = 1024
+)
+
+// errLegacySchema is returned when opening a database that still has the
+// unversioned layout.
+var errLegacySchema = errors.New("database has the unversioned key layout; run `fuzzyvm-db migrate` first")
+
+var migrateCommand = &cli.Command{
+	Name:   "migrate",
+	Usage:  "upgrade a database to the current key schema",
+	Action: migrate,
+	Flags:  []cli.Flag{dbFlag},
+}
+
+// codeInfo is the metadata record stored next to every code.
+type codeInfo struct {
+	// FirstSeen is when the code was first stored. Codes migrated from the
+	// unversioned layout don't have it.
+	FirstSeen time.Time `json:"firstSeen,omitzero"`
+	// Source is what produced the code: generate, mutate, import or migrate.
+	Source string `json:"source"`
+	// Input is the fuzz input the generator made the code from, or the seed
+	// of the mutations that made it from Parent.
+	Input  hexutil.Bytes `json:"input,omitempty"`
+	Parent hexutil.Bytes `json:"parent,omitempty"`
+	// Form is "full" for a code as it was produced and "minimized" for the
+	// minimized form of one. It is empty for migrated codes, whose form is
+	// not known.
+	Form string `json:"form,omitempty"`
+	// Full is the hash of the code a minimized code was minimized from, and
+	// Minimized that of the minimized form of a full code; its own hash if the
+	// code could not be shrunk.
+	Full      hexutil.Bytes `json:"full,omitempty"`
+	Minimized hexutil.Bytes `json:"minimized,omitempty"`
+	// Generator is the generator.Version and Fork the fork the code was made
+	// and executed with.
+	Generator int          `json:"generator,omitempty"`
+	Fork      string       `json:"fork,omitempty"`
+	Execution *execSummary `json:"execution,omitempty"`
+}
+
+// execSummary sums up an execution of a stored program.
+type execSummary struct {
+	GasUsed uint64 `json:"gasUsed"`
+	Steps   uint64 `json:"steps"`
+	// Halt is the error the outermost call frame ended with, empty if it
+	// succeeded.
+	Halt string `json:"halt,omitempty"`
+}
+
+func codeKey(code []byte) []byte {
+	return append([]byte(codePrefix), makeKey(code)...)
+}
+
+func infoKey(hash []byte) []byte {
+	return append([]byte(infoPrefix), hash...)
+}
+
+// codeHash returns the hash a code key is made of.
+func codeHash(key []byte) []byte {
+	return key[len(codePrefix):]
+}
+
+// record returns the record of a code origin produced, in the given form and
+// with the given execution.
+func (origin codeInfo) record(form string, summary *execSummary) []byte {
+	origin.FirstSeen = time.Now().UTC()
+	origin.Form = form
+	origin.Generator = generator.Version
+	origin.Fork = generator.Fork()
+	origin.Execution = summary
+	enc, err := json.Marshal(origin)
+	if err != nil {
+		panic(err) // a codeInfo always encodes
+	}
+	return enc
+}
+
+// loadInfo returns the record of the code with the given hash, or nil if it has
+// none.
+func loadInfo(db db, hash []byte) (*codeInfo, error) {
+	enc, err := db.Get(infoKey(hash))
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info := new(codeInfo)
+	if err := json.Unmarshal(enc, info); err != nil {
+		return nil, fmt.Errorf("corrupt record of %x: %w", hash, err)
+	}
+	return info, nil
+}
+
+// readSchema returns the schema version of db, 0 if it has none.
+func readSchema(db *pebble.DB) (int, error) {
+	val, closer, err := db.Get([]byte(schemaKey))
+	if isNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+	return strconv.Atoi(string(val))
+}
+
+// checkSchema fails unless db has the current schema version. A database
+// without any keys is new and gets it.
+func checkSchema(db *pebble.DB, readOnly bool) error {
+	version, err := readSchema(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version == schemaVersion:
+		return nil
+	case version > schemaVersion:
+		return fmt.Errorf("database has schema version %d, this binary only knows up to %d", version, schemaVersion)
+	}
+	iter, err := db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	empty := !iter.First()
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if !empty {
+		return errLegacySchema
+	}
+	if readOnly {
+		return nil
+	}
+	return db.Set([]byte(schemaKey), []byte(strconv.Itoa(schemaVersion)), pebble.Sync)
+}
+
+// migrate upgrades a database to the current schema.
+func migrate(ctx *cli.Context) error {
+	path := ctx.String(dbFlag.Name)
+	db, err := pebble.Open(path, &pebble.Options{ErrorIfNotExists: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	version, err := readSchema(db)
+	if err != nil {
+		return err
+	}
+	if version == schemaVersion {
+		fmt.Printf("%v already has schema version %d\n", path, version)
+		return nil
+	}
+	moved, err := migrateLegacy(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %d codes of %v to schema version %d\n", moved, path, schemaVersion)
+	return nil
+}
+
+// migrateLegacy moves the codes of an unversioned database under codePrefix,
+// gives each a record, and stamps the schema version. What produced a code
+// wasn't kept, except for imported codes, and neither was whether it is a full
+// or minimized form. Interrupted, it picks up where it stopped: codes already
+// moved no longer have legacy keys.
+func migrateLegacy(db *pebble.DB) (int, error) {
+	iter, err := db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	batch := db.NewBatch()
+	defer batch.Close()
+	moved := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		hash := iter.Key()
+		if len(hash) != len(makeKey(nil)) {
+			continue
+		}
+		info := codeInfo{Source: "migrate"}
+		if _, closer, err := db.Get(append([]byte(importPrefix), hash...)); err == nil {
+			closer.Close()
+			info = codeInfo{Source: "import", Form: "full", Fork: generator.Fork()}
+		} else if !isNotFound(err) {
+			return moved, err
+		}
+		enc, err := json.Marshal(info)
+		if err != nil {
+			return moved, err
+		}
+		if err := errors.Join(
+			batch.Set(append([]byte(codePrefix), hash...), iter.Value(), nil),
+			batch.Set(infoKey(hash), enc, nil),
+			batch.Delete(hash, nil),
+		); err != nil {
+			return moved, err
+		}
+		if moved++; moved%batchSize == 0 {
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				return moved, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return moved, err
+	}
+	if err := batch.Set([]byte(schemaKey), []byte(strconv.Itoa(schemaVersion)), nil); err != nil {
+		return moved, err
+	}
+	return moved, batch.Commit(pebble.Sync)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/cockroachdb/pebble"
+)
+
+// TestStoreProgramRecords checks that a stored program and its minimized form
+// both get a record, linked to each other.
+func TestStoreProgramRecords(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/test.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	input := []byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf")
+	gst, code, _ := generator.RecordProgram(filler.NewFiller(input))
+	if isNew, err := storeProgram(db, gst, code, codeInfo{Source: "generate", Input: input}); err != nil || !isNew {
+		t.Fatalf("storeProgram = (%v, %v), want (true, nil)", isNew, err)
+	}
+	full, err := loadInfo(db, makeKey(code))
+	if err != nil || full == nil {
+		t.Fatalf("loadInfo = (%v, %v)", full, err)
+	}
+	if full.Source != "generate" || !bytes.Equal(full.Input, input) || full.Form != "full" || full.FirstSeen.IsZero() ||
+		full.Generator != generator.Version || full.Fork != generator.Fork() || full.Execution == nil || full.Execution.Steps == 0 {
+		t.Fatalf("unexpected record %+v", full)
+	}
+	if bytes.Equal(full.Minimized, makeKey(code)) {
+		t.Skip("program did not minimize")
+	}
+	minimized, err := loadInfo(db, full.Minimized)
+	if err != nil || minimized == nil {
+		t.Fatalf("minimized form has no record: (%v, %v)", minimized, err)
+	}
+	if minimized.Form != "minimized" || !bytes.Equal(minimized.Full, makeKey(code)) || *minimized.Execution != *full.Execution {
+		t.Fatalf("unexpected record of minimized form %+v", minimized)
+	}
+	if n := countKeys(db.db); n != 2 {
+		t.Fatalf("countKeys = %d, want 2 (records are not codes)", n)
+	}
+}
+
+// TestMigrate checks that a database with the unversioned layout is refused
+// until it is migrated, and that migrating keeps its codes and imports.
+func TestMigrate(t *testing.T) {
+	path := t.TempDir() + "/test.pebble"
+	legacy, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, imported := []byte("generated"), []byte("imported")
+	for _, kv := range [][2][]byte{
+		{makeKey(generated), generated},
+		{makeKey(imported), imported},
+		{importKey(imported), []byte(`{"name":"imported"}`)},
+	} {
+		if err := legacy.Set(kv[0], kv[1], pebble.Sync); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createDB(path); !errors.Is(err, errLegacySchema) {
+		t.Fatalf("createDB on a legacy database = %v, want %v", err, errLegacySchema)
+	}
+
+	legacy, err = pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := migrateLegacy(legacy); err != nil || moved != 2 {
+		t.Fatalf("migrateLegacy = (%d, %v), want (2, nil)", moved, err)
+	}
+	if _, _, err := legacy.Get(makeKey(generated)); !isNotFound(err) {
+		t.Fatalf("legacy key left behind: %v", err)
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := createDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := countKeys(db.db); n != 2 {
+		t.Fatalf("countKeys = %d, want 2", n)
+	}
+	for code, source := range map[string]string{"generated": "migrate", "imported": "import"} {
+		if have, err := hasCode(db, []byte(code)); err != nil || !have {
+			t.Fatalf("%s not migrated: (%v, %v)", code, have, err)
+		}
+		if info, err := loadInfo(db, makeKey([]byte(code))); err != nil || info == nil || info.Source != source {
+			t.Fatalf("record of %s = (%+v, %v), want source %s", code, info, err, source)
+		}
+	}
+	if imp, err := loadImport(db, makeKey(imported)); err != nil || imp == nil {
+		t.Fatalf("import lost: (%v, %v)", imp, err)
+	}
+}
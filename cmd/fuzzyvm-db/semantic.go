@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/urfave/cli/v2"
+)
+
+// cellKind is a dimension of the semantic coverage matrix.
+type cellKind byte
+
+const (
+	cellOp         cellKind = iota // an opcode executed at all
+	cellFrame                      // an opcode executed in a frame of a call type at a call depth
+	cellHalt                       // an opcode halting its frame with an error
+	cellAccess                     // an opcode accessing a cold or warm slot or account in a frame
+	cellCall                       // a frame of a call type entered at a call depth
+	cellPrecompile                 // a precompile succeeding or failing
+)
+
+// semCell is a cell of the semantic coverage matrix: what an execution did,
+// in terms that mean the same in every code.
+type semCell struct {
+	kind  cellKind
+	op    vm.OpCode      // the opcode
+	frame semFrameKind   // the frame the opcode executed in, or a call entered
+	addr  common.Address // the precompile
+	value string         // the error, cold or warm, ok or failed
+}
+
+// semFrameKind is the call type and call depth of a frame.
+type semFrameKind struct {
+	typ   vm.OpCode
+	depth string
+}
+
+func (f semFrameKind) String() string {
+	return fmt.Sprintf("%v@%v", f.typ, f.depth)
+}
+
+// callTypes are the types of frames opcodes execute in.
+var callTypes = []vm.OpCode{vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL, vm.CREATE, vm.CREATE2}
+
+// depthBuckets are the call depths cells tell apart. The generator nests
+// programs up to ten deep.
+var depthBuckets = []string{"0", "1", "2-9", "10+"}
+
+func depthBucket(depth int) string {
+	switch {
+	case depth < 2:
+		return depthBuckets[depth]
+	case depth < 10:
+		return depthBuckets[2]
+	default:
+		return depthBuckets[3]
+	}
+}
+
+// frameKinds lists the call types at every call depth a frame can have.
+func frameKinds() []semFrameKind {
+	var kinds []semFrameKind
+	for _, typ := range callTypes {
+		for _, depth := range depthBuckets {
+			if depth == depthBuckets[0] && typ != vm.CALL && typ != vm.CREATE {
+				// Only the transaction enters a frame at depth 0.
+				continue
+			}
+			kinds = append(kinds, semFrameKind{typ, depth})
+		}
+	}
+	return kinds
+}
+
+// opHalts are the errors only some opcodes halt with. Stack errors and
+// running out of gas apply to every opcode whose stack bounds or cost allow
+// them.
+var opHalts = map[vm.OpCode][]string{
+	vm.JUMP:           {"invalid jump destination"},
+	vm.JUMPI:          {"invalid jump destination"},
+	vm.RETURNDATACOPY: {"return data out of bounds"},
+	vm.REVERT:         {"execution reverted"},
+	vm.SSTORE:         {"write protection"},
+	vm.TSTORE:         {"write protection"},
+	vm.LOG0:           {"write protection"},
+	vm.LOG1:           {"write protection"},
+	vm.LOG2:           {"write protection"},
+	vm.LOG3:           {"write protection"},
+	vm.LOG4:           {"write protection"},
+	vm.CREATE:         {"write protection"},
+	vm.CREATE2:        {"write protection"},
+	vm.CALL:           {"write protection"},
+	vm.SELFDESTRUCT:   {"write protection"},
+}
+
+// accessArg is the stack position, from the top, of the slot or account an
+// opcode accesses, and whether it is a slot of the executing account.
+var accessArg = map[vm.OpCode]struct {
+	pos  int
+	slot bool
+}{
+	vm.SLOAD:        {0, true},
+	vm.SSTORE:       {0, true},
+	vm.BALANCE:      {0, false},
+	vm.EXTCODESIZE:  {0, false},
+	vm.EXTCODECOPY:  {0, false},
+	vm.EXTCODEHASH:  {0, false},
+	vm.SELFDESTRUCT: {0, false},
+	vm.CALL:         {1, false},
+	vm.CALLCODE:     {1, false},
+	vm.DELEGATECALL: {1, false},
+	vm.STATICCALL:   {1, false},
+}
+
+// semanticRules returns the rules of a fork, which decide both the cells of
+// its matrix and what its transactions warm.
+func semanticRules(fork string) (params.Rules, error) {
+	config, _, err := tests.GetChainConfig(fork)
+	if err != nil {
+		return params.Rules{}, err
+	}
+	return config.Rules(new(big.Int), true, 0), nil
+}
+
+// forkPrecompiles returns the precompiles the generator's fork activates.
+func forkPrecompiles() (map[common.Address]bool, error) {
+	rules, err := semanticRules(generator.Fork())
+	if err != nil {
+		return nil, err
+	}
+	precompiles := make(map[common.Address]bool)
+	for _, addr := range vm.ActivePrecompiles(rules) {
+		precompiles[addr] = true
+	}
+	return precompiles, nil
+}
+
+// semanticMatrix lists every cell of the matrix for a fork, in the order they
+// are printed. Opcodes are the ones the fork defines, precompiles the ones it
+// activates.
+//
+// Opcodes are combined with the call type and call depth of the frame they
+// execute in, and accessing opcodes further with the access being cold or
+// warm, which makes a few thousand cells. Halting errors and precompiles stay
+// separate: an error is paired with the opcode halting with it, and a
+// precompile with its outcome.
+func semanticMatrix(fork string) ([]semCell, error) {
+	rules, err := semanticRules(fork)
+	if err != nil {
+		return nil, err
+	}
+	table, err := vm.LookupInstructionSet(rules)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		cells []semCell
+		ops   []vm.OpCode
+	)
+	for i := range table {
+		if op := vm.OpCode(i); op == vm.STOP || table[op].HasCost() {
+			ops = append(ops, op)
+			cells = append(cells, semCell{kind: cellOp, op: op})
+		}
+	}
+	frames := frameKinds()
+	for _, op := range ops {
+		for _, frame := range frames {
+			cells = append(cells, semCell{kind: cellFrame, op: op, frame: frame})
+		}
+	}
+	for _, op := range ops {
+		minStack, maxStack := table[op].Stack()
+		if table[op].HasCost() {
+			cells = append(cells, semCell{kind: cellHalt, op: op, value: "out of gas"})
+		}
+		if minStack > 0 {
+			cells = append(cells, semCell{kind: cellHalt, op: op, value: "stack underflow"})
+		}
+		if maxStack < 1024 {
+			cells = append(cells, semCell{kind: cellHalt, op: op, value: "stack limit reached"})
+		}
+		for _, halt := range opHalts[op] {
+			cells = append(cells, semCell{kind: cellHalt, op: op, value: halt})
+		}
+	}
+	for _, op := range ops {
+		if _, ok := accessArg[op]; !ok {
+			continue
+		}
+		for _, value := range []string{"cold", "warm"} {
+			for _, frame := range frames {
+				cells = append(cells, semCell{kind: cellAccess, op: op, frame: frame, value: value})
+			}
+		}
+	}
+	for _, frame := range frames {
+		cells = append(cells, semCell{kind: cellCall, frame: frame})
+	}
+	// ActivePrecompiles comes in map order.
+	precompiles := slices.Clone(vm.ActivePrecompiles(rules))
+	slices.SortFunc(precompiles, func(a, b common.Address) int { return a.Cmp(b) })
+	for _, addr := range precompiles {
+		cells = append(cells, semCell{kind: cellPrecompile, addr: addr, value: "ok"}, semCell{kind: cellPrecompile, addr: addr, value: "failed"})
+	}
+	return cells, nil
+}
+
+// warmKey is an account, or a slot of an account, an execution accessed.
+type warmKey struct {
+	addr   common.Address
+	slot   common.Hash
+	isSlot bool
+}
+
+// semanticTracer collects the cells of the semantic coverage matrix an
+// execution touches.
+//
+// It keeps its own access list rather than asking the StateDB: by the time
+// OnOpcode runs, the gas function of the opcode has already warmed what it
+// accesses.
+type semanticTracer struct {
+	cells       map[semCell]struct{}
+	frames      []semFrame
+	warm        map[warmKey]bool
+	precompiles map[common.Address]bool // of the fork replayed
+	// accessList is the one of the transaction replayed, which the hooks
+	// are not handed for state tests.
+	accessList types.AccessList
+}
+
+// semFrame is what a semanticTracer tracks of a call frame.
+type semFrame struct {
+	typ        vm.OpCode
+	precompile bool
+	addr       common.Address
+	warmed     []warmKey // dropped again if the frame fails
+}
+
+func newSemanticTracer(precompiles map[common.Address]bool) *semanticTracer {
+	return &semanticTracer{cells: make(map[semCell]struct{}), precompiles: precompiles}
+}
+
+// access warms k and reports whether it was warm already.
+func (t *semanticTracer) access(k warmKey) bool {
+	if t.warm[k] {
+		return true
+	}
+	t.warm[k] = true
+	if len(t.frames) > 0 {
+		frame := &t.frames[len(t.frames)-1]
+		frame.warmed = append(frame.warmed, k)
+	}
+	return false
+}
+
+func (t *semanticTracer) add(c semCell) {
+	t.cells[c] = struct{}{}
+}
+
+func (t *semanticTracer) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnTxStart: func(env *tracing.VMContext, _ *types.Transaction, from common.Address) {
+			// What EIP-2929 and EIP-3651 warm before the transaction executes;
+			// the recipient is warmed as the outermost frame is entered.
+			t.frames, t.warm = nil, map[warmKey]bool{{addr: from}: true, {addr: env.Coinbase}: true}
+			for addr := range t.precompiles {
+				t.warm[warmKey{addr: addr}] = true
+			}
+			for _, tuple := range t.accessList {
+				t.warm[warmKey{addr: tuple.Address}] = true
+				for _, slot := range tuple.StorageKeys {
+					t.warm[warmKey{addr: tuple.Address, slot: slot, isSlot: true}] = true
+				}
+			}
+		},
+		OnEnter: func(depth int, typ byte, _, to common.Address, _ []byte, _ uint64, _ *big.Int) {
+			frame := semFrame{typ: vm.OpCode(typ), addr: to, precompile: t.precompiles[to]}
+			if depth == 0 || frame.typ == vm.CREATE || frame.typ == vm.CREATE2 {
+				// Warmed by the caller, so undone only if the caller fails.
+				t.access(warmKey{addr: to})
+			}
+			t.frames = append(t.frames, frame)
+			if frame.typ != vm.SELFDESTRUCT {
+				t.add(semCell{kind: cellCall, frame: semFrameKind{frame.typ, depthBucket(depth)}})
+			}
+		},
+		OnExit: func(_ int, _ []byte, _ uint64, err error, _ bool) {
+			if len(t.frames) == 0 {
+				return
+			}
+			frame := t.frames[len(t.frames)-1]
+			t.frames = t.frames[:len(t.frames)-1]
+			if err != nil {
+				for _, k := range frame.warmed {
+					delete(t.warm, k)
+				}
+			} else if len(t.frames) > 0 {
+				parent := &t.frames[len(t.frames)-1]
+				parent.warmed = append(parent.warmed, frame.warmed...)
+			}
+			if frame.precompile {
+				value := "ok"
+				if err != nil {
+					value = "failed"
+				}
+				t.add(semCell{kind: cellPrecompile, addr: frame.addr, value: value})
+			}
+		},
+		OnOpcode: func(_ uint64, b byte, _, _ uint64, scope tracing.OpContext, _ []byte, _ int, err error) {
+			if len(t.frames) == 0 {
+				return
+			}
+			op := vm.OpCode(b)
+			frame := semFrameKind{t.frames[len(t.frames)-1].typ, depthBucket(len(t.frames) - 1)}
+			t.add(semCell{kind: cellOp, op: op})
+			t.add(semCell{kind: cellFrame, op: op, frame: frame})
+			if err != nil {
+				// The opcode failed before it executed, so it accessed nothing.
+				t.add(semCell{kind: cellHalt, op: op, value: errorKind(err)})
+				return
+			}
+			if arg, ok := accessArg[op]; ok && t.warm != nil {
+				stack := scope.StackData()
+				if len(stack) <= arg.pos {
+					return
+				}
+				v := stack[len(stack)-1-arg.pos]
+				k := warmKey{addr: v.Bytes20()}
+				if arg.slot {
+					k = warmKey{addr: scope.Address(), slot: v.Bytes32(), isSlot: true}
+				}
+				value := "cold"
+				if t.access(k) {
+					value = "warm"
+				}
+				t.add(semCell{kind: cellAccess, op: op, frame: frame, value: value})
+			}
+		},
+		OnFault: func(_ uint64, op byte, _, _ uint64, _ tracing.OpContext, _ int, err error) {
+			t.add(semCell{kind: cellHalt, op: vm.OpCode(op), value: errorKind(err)})
+		},
+	}
+}
+
+// semanticCoverage replays the codes in db under the generator's fork, each
+// with the test it was imported with if any. It returns the cells of the
+// semantic coverage matrix their executions touch, the number of codes it
+// replayed, and the number of those that failed, whose cells are left out.
+func semanticCoverage(db db, limit, workers int) (map[semCell]struct{}, int, int, error) {
+	type result struct {
+		cells map[semCell]struct{}
+		err   error
+	}
+	precompiles, err := forkPrecompiles()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var (
+		jobs    = make(chan []byte, workers*4)
+		results = make(chan result, workers*4)
+		iterErr = make(chan error, 1)
+		wg      sync.WaitGroup
+	)
+	go func() {
+		err := forEachCode(db, limit, func(code []byte) error {
+			jobs <- bytes.Clone(code)
+			return nil
+		})
+		close(jobs)
+		iterErr <- err
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for code := range jobs {
+				t := newSemanticTracer(precompiles)
+				imp, err := loadImport(db, makeKey(code))
+				if err == nil {
+					err = replayCode(code, t.hooks())
+				}
+				if err == nil && imp != nil {
+					if len(imp.Tx.AccessLists) > 0 && imp.Tx.AccessLists[0] != nil {
+						t.accessList = *imp.Tx.AccessLists[0]
+					}
+					err = replayImport(imp, t.hooks())
+				}
+				// A replay that timed out may still be running, so t is only
+				// read after the ones that returned.
+				if err != nil {
+					results <- result{err: err}
+					continue
+				}
+				results <- result{cells: t.cells}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		touched  = make(map[semCell]struct{})
+		replayed int
+		failed   int
+	)
+	for r := range results {
+		replayed++
+		if r.err != nil {
+			failed++
+			continue
+		}
+		for c := range r.cells {
+			touched[c] = struct{}{}
+		}
+	}
+	return touched, replayed, failed, <-iterErr
+}
+
+// replaySemantic replays the corpus under a semanticTracer and prints the
+// cells of the matrix no code touches.
+func replaySemantic(ctx *cli.Context) error {
+	matrix, err := semanticMatrix(generator.Fork())
+	if err != nil {
+		return err
+	}
+	db, err := openReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	workers := ctx.Int("workers")
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	touched, replayed, failed, err := semanticCoverage(db, ctx.Int("limit"), workers)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replayed %d codes (%d failed/panicked)\n", replayed, failed)
+	printUntouched(matrix, touched)
+	return nil
+}
+
+// printUntouched prints the cells of matrix not in touched, a line per
+// dimension and opcode.
+func printUntouched(matrix []semCell, touched map[semCell]struct{}) {
+	titles := map[cellKind]string{
+		cellOp:         "Opcodes never executed",
+		cellFrame:      "Opcodes never executed in frames of a call type@depth",
+		cellHalt:       "Opcodes never halting with an error",
+		cellAccess:     "Opcodes never accessing cold or warm slots and accounts in frames of a call type@depth",
+		cellCall:       "Call types never entered at a call depth",
+		cellPrecompile: "Precompiles never succeeding or failing",
+	}
+	var (
+		covered = 0
+		kind    = cellKind(255)
+		line    []string
+		label   string
+	)
+	flush := func() {
+		if len(line) > 0 {
+			fmt.Printf("  %s%s\n", label, strings.Join(line, ", "))
+		}
+		line = line[:0]
+	}
+	for _, c := range matrix {
+		if _, ok := touched[c]; ok {
+			covered++
+			continue
+		}
+		var l, item string
+		switch c.kind {
+		case cellOp:
+			// All on one line.
+			item = opName(c.op)
+		case cellFrame:
+			l, item = fmt.Sprintf("%s: ", opName(c.op)), c.frame.String()
+		case cellAccess:
+			l, item = fmt.Sprintf("%s %s: ", opName(c.op), c.value), c.frame.String()
+		case cellCall:
+			l, item = fmt.Sprintf("%s: ", opName(c.frame.typ)), c.frame.depth
+		case cellPrecompile:
+			l, item = shortAddress(c.addr)+": ", c.value
+			if p, ok := vm.PrecompiledContractsOsaka[c.addr]; ok {
+				l = fmt.Sprintf("%s %s: ", shortAddress(c.addr), p.Name())
+			}
+		default:
+			l, item = fmt.Sprintf("%s: ", opName(c.op)), c.value
+		}
+		if c.kind != kind || l != label {
+			flush()
+			if c.kind != kind {
+				fmt.Printf("%s:\n", titles[c.kind])
+				kind = c.kind
+			}
+			label = l
+		}
+		line = append(line, item)
+	}
+	flush()
+	fmt.Printf("Touched %d of %d cells (%s)\n", covered, len(matrix), share(covered, len(matrix)))
+}
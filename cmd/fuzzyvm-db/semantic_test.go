@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestSemanticMatrix(t *testing.T) {
+	matrix, err := semanticMatrix(generator.Fork())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := make(map[semCell]bool)
+	for _, c := range matrix {
+		if cells[c] {
+			t.Errorf("duplicate cell %+v", c)
+		}
+		cells[c] = true
+	}
+	for c, want := range map[semCell]bool{
+		{kind: cellOp, op: vm.ADD}: true,
+		{kind: cellOp, op: 0x0c}:   false,
+		{kind: cellHalt, op: vm.JUMP, value: "invalid jump destination"}:                   true,
+		{kind: cellHalt, op: vm.PUSH1, value: "stack limit reached"}:                       true,
+		{kind: cellHalt, op: vm.PUSH1, value: "stack underflow"}:                           false,
+		{kind: cellHalt, op: vm.POP, value: "stack limit reached"}:                         false,
+		{kind: cellFrame, op: vm.ADD, frame: semFrameKind{vm.DELEGATECALL, "2-9"}}:         true,
+		{kind: cellFrame, op: vm.ADD, frame: semFrameKind{vm.STATICCALL, "0"}}:             false,
+		{kind: cellAccess, op: vm.SLOAD, frame: semFrameKind{vm.CALL, "1"}, value: "cold"}: true,
+		{kind: cellAccess, op: vm.ADD, frame: semFrameKind{vm.CALL, "1"}, value: "cold"}:   false,
+		{kind: cellCall, frame: semFrameKind{vm.DELEGATECALL, depthBucket(12)}}:            true,
+		{kind: cellPrecompile, addr: vm.PrecompiledAddressesOsaka[0], value: "ok"}:         true,
+	} {
+		if cells[c] != want {
+			t.Errorf("%+v in matrix: %v, want %v", c, cells[c], want)
+		}
+	}
+}
+
+// TestSemanticCoverage replays codes that store twice to the same slot, jump
+// to a bad destination and read the balance of a precompile, which is warm.
+func TestSemanticCoverage(t *testing.T) {
+	db, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, code := range []string{
+		// PUSH1 1 PUSH1 0 SSTORE PUSH1 2 PUSH1 0 SSTORE PUSH1 3 PUSH1 4 ADD STOP
+		"0x6001600055600260005560036004010000",
+		// PUSH1 3 JUMP
+		"0x600356",
+		// PUSH1 1 BALANCE STOP
+		"0x60013100",
+	} {
+		code := hexutil.MustDecode(code)
+		if err := db.Set(codeKey(code), code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touched, replayed, failed, err := semanticCoverage(db, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 3 || failed != 0 {
+		t.Fatalf("replayed %d codes, %d failed", replayed, failed)
+	}
+	for c, want := range map[semCell]bool{
+		{kind: cellOp, op: vm.ADD}: true,
+		{kind: cellOp, op: vm.MUL}: false,
+		{kind: cellFrame, op: vm.SSTORE, frame: semFrameKind{vm.CALL, "0"}}:                  true,
+		{kind: cellFrame, op: vm.SSTORE, frame: semFrameKind{vm.CALL, "1"}}:                  false,
+		{kind: cellAccess, op: vm.SSTORE, frame: semFrameKind{vm.CALL, "0"}, value: "cold"}:  true,
+		{kind: cellAccess, op: vm.SSTORE, frame: semFrameKind{vm.CALL, "0"}, value: "warm"}:  true,
+		{kind: cellHalt, op: vm.JUMP, value: "invalid jump destination"}:                     true,
+		{kind: cellCall, frame: semFrameKind{vm.CALL, "0"}}:                                  true,
+		{kind: cellAccess, op: vm.BALANCE, frame: semFrameKind{vm.CALL, "0"}, value: "warm"}: true,
+		{kind: cellAccess, op: vm.BALANCE, frame: semFrameKind{vm.CALL, "0"}, value: "cold"}: false,
+	} {
+		if _, ok := touched[c]; ok != want {
+			t.Errorf("%+v touched: %v, want %v", c, ok, want)
+		}
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{&vm.ErrStackUnderflow{}, "stack underflow"},
+		{&vm.ErrStackOverflow{}, "stack limit reached"},
+		{vm.ErrInvalidJump, "invalid jump destination"},
+		{vm.ErrOutOfGas, "out of gas"},
+	} {
+		if got := errorKind(tt.err); got != tt.want {
+			t.Errorf("%v: %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -1,26 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
-// dbServer owns the single read-write pebble handle and serves HAS/PUT requests
-// from the fuzz-worker clients over a Unix socket. pebble is safe for
-// concurrent use, so each connection is handled in its own goroutine.
+// dbServer owns the single read-write pebble handle and serves the requests of
+// the fuzz-worker clients over a Unix socket, and of remote workers over any
+// further listeners; commands reading a live campaign connect the same ways.
+// pebble is safe for concurrent use, so each connection is handled in its own
+// goroutine.
 type dbServer struct {
 	db       db
 	ln       net.Listener
 	stored   atomic.Int64 // codes newly written
-	received atomic.Int64 // PUT blobs seen (incl. duplicates)
+	received atomic.Int64 // codes PUT (incl. duplicates)
 
-	wg    sync.WaitGroup // tracks live connection handlers
-	mu    sync.Mutex     // guards conns / closing
-	conns map[net.Conn]struct{}
+	wg      sync.WaitGroup // tracks live connection handlers
+	mu      sync.Mutex     // guards conns / closing
+	conns   map[net.Conn]struct{}
+	remote  []net.Listener // remote worker listeners, guarded by mu
 	closing bool
 }
 
@@ -30,8 +36,30 @@ func newServer(db db, ln net.Listener) *dbServer {
 
 // serve accepts connections until the listener is closed.
 func (s *dbServer) serve() {
+	s.accept(s.ln, nil)
+}
+
+// listen accepts remote workers on ln until shutdown. Unlike the Unix socket,
+// which only local users can reach, every connection must authenticate with
+// secret first. Only that handshake is authenticated: the frames after it are
+// neither signed nor encrypted, so ln must only face a trusted network, where
+// nobody can take over or tamper with an authenticated connection.
+func (s *dbServer) listen(ln net.Listener, secret []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		ln.Close()
+		return
+	}
+	s.remote = append(s.remote, ln)
+	go s.accept(ln, secret)
+}
+
+// accept accepts connections on ln until it is closed, authenticating them
+// with secret unless it is nil.
+func (s *dbServer) accept(ln net.Listener, secret []byte) {
 	for {
-		conn, err := s.ln.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			// Listener closed on shutdown; stop quietly.
 			return
@@ -43,6 +71,14 @@ func (s *dbServer) serve() {
 		s.wg.Add(1)
 		go func() {
 			defer s.wg.Done()
+			if secret != nil {
+				if err := serverHandshake(conn, secret); err != nil {
+					log.Printf("server: rejected %v: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					s.untrackConn(conn)
+					return
+				}
+			}
 			s.handle(conn)
 		}()
 	}
@@ -73,6 +109,9 @@ func (s *dbServer) untrackConn(conn net.Conn) {
 func (s *dbServer) shutdown() {
 	s.ln.Close()
 	s.mu.Lock()
+	for _, ln := range s.remote {
+		ln.Close()
+	}
 	s.closing = true
 	for conn := range s.conns {
 		conn.Close()
@@ -84,10 +123,16 @@ func (s *dbServer) shutdown() {
 func (s *dbServer) handle(conn net.Conn) {
 	defer conn.Close()
 	defer s.untrackConn(conn)
+	if err := s.hello(conn); err != nil {
+		if !hungUp(err) {
+			log.Printf("server: hello error: %v", err)
+		}
+		return
+	}
 	for {
 		op, payload, err := readFrame(conn)
 		if err != nil {
-			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			if !hungUp(err) {
 				log.Printf("server: read error: %v", err)
 			}
 			return
@@ -95,43 +140,116 @@ func (s *dbServer) handle(conn net.Conn) {
 		switch op {
 		case opHas:
 			resp := respAbsent
-			if have, err := s.has(payload); err != nil {
+			if have, err := s.db.Has(payload); err != nil {
 				log.Printf("server: has error: %v", err)
 			} else if have {
 				resp = respPresent
 			}
-			if err := writeByte(conn, resp); err != nil {
-				return
-			}
+			err = writeFrame(conn, resp, nil)
+		case opGet:
+			err = s.get(conn, payload)
 		case opPut:
-			if err := s.put(payload); err != nil {
-				log.Printf("server: put error: %v", err)
-			}
-			if err := writeByte(conn, respAck); err != nil {
-				return
+			if perr := s.put(payload); perr != nil {
+				log.Printf("server: put error: %v", perr)
+				err = fail(conn, perr)
+			} else {
+				err = writeFrame(conn, respAck, nil)
 			}
+		case opStats:
+			err = s.stats(conn)
+		case opIter:
+			err = s.iter(conn, payload)
 		default:
 			log.Printf("server: unknown opcode %q", op)
 			return
 		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// hello agrees on the protocol version with a new client.
+func (s *dbServer) hello(conn net.Conn) error {
+	op, payload, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	if op != opHello {
+		return fmt.Errorf("client sent %q before HELLO; is it older than protocol version %d?", op, protocolVersion)
+	}
+	version, err := decodeVersion(payload)
+	if err != nil {
+		return err
+	}
+	if version != protocolVersion {
+		writeFrame(conn, respFailed, fmt.Appendf(nil, "server speaks protocol version %d, not %d", protocolVersion, version))
+		return fmt.Errorf("client speaks protocol version %d", version)
+	}
+	return writeFrame(conn, respAck, encodeVersion(protocolVersion))
+}
+
+// get replies with the value of key.
+func (s *dbServer) get(conn net.Conn, key []byte) error {
+	val, err := s.db.Get(key)
+	if isNotFound(err) {
+		return writeFrame(conn, respAbsent, nil)
+	} else if err != nil {
+		return fail(conn, err)
+	}
+	if len(val) > maxFrame {
+		return fail(conn, fmt.Errorf("value of %x is too large to send", key))
 	}
+	return writeFrame(conn, respPresent, val)
 }
 
-// has reports whether a code with the given 32-byte hash is already stored.
-func (s *dbServer) has(hash []byte) (bool, error) {
-	if _, err := s.db.Get(hash); err != nil {
-		if isNotFound(err) {
-			return false, nil
+// stats replies with the counters of the server and the number of codes in the
+// database, which it counts afresh.
+func (s *dbServer) stats(conn net.Conn) error {
+	codes := uint64(0)
+	err := s.db.Iterate([]byte(codePrefix), func(_, _ []byte) error {
+		codes++
+		return nil
+	})
+	if err != nil {
+		return fail(conn, err)
+	}
+	return writeFrame(conn, respAck, serverStats{
+		stored:   uint64(s.stored.Load()),
+		received: uint64(s.received.Load()),
+		codes:    codes,
+	}.encode())
+}
+
+// iter streams every key with the given prefix and its value to the client.
+// The iteration sees the database as it was when it started, and a client
+// consuming the stream slowly holds that view open.
+func (s *dbServer) iter(conn net.Conn, prefix []byte) error {
+	var werr error
+	err := s.db.Iterate(prefix, func(key, value []byte) error {
+		item := encodeBlobs(key, value)
+		if len(item) > maxFrame {
+			return fmt.Errorf("value of %x is too large to send", key)
 		}
-		return false, err
+		werr = writeFrame(conn, respPresent, item)
+		return werr
+	})
+	if werr != nil {
+		return werr
+	}
+	if err != nil {
+		return fail(conn, err)
 	}
-	return true, nil
+	return writeFrame(conn, respAck, nil)
 }
 
-// put stores each blob that isn't already present, atomically per PUT.
+// put stores each key/value pair whose key isn't already present, atomically
+// per PUT. Workers only store codes and their records, so put turns down the
+// whole PUT if a code isn't stored under its own hash, if a record belongs to
+// no code of the PUT or the database, or if any other key is in it.
 //
 // The stored counter can slightly over-count under concurrency: two connections
-// PUTing the same new blob may both pass the Get check before either writes, so
+// PUTing the same new blob may both pass the Has check before either writes, so
 // both increment stored. Pebble dedups on the key, so this is a stats-only
 // imprecision, never data corruption. Serializing put would remove it at the
 // cost of throughput on the hot path, which isn't worth it for a progress
@@ -141,17 +259,32 @@ func (s *dbServer) put(payload []byte) error {
 	if err != nil {
 		return err
 	}
-	var keys, vals [][]byte
-	for _, code := range blobs {
-		s.received.Add(1)
-		key := makeKey(code)
-		if _, err := s.db.Get(key); err == nil {
-			continue // already stored
-		} else if !isNotFound(err) {
+	if len(blobs)%2 != 0 {
+		return fmt.Errorf("odd number of blobs: %d", len(blobs))
+	}
+	if err := s.checkPut(blobs); err != nil {
+		return err
+	}
+	var (
+		keys, vals [][]byte
+		codes      int64
+	)
+	for i := 0; i < len(blobs); i += 2 {
+		key := blobs[i]
+		isCode := bytes.HasPrefix(key, []byte(codePrefix))
+		if isCode {
+			s.received.Add(1)
+		}
+		if have, err := s.db.Has(key); err != nil {
 			return err
+		} else if have {
+			continue // already stored
+		}
+		if isCode {
+			codes++
 		}
 		keys = append(keys, key)
-		vals = append(vals, code)
+		vals = append(vals, blobs[i+1])
 	}
 	if len(keys) == 0 {
 		return nil
@@ -159,10 +292,57 @@ func (s *dbServer) put(payload []byte) error {
 	if err := s.db.SetBatch(keys, vals); err != nil {
 		return err
 	}
-	s.stored.Add(int64(len(keys)))
+	s.stored.Add(codes)
+	return nil
+}
+
+// checkPut checks that the key/value pairs of a PUT are codes stored under
+// their hash and records of codes in the PUT or the database.
+func (s *dbServer) checkPut(blobs [][]byte) error {
+	codes := make(map[string]bool)
+	for i := 0; i < len(blobs); i += 2 {
+		key, val := blobs[i], blobs[i+1]
+		if bytes.HasPrefix(key, []byte(codePrefix)) {
+			if !bytes.Equal(key, codeKey(val)) {
+				return fmt.Errorf("code %x stored under hash %x", makeKey(val), codeHash(key))
+			}
+			codes[string(codeHash(key))] = true
+		}
+	}
+	for i := 0; i < len(blobs); i += 2 {
+		key := blobs[i]
+		switch {
+		case bytes.HasPrefix(key, []byte(codePrefix)):
+		case bytes.HasPrefix(key, []byte(infoPrefix)):
+			hash := key[len(infoPrefix):]
+			if codes[string(hash)] {
+				continue
+			}
+			have, err := s.db.Has(append([]byte(codePrefix), hash...))
+			if err != nil {
+				return err
+			}
+			if !have {
+				return fmt.Errorf("record of %x belongs to no code", hash)
+			}
+		default:
+			return fmt.Errorf("key %q is neither a code nor a record", key)
+		}
+	}
 	return nil
 }
 
+// hungUp reports whether err is the client going away, or the server closing
+// its connection. A client stopping an ITER early resets the connection.
+func hungUp(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// fail replies to a request that failed with err.
+func fail(w io.Writer, err error) error {
+	return writeFrame(w, respFailed, []byte(err.Error()))
+}
+
 func writeByte(w io.Writer, b byte) error {
 	_, err := w.Write([]byte{b})
 	return err
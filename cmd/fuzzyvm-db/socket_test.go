@@ -1,11 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"net"
 	"path/filepath"
 	"testing"
 )
 
+// startSocket serves db on a Unix socket.
+func startSocket(t *testing.T, db db) (*dbServer, string) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "db.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(db, ln)
+	go srv.serve()
+	return srv, sock
+}
+
 func TestSocketRoundTrip(t *testing.T) {
 	dir := t.TempDir()
 	pdb, err := createDB(dir + "/db.pebble")
@@ -45,7 +60,7 @@ func TestSocketRoundTrip(t *testing.T) {
 	}
 	// Batch of two, one new one dup.
 	if err := cli.SetBatch(
-		[][]byte{makeKey(code), makeKey([]byte("second"))},
+		[][]byte{codeKey(code), codeKey([]byte("second"))},
 		[][]byte{code, []byte("second")},
 	); err != nil {
 		t.Fatalf("SetBatch: %v", err)
@@ -55,6 +70,53 @@ func TestSocketRoundTrip(t *testing.T) {
 	}
 }
 
+// TestSocketRejectsPut checks that the server only stores codes under their
+// hash and records of known codes, and turns down a PUT with anything else.
+func TestSocketRejectsPut(t *testing.T) {
+	pdb, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	stored := []byte("stored-code")
+	if err := putCode(pdb, stored); err != nil {
+		t.Fatal(err)
+	}
+	srv, sock := startSocket(t, pdb)
+	defer srv.shutdown()
+	cli, err := dialSocketDB(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	code, record := []byte("new-code"), []byte("{}")
+	for _, tt := range []struct {
+		name       string
+		keys, vals [][]byte
+		ok         bool
+	}{
+		{"code", [][]byte{codeKey(code)}, [][]byte{code}, true},
+		{"code and record", [][]byte{codeKey([]byte("other")), infoKey(makeKey([]byte("other")))}, [][]byte{[]byte("other"), record}, true},
+		{"record of a stored code", [][]byte{infoKey(makeKey(stored))}, [][]byte{record}, true},
+		{"code under another hash", [][]byte{codeKey(code)}, [][]byte{[]byte("not-new-code")}, false},
+		{"record of no code", [][]byte{infoKey(makeKey([]byte("missing")))}, [][]byte{record}, false},
+		{"schema", [][]byte{[]byte(schemaKey)}, [][]byte{[]byte("0")}, false},
+		{"import", [][]byte{codeKey([]byte("third")), importKey([]byte("third"))}, [][]byte{[]byte("third"), record}, false},
+	} {
+		err := cli.SetBatch(tt.keys, tt.vals)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !tt.ok && !errors.Is(err, errFailed) {
+			t.Errorf("%s: %v, want it turned down", tt.name, err)
+		}
+	}
+	// A turned-down PUT stores none of its pairs.
+	if have, err := hasCode(pdb, []byte("third")); err != nil || have {
+		t.Fatalf("code of a turned-down PUT: (%v, %v), want (false, nil)", have, err)
+	}
+}
+
 // TestServerShutdownPersistsWrites checks that a PUT acknowledged just before
 // shutdown is durably stored: shutdown drains in-flight handlers, then the db
 // is closed (flushing the WAL), and reopening finds the key.
@@ -99,3 +161,120 @@ func TestServerShutdownPersistsWrites(t *testing.T) {
 		t.Fatalf("after shutdown+reopen hasCode = (%v, %v), want (true, nil)", have, err)
 	}
 }
+
+// TestSocketReadAccess reads a database through its server, as the commands do
+// while a campaign holds it.
+func TestSocketReadAccess(t *testing.T) {
+	pdb, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	codes := [][]byte{[]byte("code-a"), []byte("code-b"), []byte("code-c")}
+	for _, code := range codes {
+		if err := putCode(pdb, code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv, sock := startSocket(t, pdb)
+	defer srv.shutdown()
+
+	cli, err := dialServer(sock, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if val, err := cli.Get(codeKey(codes[0])); err != nil || !bytes.Equal(val, codes[0]) {
+		t.Fatalf("Get = (%q, %v), want the code", val, err)
+	}
+	if _, err := cli.Get(codeKey([]byte("missing"))); !isNotFound(err) {
+		t.Fatalf("Get of a missing key: %v, want not found", err)
+	}
+	if err := putCode(cli, []byte("code-d")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := cli.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (serverStats{stored: 1, received: 1, codes: 4}) {
+		t.Fatalf("Stats = %+v, want 1 stored of 1 received, 4 codes", stats)
+	}
+
+	// The callback may make requests of its own while the stream is open.
+	var seen [][]byte
+	err = forEachCode(cli, 3, func(code []byte) error {
+		if have, err := hasCode(cli, code); err != nil || !have {
+			t.Errorf("hasCode(%q) during iteration = (%v, %v)", code, have, err)
+		}
+		seen = append(seen, code)
+		return nil
+	})
+	if err != nil || len(seen) != 3 {
+		t.Fatalf("forEachCode saw %d codes: %v", len(seen), err)
+	}
+	for i := 1; i < len(seen); i++ {
+		if bytes.Compare(codeKey(seen[i-1]), codeKey(seen[i])) >= 0 {
+			t.Errorf("codes out of key order: %q before %q", seen[i-1], seen[i])
+		}
+	}
+	// Stopping early leaves the client usable.
+	if have, err := hasCode(cli, codes[0]); err != nil || !have {
+		t.Fatalf("hasCode after iteration = (%v, %v)", have, err)
+	}
+}
+
+// TestSocketVersion checks that client and server refuse to talk across
+// protocol versions.
+func TestSocketVersion(t *testing.T) {
+	pdb, err := createDB(t.TempDir() + "/db.pebble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	srv, sock := startSocket(t, pdb)
+	defer srv.shutdown()
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := writeFrame(conn, opHello, encodeVersion(protocolVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	if resp, msg, err := readFrame(conn); err != nil || resp != respFailed {
+		t.Fatalf("HELLO of a newer client answered (%q, %q, %v), want a failure", resp, msg, err)
+	}
+
+	// A client from before HELLO is hung up on.
+	old, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if err := writeFrame(old, opHas, codeKey([]byte("code"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readByte(old); err == nil {
+		t.Fatal("server answered a request without HELLO")
+	}
+
+	// And a server from before HELLO is reported as such.
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "old.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		readFrame(conn)
+		conn.Close()
+	}()
+	if _, err := dialSocketDB(ln.Addr().String()); !errors.Is(err, errVersion) {
+		t.Fatalf("dialing an old server: %v, want a version mismatch", err)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultAttribution is where replay --attribution writes and whocovers reads
+// unless told otherwise.
+const defaultAttribution = "attribution.jsonl"
+
+var whocoversCommand = &cli.Command{
+	Name:      "whocovers",
+	Usage:     "print the smallest stored code that covers a line, as recorded by replay --attribution",
+	ArgsUsage: "<file:line>",
+	Action:    whocovers,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "attribution",
+			Usage: "the attribution replay wrote",
+			Value: defaultAttribution,
+		},
+	},
+}
+
+// blockAttribution records which codes execute a coverage block: how many of
+// them, and the smallest one, the first in hash order of equally small ones.
+type blockAttribution struct {
+	Block fuzzer.CoverageBlock `json:"block"`
+	Codes int                  `json:"codes"`
+	Code  hexutil.Bytes        `json:"code,omitempty"` // hash of the smallest code
+	Size  int                  `json:"size,omitempty"`
+}
+
+// attributor attributes the coverage of a replay to the codes replayed, one at
+// a time. The counters of the binary only ever grow, so the blocks a code
+// executed are the ones whose count grew while it was replayed, and the profile
+// of the whole corpus stays intact.
+type attributor struct {
+	blocks map[string]*blockAttribution
+	order  []*blockAttribution // in profile order
+
+	unattributed int // codes replayed alongside a timed-out replay
+}
+
+func newAttributor() *attributor {
+	return &attributor{blocks: make(map[string]*blockAttribution)}
+}
+
+// record attributes the blocks whose count grew since the last profile to the
+// code with the given hash and size. A nil hash attributes nothing, as for the
+// first profile, taken before any code is replayed.
+func (a *attributor) record(profile fuzzer.CoverageProfile, hash []byte, size int) {
+	for _, b := range profile {
+		if path.Dir(b.File) == packagePath {
+			// Instrumented only so the binary collects coverage at all.
+			continue
+		}
+		key := fmt.Sprintf("%s:%d.%d,%d.%d", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+		at, ok := a.blocks[key]
+		if !ok {
+			at = &blockAttribution{Block: b}
+			at.Block.Count = 0
+			a.blocks[key] = at
+			a.order = append(a.order, at)
+		}
+		if b.Count > at.Block.Count && hash != nil {
+			at.Codes++
+			if at.Code == nil || size < at.Size || (size == at.Size && bytes.Compare(hash, at.Code) < 0) {
+				at.Code, at.Size = bytes.Clone(hash), size
+			}
+		}
+		at.Block.Count = b.Count
+	}
+}
+
+// attributeCorpus replays the codes in db one at a time, each with the test it
+// was imported with if any, and attributes the coverage of each replay to its
+// code. It returns how many codes it replayed and how many of those failed,
+// whose coverage counts all the same. It needs a binary built with -cover.
+//
+// A replay that times out keeps running, and the counters can't tell its
+// coverage from that of the codes replayed after it. Until it ends, codes are
+// replayed for the profile only and left unattributed, as is the code that
+// timed out.
+func attributeCorpus(db db, limit int) (*attributor, int, int, error) {
+	var (
+		a        = newAttributor()
+		replayed int
+		failed   int
+	)
+	profile, err := fuzzer.ReadCoverage()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	a.record(profile, nil, 0)
+	err = forEachCode(db, limit, func(code []byte) error {
+		hash := makeKey(code)
+		imp, err := loadImport(db, hash)
+		if err != nil {
+			return err
+		}
+		stray := strayReplays.Load() > 0
+		err = replayCode(code, nil)
+		if err == nil && imp != nil {
+			err = replayImport(imp, nil)
+		}
+		if replayed++; err != nil {
+			failed++
+		}
+		if stray || errors.Is(err, errReplayTimeout) {
+			a.unattributed++
+			hash = nil
+		}
+		profile, err := fuzzer.ReadCoverage()
+		if err != nil {
+			return err
+		}
+		a.record(profile, hash, len(code))
+		return nil
+	})
+	return a, replayed, failed, err
+}
+
+// writeAttribution writes the attribution of every block as a line of JSON.
+func writeAttribution(path string, a *attributor) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, at := range a.order {
+		if err := enc.Encode(at); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeProfile writes the coverage of all codes replayed as a profile in the
+// text format go test writes.
+func (a *attributor) writeProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "mode: atomic")
+	for _, at := range a.order {
+		b := at.Block
+		fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.Statements, b.Count)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readAttribution reads the blocks an attribution holds for location
+// (file:line), and fails if it has none.
+func readAttribution(path, location string) ([]blockAttribution, error) {
+	file, line, err := fuzzer.ParseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		found []blockAttribution
+		dec   = json.NewDecoder(bufio.NewReader(f))
+	)
+	for dec.More() {
+		var at blockAttribution
+		if err := dec.Decode(&at); err != nil {
+			return nil, fmt.Errorf("corrupt attribution %v: %w", path, err)
+		}
+		if at.Block.Contains(file, line) {
+			found = append(found, at)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no coverage block at %v", location)
+	}
+	return found, nil
+}
+
+// whocovers prints, for every coverage block spanning a line, how many codes
+// execute it and which is the smallest.
+func whocovers(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("want one file:line location, got %d arguments", ctx.NArg())
+	}
+	blocks, err := readAttribution(ctx.String("attribution"), ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	for _, at := range blocks {
+		b := at.Block
+		fmt.Printf("%s:%d.%d,%d.%d: ", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+		if at.Codes == 0 {
+			fmt.Println("no code executes it")
+			continue
+		}
+		fmt.Printf("%d codes, smallest %x (%d bytes)\n", at.Codes, []byte(at.Code), at.Size)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+)
+
+// TestAttribution attributes the growth of the counters to the codes replayed
+// and looks the blocks up again after a round trip through the file.
+func TestAttribution(t *testing.T) {
+	profile := func(counts ...int) fuzzer.CoverageProfile {
+		return fuzzer.CoverageProfile{
+			{File: "core/vm/instructions.go", StartLine: 10, EndLine: 12, Statements: 2, Count: counts[0]},
+			{File: "core/vm/instructions.go", StartLine: 13, EndLine: 13, Statements: 1, Count: counts[1]},
+			{File: "core/vm/interpreter.go", StartLine: 10, EndLine: 20, Statements: 5, Count: counts[2]},
+			// The command's own blocks are left out.
+			{File: packagePath + "/main.go", StartLine: 1, EndLine: 2, Statements: 1, Count: counts[3]},
+		}
+	}
+	a := newAttributor()
+	a.record(profile(0, 0, 1, 1), nil, 0)       // before the first code
+	a.record(profile(1, 0, 2, 2), []byte{2}, 5) // big
+	a.record(profile(3, 0, 3, 3), []byte{3}, 2) // small
+	a.record(profile(3, 0, 4, 4), []byte{1}, 2) // as small, first in hash order
+
+	path := t.TempDir() + "/attribution.jsonl"
+	if err := writeAttribution(path, a); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		location string
+		codes    int
+		code     []byte
+	}{
+		{"instructions.go:11", 2, []byte{3}},
+		{"instructions.go:13", 0, nil},
+		{"core/vm/interpreter.go:20", 3, []byte{1}},
+	} {
+		found, err := readAttribution(path, tt.location)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.location, err)
+		}
+		if len(found) != 1 || found[0].Codes != tt.codes || !bytes.Equal(found[0].Code, tt.code) {
+			t.Errorf("%v: attributed %+v, want %d codes, smallest %x", tt.location, found, tt.codes, tt.code)
+		}
+	}
+	for _, location := range []string{"instructions.go:14", packagePath + "/main.go:1"} {
+		if _, err := readAttribution(path, location); err == nil {
+			t.Errorf("%v: found a block", location)
+		}
+	}
+}
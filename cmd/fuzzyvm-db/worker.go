@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/urfave/cli/v2"
+)
+
+var workerCommand = &cli.Command{
+	Name:   "worker",
+	Usage:  "fuzz on this machine into the database of a generate campaign listening elsewhere",
+	Action: worker,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "server",
+			Usage:    "TCP address of the campaign, as given to generate --listen",
+			Required: true,
+		},
+		secretFlag,
+		&cli.IntFlag{
+			Name:    "procs",
+			Aliases: []string{"p"},
+			Usage:   "number of parallel fuzzing workers (0 = one per CPU)",
+			Value:   0,
+		},
+		&cli.DurationFlag{
+			Name:  "time",
+			Usage: "how long to fuzz for (0 = until interrupted)",
+			Value: 0,
+		},
+		budgetFlag,
+		gasBudgetFlag,
+		&cli.StringFlag{
+			Name:  "quarantine",
+			Usage: "directory to save the inputs that run over budget to",
+			Value: "quarantine",
+		},
+		debugFlag,
+	},
+}
+
+// worker runs FuzzEVM workers like generate does, but has them store new
+// programs in the database of a campaign on another machine. The workers
+// reconnect whenever the connection breaks, so they keep fuzzing through a
+// restart of the campaign; inputs that find no server are skipped.
+func worker(ctx *cli.Context) error {
+	addr, secret := ctx.String("server"), ctx.String(secretFlag.Name)
+	if secret == "" {
+		return fmt.Errorf("remote workers need the --secret of the campaign")
+	}
+	procs := ctx.Int("procs")
+	if procs <= 0 {
+		if procs = runtime.NumCPU() - 2; procs < 1 {
+			procs = 1
+		}
+	}
+	// A wrong secret or protocol version would make every worker skip every
+	// input; catch them here. A server that isn't up yet is fine, the workers
+	// keep trying.
+	if conn, err := dialRemoteDB(addr, []byte(secret)).connect(); errors.Is(err, errHandshake) || errors.Is(err, errVersion) {
+		return err
+	} else if err != nil {
+		log.Printf("Server %v not reachable yet: %v", addr, err)
+	} else {
+		conn.Close()
+	}
+	quarantine, err := filepath.Abs(ctx.String("quarantine"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(quarantine, 0755); err != nil {
+		return err
+	}
+	pkgDir, err := packageDir()
+	if err != nil {
+		return err
+	}
+
+	cmd := fuzzCommand(pkgDir, procs, ctx.Duration("time"))
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", serverEnvKey, addr),
+		fmt.Sprintf("%s=%s", secretEnvKey, secret),
+	)
+	cmd.Env = append(cmd.Env, budgetEnv(ctx, quarantine)...)
+	if ctx.Bool(debugFlag.Name) {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=1", debugEnvKey))
+	}
+	interrupted, stop := catchInterrupt()
+	defer stop()
+
+	fmt.Printf("Fuzzing into %v with %d workers\n", addr, procs)
+	err = cmd.Run()
+	if interrupted.Load() {
+		return nil
+	}
+	return err
+}
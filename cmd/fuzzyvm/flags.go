@@ -33,4 +33,67 @@ var (
 		Usage: "Number of generator threads started (default = NUMCPU)",
 		Value: runtime.NumCPU(),
 	}
+
+	evmFlag = &cli.StringSliceFlag{
+		Name:  "evm",
+		Usage: "External EVM to run every new test on, as kind=/path/to/binary (kind is geth, besu, erigon, evmone, eels, nethermind, nimbus or revme)",
+	}
+
+	testNameFlag = &cli.StringFlag{
+		Name:  "name",
+		Usage: "Name of the test, if the file holds several (default = the first)",
+	}
+
+	forkFlag = &cli.StringFlag{
+		Name:  "fork",
+		Usage: "Fork of the subtest to reduce (default = the first)",
+	}
+
+	indexFlag = &cli.IntFlag{
+		Name:  "index",
+		Usage: "Index of the subtest to reduce among the post states of its fork",
+	}
+
+	commandFlag = &cli.StringFlag{
+		Name:  "command",
+		Usage: "Oracle command, run with the path of a candidate test; the candidate is kept if it exits with 0 (default = the candidate executes the same instructions, wherever they sit in the code)",
+	}
+
+	probesFlag = &cli.IntFlag{
+		Name:  "probes",
+		Usage: "Maximum number of candidate tests to try",
+		Value: 10000,
+	}
+
+	fillFlag = &cli.BoolFlag{
+		Name:  "fill",
+		Usage: "Write tests with the post states, logs and exceptions go-ethereum computes for every fork; tests it can't fill are kept unfilled as FuzzyVM-unfillable-*",
+	}
+
+	outputFlag = &cli.StringFlag{
+		Name:  "output",
+		Usage: "File to write the reduced test to (default = stdout)",
+	}
+
+	contextFlag = &cli.IntFlag{
+		Name:  "context",
+		Usage: "Number of steps and instructions to show around the divergence",
+		Value: 5,
+	}
+
+	testFlag = &cli.StringFlag{
+		Name:  "test",
+		Usage: "State test the traces are of, to disassemble the code around the divergence",
+	}
+
+	metaFlag = &cli.StringFlag{
+		Name:  "meta",
+		Usage: "Provenance of the test, to annotate the disassembly with strategies (default = <test>.meta.json, if it exists)",
+	}
+
+	sinkFlag = &cli.StringFlag{
+		Name:  "sink",
+		Usage: "Where to store the tests: dir:<path>, pebble:<path>, tar:<prefix> (rolling .tar.zst archives) or ndjson (stdout)",
+		Value: "dir:" + outputRootDir,
+	}
 )
@@ -21,14 +21,19 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/MariusVanDerWijden/FuzzyVM/benchmark"
 	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+	"github.com/MariusVanDerWijden/FuzzyVM/sink"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -62,6 +67,9 @@ var runCommand = &cli.Command{
 	Action: run,
 	Flags: []cli.Flag{
 		threadsFlag,
+		evmFlag,
+		fillFlag,
+		sinkFlag,
 	},
 }
 
@@ -73,7 +81,9 @@ func initApp() *cli.App {
 		benchCommand,
 		corpusCommand,
 		minCorpusCommand,
+		reduceCommand,
 		runCommand,
+		traceDiffCommand,
 	}
 	return app
 }
@@ -119,27 +129,59 @@ func corpus(c *cli.Context) error {
 }
 
 func run(c *cli.Context) error {
-	directories := []string{
-		outputRootDir,
-		crashesDir,
+	ensureDirs(outputRootDir, crashesDir)
+	genThreads := c.Int(threadsFlag.Name)
+	evms := c.StringSlice(evmFlag.Name)
+	if _, err := fuzzer.ParseEVMs(evms); err != nil {
+		return err
+	}
+	// The fuzzing workers run in processes of their own; this one owns the
+	// sink and serves it to them over a Unix socket.
+	out, err := sink.Open(c.String(sinkFlag.Name))
+	if err != nil {
+		return err
 	}
-	for i := 0; i < 256; i++ {
-		directories = append(directories, fmt.Sprintf("%v/%v", outputRootDir, common.Bytes2Hex([]byte{byte(i)})))
+	sockDir, err := os.MkdirTemp("", "fuzzyvm-sink-")
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer os.RemoveAll(sockDir)
+	sockPath := filepath.Join(sockDir, "sink.sock")
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	srv := sink.Serve(ln, out)
+	// Ctrl-C reaches the workers too. Catch it here so that once they are gone
+	// the sink is closed properly, which finishes the current archive.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	cmd := startGenerator(genThreads, evms, c.Bool(fillFlag.Name), sockPath)
+	err = cmd.Wait()
+	srv.Shutdown()
+	if cerr := out.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	select {
+	case <-sigCh:
+		return nil
+	default:
+		return err
 	}
-	ensureDirs(directories...)
-	genThreads := c.Int(threadsFlag.Name)
-	cmd := startGenerator(genThreads)
-	return cmd.Wait()
 }
 
-func startGenerator(genThreads int) *exec.Cmd {
+func startGenerator(genThreads int, evms []string, fill bool, sinkAddr string) *exec.Cmd {
 	var (
 		cmdName = "go"
 		target  = "FuzzVMBasic"
 		dir     = "./fuzzer/..."
 	)
 	cmd := exec.Command(cmdName, "test", "--fuzz", target, "--parallel", fmt.Sprint(genThreads), dir)
-	cmd.Stdout = os.Stdout
+	// The ndjson sink owns stdout.
+	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	// Set the output directory
 	path, err := os.Getwd()
@@ -148,6 +190,12 @@ func startGenerator(genThreads int) *exec.Cmd {
 	}
 	directory := filepath.Join(path, outputRootDir)
 	env := append(os.Environ(), fmt.Sprintf("%v=%v", fuzzer.EnvKey, directory))
+	env = append(env, fmt.Sprintf("%v=%v", fuzzer.CrashEnvKey, filepath.Join(path, crashesDir)))
+	env = append(env, fmt.Sprintf("%v=%v", fuzzer.EVMEnvKey, strings.Join(evms, ",")))
+	env = append(env, fmt.Sprintf("%v=%v", fuzzer.SinkEnvKey, sinkAddr))
+	if fill {
+		env = append(env, fmt.Sprintf("%v=1", fuzzer.FillEnvKey))
+	}
 	cmd.Env = env
 	if err := cmd.Start(); err != nil {
 		panic(err)
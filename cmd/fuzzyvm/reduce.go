@@ -0,0 +1,131 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+)
+
+var reduceCommand = &cli.Command{
+	Name:      "reduce",
+	Usage:     "Shrinks a state test from any source while an oracle still holds",
+	ArgsUsage: "<test.json>",
+	Action:    reduce,
+	Flags: []cli.Flag{
+		testNameFlag,
+		forkFlag,
+		indexFlag,
+		commandFlag,
+		probesFlag,
+		fillFlag,
+		outputFlag,
+	},
+}
+
+func reduce(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("expected the path of a state test")
+	}
+	test, err := fuzzing.FromGeneralStateTest(c.Args().First())
+	if err != nil {
+		return err
+	}
+	name, err := testName(test, c.String(testNameFlag.Name))
+	if err != nil {
+		return err
+	}
+	sub := (*test)[name]
+	// Reduce the one subtest of the one test the oracle should look at.
+	test = &fuzzing.GeneralStateTest{name: sub}
+	if err := fuzzer.SelectSubtest(test, name, c.String(forkFlag.Name), c.Int(indexFlag.Name)); err != nil {
+		return err
+	}
+	pred := fuzzer.SameRelocatedTrace
+	if command := strings.Fields(c.String(commandFlag.Name)); len(command) > 0 {
+		pred = fuzzer.CommandSucceeds(command...)
+	}
+	before, err := json.MarshalIndent(test, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fuzzer.Reduce(test, name, pred, c.Int(probesFlag.Name)); err != nil {
+		return err
+	}
+	after, err := json.MarshalIndent(test, "", "  ")
+	if err != nil {
+		return err
+	}
+	if c.Bool(fillFlag.Name) {
+		filled, err := fuzzer.FillTest(test, name)
+		if err != nil {
+			return err
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, filled, "", "  "); err != nil {
+			return err
+		}
+		after = indented.Bytes()
+	}
+	after = append(after, '\n')
+	if output := c.String(outputFlag.Name); output != "" {
+		if err := os.WriteFile(output, after, 0644); err != nil {
+			return err
+		}
+	} else if _, err := os.Stdout.Write(after); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Reduced %v from %d to %d bytes: %d accounts with %d bytes of code left\n",
+		name, len(before), len(after), len(sub.Pre), codeSize(test, name))
+	return nil
+}
+
+// testName returns name if the file holds a test of that name, or the name of
+// its first test if name is empty.
+func testName(test *fuzzing.GeneralStateTest, name string) (string, error) {
+	if name == "" {
+		names := slices.Sorted(maps.Keys(*test))
+		if len(names) == 0 {
+			return "", errors.New("the file holds no tests")
+		}
+		return names[0], nil
+	}
+	if _, ok := (*test)[name]; !ok {
+		return "", fmt.Errorf("no test named %q", name)
+	}
+	return name, nil
+}
+
+// codeSize sums the code of all accounts of the named test.
+func codeSize(test *fuzzing.GeneralStateTest, name string) int {
+	size := 0
+	for _, acc := range (*test)[name].Pre {
+		size += len(acc.Code)
+	}
+	return size
+}
@@ -0,0 +1,115 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/urfave/cli/v2"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+)
+
+var traceDiffCommand = &cli.Command{
+	Name:      "tracediff",
+	Usage:     "Reports the first step two EIP-3155 traces differ in",
+	ArgsUsage: "<a.jsonl> <b.jsonl>",
+	Action:    traceDiff,
+	Flags: []cli.Flag{
+		contextFlag,
+		testFlag,
+		testNameFlag,
+		metaFlag,
+	},
+}
+
+func traceDiff(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("expected the paths of two traces")
+	}
+	a, err := os.Open(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	b, err := os.Open(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	window := c.Int(contextFlag.Name)
+	diff, err := fuzzer.DiffTraces(a, b, window)
+	if err != nil {
+		return err
+	}
+	if diff == nil {
+		fmt.Println("The traces agree")
+		return nil
+	}
+	fmt.Print(diff)
+	if path := c.String(testFlag.Name); path != "" && diff.Summary == nil {
+		code, err := disassembleDiff(c, path, diff, window)
+		if err != nil {
+			fmt.Printf("\nNo disassembly: %v\n", err)
+		} else {
+			fmt.Printf("\nCode:\n%v", code)
+		}
+	}
+	// Like diff(1), report the difference in the exit status.
+	return cli.Exit("", 1)
+}
+
+// disassembleDiff disassembles the code around the divergence in the test at
+// path, annotated with the strategies of its provenance if there is one.
+func disassembleDiff(c *cli.Context, path string, diff *fuzzer.TraceDiff, window int) (string, error) {
+	test, err := fuzzing.FromGeneralStateTest(path)
+	if err != nil {
+		return "", err
+	}
+	name, err := testName(test, c.String(testNameFlag.Name))
+	if err != nil {
+		return "", err
+	}
+	metaPath := c.String(metaFlag.Name)
+	if metaPath == "" {
+		if guess := strings.TrimSuffix(path, ".json") + ".meta.json"; fileExists(guess) {
+			metaPath = guess
+		}
+	}
+	var meta *fuzzer.Provenance
+	if metaPath != "" {
+		data, err := os.ReadFile(metaPath)
+		if err != nil {
+			return "", err
+		}
+		meta = new(fuzzer.Provenance)
+		if err := json.Unmarshal(data, meta); err != nil {
+			return "", fmt.Errorf("invalid provenance %v: %w", metaPath, err)
+		}
+	}
+	return fuzzer.DisassembleDiff(test, name, diff, meta, window)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -48,6 +48,11 @@ var allTests = []test{
 }
 
 func main() {
+	// fuzzyvm-db's quarantine of slow inputs, if given, is benchmark material.
+	if len(os.Args) > 1 {
+		benchQuarantine(os.Args[1])
+		return
+	}
 	/*
 		test := len(allTests) - 1
 		writeTest := true
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/fuzzer"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// benchQuarantine times the programs of the inputs `fuzzyvm-db generate`
+// quarantined in dir for running over their budget, slowest first, and writes
+// out a state test for each of them.
+func benchQuarantine(dir string) {
+	inputs, err := fuzzer.ReadQuarantine(dir)
+	if err != nil {
+		panic(err)
+	}
+	type result struct {
+		code []byte
+		took time.Duration
+	}
+	results := make([]result, 0, len(inputs))
+	for _, in := range inputs {
+		_, code, _ := generator.RecordProgram(filler.NewFiller(in.Seed))
+		results = append(results, result{code, timeGeneration(code)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].took > results[j].took })
+	for _, r := range results {
+		path := fmt.Sprintf("statetest-quarantine-%x.json", crypto.Keccak256(r.code)[:8])
+		fmt.Printf("%v: %v (%d bytes)\n", path, r.took, len(r.code))
+		testMaker := generator.CreateGstMaker(filler.NewFiller([]byte("\x5a\x5a\x5a\x5a\x5a\x5a\x5a")), r.code)
+		if err := testMaker.Fill(nil, 0); err != nil {
+			panic(err)
+		}
+		storeTest(testMaker.ToGeneralStateTest("test"), path)
+	}
+}
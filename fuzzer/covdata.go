@@ -0,0 +1,309 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime/coverage"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// The Go runtime hands out its coverage data only in the binary formats of
+// internal/coverage: a meta-data file naming the coverable units of every
+// function, and a counter file holding the counters of the functions that
+// ran. Their layout is documented in internal/coverage/defs.go; this file
+// decodes version 1 of both, the parts go tool covdata textfmt reads, so that
+// a snapshot of the counters needs no toolchain and no process of its own.
+
+var (
+	covMetaMagic    = []byte{0x00, 'c', 'v', 'm'}
+	covCounterMagic = []byte{0x00, 'c', 'w', 'm'}
+)
+
+const (
+	covMetaHeaderSize    = 56 // coverage.MetaFileHeader
+	covPkgHeaderSize     = 44 // coverage.MetaSymbolHeader
+	covCounterHeaderSize = 32 // coverage.CounterFileHeader
+	covSegmentHeaderSize = 16 // coverage.CounterSegmentHeader
+
+	covCtrRaw     = 1 // coverage.CtrRaw
+	covCtrULEB128 = 2 // coverage.CtrULeb128
+)
+
+var errCorruptCoverage = errors.New("corrupt coverage data")
+
+// coverageMeta is the meta-data of the running binary: every block of the
+// profile with a zero count, in the order go tool covdata textfmt writes them,
+// and where the counter of each unit goes, by package, function and unit.
+type coverageMeta struct {
+	hash   []byte
+	blocks CoverageProfile
+	index  [][][]int
+}
+
+// readCoverageMeta decodes the meta-data of the binary, which doesn't change
+// while it runs.
+var readCoverageMeta = sync.OnceValues(func() (*coverageMeta, error) {
+	var buf bytes.Buffer
+	if err := coverage.WriteMeta(&buf); err != nil {
+		return nil, fmt.Errorf("no coverage counters, is the binary built with -cover? %w", err)
+	}
+	return decodeCoverageMeta(buf.Bytes())
+})
+
+// decodeCoverageMeta decodes a meta-data file.
+func decodeCoverageMeta(data []byte) (*coverageMeta, error) {
+	r := &covReader{b: data}
+	if !bytes.Equal(r.bytes(4), covMetaMagic) || r.u32() != 1 {
+		return nil, fmt.Errorf("%w: not a version 1 meta-data file", errCorruptCoverage)
+	}
+	r.seek(16)
+	entries := r.u64()
+	if entries > uint64(len(data)/16) {
+		return nil, errCorruptCoverage
+	}
+	meta := &coverageMeta{hash: bytes.Clone(r.bytes(16)), index: make([][][]int, entries)}
+	type pkgBlocks struct {
+		path   string
+		blocks []int // into meta.blocks
+	}
+	pkgs := make([]pkgBlocks, entries)
+	for i := range int(entries) {
+		r.seek(covMetaHeaderSize + 8*i)
+		off := r.u64()
+		r.seek(covMetaHeaderSize + 8*(int(entries)+i))
+		size := r.u64()
+		if r.err != nil || off > uint64(len(data)) || size > uint64(len(data))-off {
+			return nil, errCorruptCoverage
+		}
+		path, funcs, err := decodeCoveragePkg(data[off : off+size])
+		if err != nil {
+			return nil, err
+		}
+		pkgs[i].path = path
+		meta.index[i] = make([][]int, len(funcs))
+		for f, blocks := range funcs {
+			for _, b := range blocks {
+				meta.index[i][f] = append(meta.index[i][f], len(meta.blocks))
+				pkgs[i].blocks = append(pkgs[i].blocks, len(meta.blocks))
+				meta.blocks = append(meta.blocks, b)
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	// Packages by path and the blocks of each by position, like textfmt.
+	slices.SortStableFunc(pkgs, func(a, b pkgBlocks) int { return strings.Compare(a.path, b.path) })
+	order := make([]int, 0, len(meta.blocks))
+	for _, p := range pkgs {
+		slices.SortFunc(p.blocks, func(i, j int) int {
+			a, b := meta.blocks[i], meta.blocks[j]
+			return cmp.Or(
+				strings.Compare(a.File, b.File),
+				cmp.Compare(a.StartLine, b.StartLine),
+				cmp.Compare(a.EndLine, b.EndLine),
+				cmp.Compare(a.StartCol, b.StartCol),
+				cmp.Compare(a.EndCol, b.EndCol),
+				cmp.Compare(a.Statements, b.Statements),
+			)
+		})
+		order = append(order, p.blocks...)
+	}
+	position := make([]int, len(meta.blocks))
+	sorted := make(CoverageProfile, len(meta.blocks))
+	for pos, i := range order {
+		position[i] = pos
+		sorted[pos] = meta.blocks[i]
+	}
+	meta.blocks = sorted
+	for _, funcs := range meta.index {
+		for _, units := range funcs {
+			for k, i := range units {
+				units[k] = position[i]
+			}
+		}
+	}
+	return meta, nil
+}
+
+// decodeCoveragePkg decodes the meta-data of a package: its path and the
+// blocks of each of its functions.
+func decodeCoveragePkg(data []byte) (string, [][]CoverageBlock, error) {
+	r := &covReader{b: data}
+	r.seek(8)
+	pathIdx := r.u32()
+	r.seek(40)
+	numFuncs := int(r.u32())
+	if numFuncs > len(data)/4 {
+		return "", nil, errCorruptCoverage
+	}
+	r.seek(covPkgHeaderSize + 4*numFuncs)
+	strs := r.strings()
+	str := func(i uint64) string {
+		if i >= uint64(len(strs)) {
+			r.fail()
+			return ""
+		}
+		return strs[i]
+	}
+	path := str(uint64(pathIdx))
+	funcs := make([][]CoverageBlock, numFuncs)
+	for f := range funcs {
+		r.seek(covPkgHeaderSize + 4*f)
+		r.seek(int(r.u32()))
+		units := r.uleb()
+		r.uleb() // function name
+		file := str(r.uleb())
+		for range min(units, uint64(len(data))) {
+			funcs[f] = append(funcs[f], CoverageBlock{
+				File:       file,
+				StartLine:  int(r.uleb()),
+				StartCol:   int(r.uleb()),
+				EndLine:    int(r.uleb()),
+				EndCol:     int(r.uleb()),
+				Statements: int(r.uleb()),
+			})
+		}
+	}
+	return path, funcs, r.err
+}
+
+// profile returns the profile of the counters in a counter file.
+func (meta *coverageMeta) profile(counterData []byte) (CoverageProfile, error) {
+	profile := slices.Clone(meta.blocks)
+	err := decodeCoverageCounters(counterData, meta.hash, func(pkg, fn uint32, counters []uint32) error {
+		if int(pkg) >= len(meta.index) || int(fn) >= len(meta.index[pkg]) || len(counters) != len(meta.index[pkg][fn]) {
+			return fmt.Errorf("%w: counters of unknown function %d.%d", errCorruptCoverage, pkg, fn)
+		}
+		for k, c := range counters {
+			profile[meta.index[pkg][fn][k]].Count = int(c)
+		}
+		return nil
+	})
+	return profile, err
+}
+
+// decodeCoverageCounters calls fn with the counters of every function in a
+// counter file written for the meta-data with the given hash.
+func decodeCoverageCounters(data, metaHash []byte, fn func(pkg, fn uint32, counters []uint32) error) error {
+	r := &covReader{b: data}
+	if !bytes.Equal(r.bytes(4), covCounterMagic) || r.u32() != 1 {
+		return fmt.Errorf("%w: not a version 1 counter file", errCorruptCoverage)
+	}
+	if !bytes.Equal(r.bytes(16), metaHash) {
+		return fmt.Errorf("%w: counters of other meta-data", errCorruptCoverage)
+	}
+	flavor, bigEndian := r.bytes(1), r.bytes(1)
+	r.seek(covCounterHeaderSize)
+	funcs := r.u64()
+	strTab, args := r.u32(), r.u32()
+	r.seek((covCounterHeaderSize + covSegmentHeaderSize + int(strTab) + int(args) + 3) &^ 3)
+	if r.err != nil {
+		return r.err
+	}
+	read := r.uleb
+	switch {
+	case flavor[0] == covCtrRaw && bigEndian[0] != 0:
+		read = func() uint64 { return uint64(binary.BigEndian.Uint32(r.bytes(4))) }
+	case flavor[0] == covCtrRaw:
+		read = func() uint64 { return uint64(r.u32()) }
+	case flavor[0] != covCtrULEB128:
+		return fmt.Errorf("%w: unknown counter flavor %d", errCorruptCoverage, flavor[0])
+	}
+	var counters []uint32
+	for range funcs {
+		n, pkg, f := read(), uint32(read()), uint32(read())
+		counters = counters[:0]
+		for range min(n, uint64(len(data))) {
+			counters = append(counters, uint32(read()))
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if err := fn(pkg, f, counters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// covReader reads the little-endian numbers of the coverage formats from a
+// byte slice, remembering the first read out of bounds.
+type covReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *covReader) fail() {
+	if r.err == nil {
+		r.err = errCorruptCoverage
+	}
+}
+
+func (r *covReader) seek(off int) {
+	if off < 0 || off > len(r.b) {
+		r.fail()
+		return
+	}
+	r.off = off
+}
+
+func (r *covReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b)-r.off {
+		r.fail()
+		return make([]byte, n)
+	}
+	r.off += n
+	return r.b[r.off-n : r.off]
+}
+
+func (r *covReader) u32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+func (r *covReader) u64() uint64 { return binary.LittleEndian.Uint64(r.bytes(8)) }
+
+func (r *covReader) uleb() uint64 {
+	var v uint64
+	for shift := 0; r.err == nil; shift += 7 {
+		b := r.bytes(1)[0]
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v
+}
+
+// strings reads a string table: the number of strings, then each as its
+// length and bytes.
+func (r *covReader) strings() []string {
+	n := r.uleb()
+	strs := make([]string, 0, min(n, uint64(len(r.b))))
+	for range n {
+		if r.err != nil {
+			break
+		}
+		strs = append(strs, string(r.bytes(int(min(r.uleb(), uint64(len(r.b)))))))
+	}
+	return strs
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The coverage data of a program built with go build -cover
+// -covermode=atomic, which called f(1), f(5) and f(7) of
+//
+//	8	func f(x int) int {
+//	9		if x > 2 {
+//	10			return 1
+//	11		}
+//	12		return 0
+//	13	}
+//
+// before writing it, and the profile go tool covdata textfmt made of it. Test
+// binaries carry no meta-data to read.
+var (
+	testCovMeta     = common.FromHex("0063766d01000000b50000000000000001000000000000008d7acb98b6239b0071ee15423004f696480000000200000003010000000000004a000000000000006b0000000000000001006b00000002000000010000000100000020bef43f545d908d516b78cf4d00443f0000000005000000020000004f00000062000000050004636f7678046d61696e01660c636f76782f6d61696e2e676f0303040902090b010c020c0a010a030b010100010204100215010500")
+	testCovCounters = common.FromHex("0063776d010000008d7acb98b6239b0071ee15423004f69602000000000000000200000000000000360000000e0000000b0006474f4152434805616d64363404617267630132056172677630062e2f636f7678056172677631016404474f4f53056c696e7578050102090a030405060708000000010001010300000301020063776d000000000100000000000000")
+	testCovProfile  = `mode: atomic
+covx/main.go:9.2,9.11 1 3
+covx/main.go:10.3,11.1 1 2
+covx/main.go:12.2,12.10 1 1
+covx/main.go:16.2,21.1 5 1
+`
+)
+
+func TestDecodeCoverage(t *testing.T) {
+	want, err := ParseCoverage(strings.NewReader(testCovProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := decodeCoverageMeta(testCovMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := meta.profile(testCovCounters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(have, want) {
+		t.Fatalf("profile mismatch:\nhave %v\nwant %v", have, want)
+	}
+	// Truncated data fails, and doesn't crash.
+	for n := range len(testCovMeta) {
+		if _, err := decodeCoverageMeta(testCovMeta[:n]); !errors.Is(err, errCorruptCoverage) {
+			t.Fatalf("meta-data cut to %d bytes: %v", n, err)
+		}
+	}
+	for n := range len(testCovCounters) - 16 { // the footer is not read
+		if _, err := meta.profile(testCovCounters[:n]); !errors.Is(err, errCorruptCoverage) {
+			t.Fatalf("counters cut to %d bytes: %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime/coverage"
+	"strconv"
+	"strings"
+)
+
+// CoverageBlock is one block of a Go coverage profile: a range of statements
+// that always execute together, and how often they did.
+type CoverageBlock struct {
+	File                string
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	Statements, Count   int
+}
+
+// CoverageProfile is a parsed Go coverage profile.
+type CoverageProfile []CoverageBlock
+
+// ClearCoverage resets the coverage counters of the running binary. It only
+// works in binaries built with -cover -covermode=atomic.
+func ClearCoverage() error {
+	return coverage.ClearCounters()
+}
+
+// ReadCoverage snapshots the coverage counters of the running binary. The Go
+// runtime only hands them out in its binary formats, which are decoded in
+// process, so a snapshot per execution stays cheap.
+func ReadCoverage() (CoverageProfile, error) {
+	meta, err := readCoverageMeta()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := coverage.WriteCounters(&buf); err != nil {
+		return nil, err
+	}
+	return meta.profile(buf.Bytes())
+}
+
+// ParseCoverage reads a coverage profile in the text format go test
+// -coverprofile and go tool covdata textfmt write.
+func ParseCoverage(r io.Reader) (CoverageProfile, error) {
+	var (
+		profile CoverageProfile
+		sc      = bufio.NewScanner(r)
+	)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// file:startLine.startCol,endLine.endCol statements count
+		var b CoverageBlock
+		colon := strings.LastIndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("malformed coverage line %q", line)
+		}
+		b.File = line[:colon]
+		if _, err := fmt.Sscanf(line[colon+1:], "%d.%d,%d.%d %d %d", &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol, &b.Statements, &b.Count); err != nil {
+			return nil, fmt.Errorf("malformed coverage line %q: %w", line, err)
+		}
+		profile = append(profile, b)
+	}
+	return profile, sc.Err()
+}
+
+// ParseLocation splits a file:line location. The file may be given relative to
+// anything, it matches every profile file with that suffix.
+func ParseLocation(location string) (string, int, error) {
+	colon := strings.LastIndexByte(location, ':')
+	if colon < 0 {
+		return "", 0, fmt.Errorf("invalid location %q, want file:line", location)
+	}
+	line, err := strconv.Atoi(location[colon+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid location %q, want file:line", location)
+	}
+	return location[:colon], line, nil
+}
+
+// Contains reports whether the block spans the given line of a file.
+func (b CoverageBlock) Contains(file string, line int) bool {
+	return strings.HasSuffix(b.File, file) && b.StartLine <= line && line <= b.EndLine
+}
+
+// Covers reports whether any block spanning location (file:line) executed.
+func (p CoverageProfile) Covers(location string) (bool, error) {
+	file, line, err := ParseLocation(location)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, b := range p {
+		if b.Contains(file, line) {
+			if b.Count > 0 {
+				return true, nil
+			}
+			found = true
+		}
+	}
+	if !found {
+		return false, fmt.Errorf("no coverage block at %v", location)
+	}
+	return false, nil
+}
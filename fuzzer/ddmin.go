@@ -0,0 +1,166 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/mutator"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// maxMinimizeProbes bounds how often minimizing one test re-executes it,
+// across all its accounts and embedded codes. ddmin needs O(n²) probes in the
+// worst case, and minimization runs inline in the fuzzing loop, so a large test
+// ends up reduced as far as the budget allows rather than 1-minimal.
+const maxMinimizeProbes = 128
+
+// ddmin is Zeller's delta debugging over the complements: it repeatedly tries
+// to drop one of n chunks of items, keeps any candidate for which keep still
+// holds, and doubles n when no chunk can go. It returns the smallest slice it
+// found before running out of probes, each call of keep using up one; keep
+// must hold for items itself.
+func ddmin[T any](items []T, keep func([]T) bool, probes *int) []T {
+	n := 2
+	for len(items) >= 2 && *probes > 0 {
+		size := (len(items) + n - 1) / n
+		reduced := false
+		for start := 0; start < len(items) && *probes > 0; start += size {
+			candidate := slices.Concat(items[:start], items[min(start+size, len(items)):])
+			*probes--
+			if keep(candidate) {
+				items = candidate
+				n = max(n-1, 2)
+				reduced = true
+				break
+			}
+		}
+		if !reduced {
+			if n >= len(items) {
+				break
+			}
+			n = min(2*n, len(items))
+		}
+	}
+	return items
+}
+
+// prefix returns the length of the shortest prefix of code for which keep
+// holds, by binary search.
+func prefix(code []byte, keep func([]byte) bool, probes *int) int {
+	return sort.Search(len(code), func(i int) bool {
+		if *probes <= 0 {
+			return false
+		}
+		*probes--
+		return keep(code[0:i])
+	})
+}
+
+// minimizeCode shrinks code, which may be embedded in a larger program, while
+// keep holds: to its shortest working prefix first, then by shrinkCode. A
+// constructor is not minimized itself, only the runtime code it deploys.
+func minimizeCode(code []byte, keep func([]byte) bool, probes *int) []byte {
+	if runtime, ok := deployedRuntime(code); ok {
+		return deployer(minimizeCode(runtime, func(runtime []byte) bool {
+			return keep(deployer(runtime))
+		}, probes))
+	}
+	return shrinkCode(code[0:prefix(code, keep, probes)], keep, probes)
+}
+
+// shrinkCode removes instructions anywhere in code while keep holds, then
+// minimizes every init code it embeds and deploys the same way.
+func shrinkCode(code []byte, keep func([]byte) bool, probes *int) []byte {
+	code = minimizeInstructions(code, keep, probes)
+	for i := 0; *probes > 0; i++ {
+		insts := mutator.Disassemble(code)
+		embeddings := findEmbeddings(insts)
+		if i >= len(embeddings) {
+			break
+		}
+		e := embeddings[i]
+		inner := minimizeCode(e.code, func(inner []byte) bool {
+			return keep(e.replace(insts, inner))
+		}, probes)
+		if len(inner) < len(e.code) {
+			code = e.replace(insts, inner)
+		}
+	}
+	return code
+}
+
+// minimizeInstructions removes instruction-aligned chunks anywhere in code while
+// keep holds for the result. Removing whole instructions never splits a PUSH
+// from its operand, and every candidate is rebuilt with mutator.Relocate, so
+// jumps into the code that survives still land on their JUMPDEST.
+func minimizeInstructions(code []byte, keep func([]byte) bool, probes *int) []byte {
+	insts := ddmin(mutator.Disassemble(code), func(insts []mutator.Instruction) bool {
+		return keep(mutator.Relocate(insts))
+	}, probes)
+	return mutator.Relocate(insts)
+}
+
+// MinimizeStrategies works on the generator's structure instead of the bytes:
+// it records the strategy invocations the program seed describes, and
+// regenerates it with as many of them left out as it can while pred still
+// holds. It returns the invocations of the smallest program it found, and that
+// program's test and code. It fails if pred doesn't hold for the full program.
+func MinimizeStrategies(seed []byte, pred Predicate) ([]generator.Invocation, *fuzzing.GstMaker, []byte, error) {
+	const name = "minimal"
+	gst, _, all := generator.RecordProgram(filler.NewFiller(seed))
+	holds, err := pred(gst.ToGeneralStateTest(name), name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	regenerate := func(kept []int) (*fuzzing.GstMaker, []byte, []generator.Invocation) {
+		skip := make(map[int]bool, len(all))
+		for i := range all {
+			skip[i] = true
+		}
+		for _, i := range kept {
+			skip[i] = false
+		}
+		return generator.RegenerateProgram(filler.NewFiller(seed), skip)
+	}
+	indices := make([]int, len(all))
+	for i := range indices {
+		indices[i] = i
+	}
+	probes := maxMinimizeProbes
+	kept := ddmin(indices, func(kept []int) bool {
+		gst, _, _ := regenerate(kept)
+		return holds(gst.ToGeneralStateTest(name))
+	}, &probes)
+	gst, code, invocations := regenerate(kept)
+	return invocations, gst, code, nil
+}
+
+// strategyReport minimizes the program seed generates at the strategy level
+// while pred holds, and describes the result for a bug report. It returns ""
+// if pred doesn't hold for the regenerated program.
+func strategyReport(seed []byte, pred Predicate) string {
+	invocations, _, code, err := MinimizeStrategies(seed, pred)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("\nStrategies:\n%v\nProgram:\n%x\n", generator.FormatInvocations(invocations), code)
+}
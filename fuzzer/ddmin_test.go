@@ -0,0 +1,111 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+func TestDdmin(t *testing.T) {
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	keep := func(items []int) bool {
+		return slices.Contains(items, 63) && slices.Contains(items, 71)
+	}
+	probes := 1000
+	if got := ddmin(items, keep, &probes); !slices.Equal(got, []int{63, 71}) {
+		t.Errorf("got %v, want [63 71]", got)
+	}
+	// Out of probes, ddmin returns what it has so far.
+	probes = 1
+	if got := ddmin(items, keep, &probes); len(got) != 50 || probes != 0 {
+		t.Errorf("got %d items after one probe, want 50", len(got))
+	}
+}
+
+// TestMinimizeDeadCode checks that minimization removes a dead block in the
+// middle of a program, which the prefix search alone cannot, and fixes up the
+// jump across it.
+func TestMinimizeDeadCode(t *testing.T) {
+	dead := program.New()
+	for range 100 {
+		dead.Push(0x1234).Op(vm.POP)
+	}
+	// Jump over the dead block to the code that matters.
+	p := program.New().Sstore(1, 2)
+	dest := p.Size() + 4 + dead.Size()
+	p.Push(dest).Op(vm.JUMP).Append(dead.Bytes())
+	p.Op(vm.JUMPDEST).Sstore(3, 4)
+
+	gst := generator.CreateGstMaker(filler.NewFiller([]byte("minimizeminimizeminimize")), p.Bytes())
+	_, minimized, err := MinimizeProgram(gst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The jump keeps its two byte operand, now pointing right behind it.
+	want := program.New().Sstore(1, 2).Append([]byte{byte(vm.PUSH2), 0, 9}).Op(vm.JUMP, vm.JUMPDEST).Sstore(3, 4).Bytes()
+	if !bytes.Equal(minimized, want) {
+		t.Errorf("minimized to %x, want %x", minimized, want)
+	}
+}
+
+func TestMinimizeStrategies(t *testing.T) {
+	seed := []byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsfx")
+	hasSstore := func(test *fuzzing.GeneralStateTest) bool {
+		for _, sub := range *test {
+			for _, acc := range sub.Pre {
+				if bytes.Contains(acc.Code, []byte{byte(vm.SSTORE)}) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	keepSstore := func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error) {
+		if !hasSstore(test) {
+			return nil, errors.New("no SSTORE")
+		}
+		return hasSstore, nil
+	}
+	_, _, all := generator.RecordProgram(filler.NewFiller(seed))
+	invocations, gst, code, err := MinimizeStrategies(seed, keepSstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasSstore(gst.ToGeneralStateTest("name")) || !bytes.Contains(code, []byte{byte(vm.SSTORE)}) {
+		t.Errorf("minimized program %x lost its SSTORE", code)
+	}
+	kept := generator.FormatInvocations(invocations)
+	if n := bytes.Count([]byte(kept), []byte("\n")); n == 0 || n >= len(all) {
+		t.Errorf("kept %d of %d strategies:\n%v", n, len(all), kept)
+	}
+	// Nothing to minimize if the program never satisfied the predicate.
+	if _, _, _, err := MinimizeStrategies(seed, SameDiscrepancy); err == nil {
+		t.Errorf("minimized a program that doesn't satisfy the predicate")
+	}
+}
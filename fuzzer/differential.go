@@ -0,0 +1,195 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// execConfig is one way of setting up go-ethereum to run a state test. All
+// configurations must agree on the outcome; any difference is a bug in geth.
+type execConfig struct {
+	scheme   string
+	snapshot bool // only honoured by the hash scheme
+	trace    bool
+}
+
+func (c execConfig) String() string {
+	s := c.scheme
+	if c.snapshot {
+		s += "+snapshot"
+	}
+	if c.trace {
+		s += "+tracer"
+	}
+	return s
+}
+
+// execMatrix is the set of configurations the differential oracle runs every
+// test under: both trie schemes, the snapshotter (which geth only attaches to
+// the hash scheme), and each of those with and without a tracer.
+var execMatrix = []execConfig{
+	{scheme: rawdb.HashScheme},
+	{scheme: rawdb.HashScheme, trace: true},
+	{scheme: rawdb.HashScheme, snapshot: true},
+	{scheme: rawdb.HashScheme, snapshot: true, trace: true},
+	{scheme: rawdb.PathScheme},
+	{scheme: rawdb.PathScheme, trace: true},
+}
+
+// execResult is the observable outcome of one run of a state test.
+type execResult struct {
+	Root    common.Hash
+	Logs    common.Hash
+	GasUsed uint64
+	Err     string
+}
+
+// toStateTest converts a generated test to geth's representation by round
+// tripping it through JSON, the only interface the two share.
+func toStateTest(test *fuzzing.GeneralStateTest, name string) (*tests.StateTest, error) {
+	data, err := json.Marshal((*test)[name])
+	if err != nil {
+		return nil, err
+	}
+	var st tests.StateTest
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// execute runs the first subtest of test under cfg.
+func execute(test *tests.StateTest, cfg execConfig) execResult {
+	var vmConfig vm.Config
+	if cfg.trace {
+		vmConfig.Tracer = noopTracer()
+	}
+	state, root, gasUsed, err := test.RunNoVerify(test.Subtests()[0], vmConfig, cfg.snapshot, cfg.scheme)
+	defer state.Close()
+	res := execResult{Root: root, GasUsed: gasUsed}
+	if err != nil {
+		res.Err = err.Error()
+	}
+	if state.StateDB != nil {
+		res.Logs = logsHash(state.StateDB.Logs())
+	}
+	return res
+}
+
+// logsHash hashes logs the way the post section of a state test does.
+func logsHash(logs []*types.Log) common.Hash {
+	enc, err := rlp.EncodeToBytes(logs)
+	if err != nil {
+		panic(fmt.Sprintf("could not encode logs: %v", err))
+	}
+	return crypto.Keccak256Hash(enc)
+}
+
+// noopTracer returns hooks that observe every VM and state event but do
+// nothing. Attaching them is enough to route execution through the tracing
+// branches of the interpreter, without the cost of a real logger on
+// long-running programs.
+func noopTracer() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnTxStart:       func(*tracing.VMContext, *types.Transaction, common.Address) {},
+		OnTxEnd:         func(*types.Receipt, error) {},
+		OnEnter:         func(int, byte, common.Address, common.Address, []byte, uint64, *big.Int) {},
+		OnExit:          func(int, []byte, uint64, error, bool) {},
+		OnOpcode:        func(uint64, byte, uint64, uint64, tracing.OpContext, []byte, int, error) {},
+		OnFault:         func(uint64, byte, uint64, uint64, tracing.OpContext, int, error) {},
+		OnGasChange:     func(uint64, uint64, tracing.GasChangeReason) {},
+		OnBalanceChange: func(common.Address, *big.Int, *big.Int, tracing.BalanceChangeReason) {},
+		OnNonceChangeV2: func(common.Address, uint64, uint64, tracing.NonceChangeReason) {},
+		OnCodeChangeV2:  func(common.Address, common.Hash, []byte, common.Hash, []byte, tracing.CodeChangeReason) {},
+		OnStorageChange: func(common.Address, common.Hash, common.Hash, common.Hash) {},
+		OnLog:           func(*types.Log) {},
+	}
+}
+
+// Differential runs the named test under every configuration in execMatrix and
+// compares the post-state roots, logs hashes and gas used. It returns a
+// human-readable report if the configurations disagree, or "" if they all
+// produced the same outcome.
+func Differential(test *fuzzing.GeneralStateTest, name string) (string, error) {
+	st, err := toStateTest(test, name)
+	if err != nil {
+		return "", err
+	}
+	results := make([]execResult, len(execMatrix))
+	for i, cfg := range execMatrix {
+		results[i] = execute(st, cfg)
+	}
+	return disagreement(execMatrix, results), nil
+}
+
+// disagreement describes how results differ from the first one, or returns ""
+// if they are all equal.
+func disagreement(configs []execConfig, results []execResult) string {
+	var (
+		report strings.Builder
+		want   = results[0]
+	)
+	for i, res := range results[1:] {
+		if res == want {
+			continue
+		}
+		fmt.Fprintf(&report, "%v disagrees with %v:\n", configs[i+1], configs[0])
+		if res.Root != want.Root {
+			fmt.Fprintf(&report, "  root:  %x != %x\n", res.Root, want.Root)
+		}
+		if res.Logs != want.Logs {
+			fmt.Fprintf(&report, "  logs:  %x != %x\n", res.Logs, want.Logs)
+		}
+		if res.GasUsed != want.GasUsed {
+			fmt.Fprintf(&report, "  gas:   %d != %d\n", res.GasUsed, want.GasUsed)
+		}
+		if res.Err != want.Err {
+			fmt.Fprintf(&report, "  error: %q != %q\n", res.Err, want.Err)
+		}
+	}
+	return report.String()
+}
+
+// storeCrash saves a test that exposed a discrepancy to the crashes directory,
+// next to a text file with the report.
+func storeCrash(test *fuzzing.GeneralStateTest, testName, report string) error {
+	path := filepath.Join(crashDir, testName)
+	data, err := json.MarshalIndent(test, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode state test %q: %w", testName, err)
+	}
+	if err := os.WriteFile(path+".json", data, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".txt", []byte(report), 0755)
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+func TestDifferentialAgrees(t *testing.T) {
+	for _, seed := range []string{
+		"asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf",
+		strings.Repeat("\x5a", 64),
+		strings.Repeat("\xf1\x00\x55\x54", 32),
+	} {
+		testMaker, _ := generator.GenerateProgram(filler.NewFiller([]byte(seed)))
+		report, err := Differential(testMaker.ToGeneralStateTest("name"), "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report != "" {
+			t.Fatalf("configurations disagree on seed %x:\n%v", seed, report)
+		}
+	}
+}
+
+func TestDisagreementReport(t *testing.T) {
+	results := []execResult{{GasUsed: 21000}, {GasUsed: 21000}, {GasUsed: 21001, Err: "boom"}}
+	if report := disagreement(execMatrix, results[:2]); report != "" {
+		t.Fatalf("equal results reported as a disagreement:\n%v", report)
+	}
+	report := disagreement(execMatrix, results)
+	for _, want := range []string{execMatrix[2].String(), "gas:", "error:"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%v", want, report)
+		}
+	}
+	if strings.Contains(report, "root:") {
+		t.Errorf("report flags an equal root:\n%v", report)
+	}
+}
+
+func TestStoreCrash(t *testing.T) {
+	defer func(dir string) { crashDir = dir }(crashDir)
+	crashDir = t.TempDir()
+	testMaker, _ := generator.GenerateProgram(filler.NewFiller([]byte("crashcrashcrashcrashcrashcrashcrash")))
+	if err := storeCrash(testMaker.ToGeneralStateTest("crash"), "crash", "report\n"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"crash.json", "crash.txt"} {
+		if _, err := os.Stat(filepath.Join(crashDir, file)); err != nil {
+			t.Error(err)
+		}
+	}
+}
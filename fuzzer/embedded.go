@@ -0,0 +1,149 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/mutator"
+)
+
+// maxEmbeddedSize bounds the memory image findEmbeddings reconstructs, far
+// above the init code size limit, so a store to a huge offset is ignored
+// rather than allocated.
+const maxEmbeddedSize = 1 << 17
+
+// embedding is init code that a program writes to memory with a run of MSTOREs
+// and MSTORE8s and deploys straight away with CREATE or CREATE2: the shape
+// program.Mstore followed by Environment.CreateAndCall emits.
+type embedding struct {
+	start, end int // the stores are insts[start:end]
+	create     int // index of the CREATE or CREATE2
+	memStart   uint32
+	code       []byte
+}
+
+// findEmbeddings returns the init code embeddings in insts, in order.
+func findEmbeddings(insts []mutator.Instruction) []embedding {
+	var found []embedding
+	for i := 0; i < len(insts); i++ {
+		end := i
+		for end+2 < len(insts) && isStore(insts[end:end+3]) {
+			end += 3
+		}
+		if end == i {
+			continue
+		}
+		if e, ok := embeddingAt(insts, i, end); ok {
+			found = append(found, e)
+		}
+		i = end - 1
+	}
+	return found
+}
+
+// isStore reports whether insts start with a PUSH value, PUSH offset, MSTORE or
+// MSTORE8 triple.
+func isStore(insts []mutator.Instruction) bool {
+	return insts[0].Op.IsPush() && insts[1].Op.IsPush() && (insts[2].Op == vm.MSTORE || insts[2].Op == vm.MSTORE8)
+}
+
+// embeddingAt reconstructs the memory the stores in insts[start:end] write and
+// checks that the instructions after them deploy it: a CREATE (or a CREATE2
+// with its salt pushed first) whose size, offset and value are pushed right
+// before it, reading exactly from where the stores begin.
+func embeddingAt(insts []mutator.Instruction, start, end int) (embedding, bool) {
+	create := end + 3
+	if create >= len(insts) || insts[create].Op != vm.CREATE {
+		if create++; create >= len(insts) || insts[create].Op != vm.CREATE2 {
+			return embedding{}, false
+		}
+	}
+	for _, in := range insts[end:create] {
+		if !in.Op.IsPush() {
+			return embedding{}, false
+		}
+	}
+	var (
+		memStart = uint64(maxEmbeddedSize)
+		image    = make(map[uint64]byte)
+		top      uint64
+	)
+	for i := start; i < end; i += 3 {
+		value, offset := pushValue(insts[i]), pushValue(insts[i+1])
+		if !offset.IsUint64() || offset.Uint64() >= maxEmbeddedSize {
+			return embedding{}, false
+		}
+		off := offset.Uint64()
+		word := []byte{byte(value.Uint64())}
+		if insts[i+2].Op == vm.MSTORE {
+			word = value.FillBytes(make([]byte, 32))
+		}
+		for j, b := range word {
+			image[off+uint64(j)] = b
+		}
+		memStart = min(memStart, off)
+		top = max(top, off+uint64(len(word)))
+	}
+	size, offset := pushValue(insts[create-3]), pushValue(insts[create-2])
+	if !offset.IsUint64() || offset.Uint64() != memStart || !size.IsUint64() || memStart+size.Uint64() > top {
+		return embedding{}, false
+	}
+	code := make([]byte, size.Uint64())
+	for j := range code {
+		code[j] = image[memStart+uint64(j)]
+	}
+	return embedding{start: start, end: end, create: create, memStart: uint32(memStart), code: code}, true
+}
+
+func pushValue(in mutator.Instruction) *big.Int {
+	return new(big.Int).SetBytes(in.Arg)
+}
+
+// replace returns the code of insts with the embedded init code swapped for
+// code. The stores are emitted anew and the size of the CREATE is adjusted;
+// jumps around the embedding are relocated.
+func (e embedding) replace(insts []mutator.Instruction, code []byte) []byte {
+	stores := mutator.Disassemble(program.New().Mstore(code, e.memStart).Bytes())
+	size := mutator.Disassemble(program.New().Push(len(code)).Bytes())
+	return mutator.Relocate(slices.Concat(insts[:e.start], stores, insts[e.end:e.create-3], size, insts[e.create-2:]))
+}
+
+// deployedRuntime returns the runtime code that code, if it is a constructor
+// built by program.ReturnViaCodeCopy, deploys.
+func deployedRuntime(code []byte) ([]byte, bool) {
+	insts := mutator.Disassemble(code)
+	if len(insts) < 2 || insts[1].Op != vm.PUSH2 || len(insts[1].Arg) != 2 {
+		return nil, false
+	}
+	offset := int(pushValue(insts[1]).Uint64())
+	if offset > len(code) {
+		return nil, false
+	}
+	runtime := code[offset:]
+	return runtime, bytes.Equal(code, deployer(runtime))
+}
+
+// deployer wraps runtime in a constructor that deploys it.
+func deployer(runtime []byte) []byte {
+	return program.New().ReturnViaCodeCopy(runtime).Bytes()
+}
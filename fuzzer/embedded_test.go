@@ -0,0 +1,144 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+	"github.com/holiman/goevmlab/fuzzing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/mutator"
+)
+
+// createAndCall embeds init code the way Environment.CreateAndCall does.
+func createAndCall(p *program.Program, init []byte, create2 bool) *program.Program {
+	p.Mstore(init, 0)
+	op := vm.CREATE
+	if create2 {
+		p.Push(0)
+		op = vm.CREATE2
+	}
+	return p.Push(len(init)).Push(0).Push(0).Op(op).Op(vm.POP)
+}
+
+// deadCode is a block of instructions that runs over the code size minimization
+// bothers with.
+func deadCode() []byte {
+	p := program.New()
+	for range 100 {
+		p.Push(0x1234).Op(vm.POP)
+	}
+	return p.Bytes()
+}
+
+func TestFindEmbeddings(t *testing.T) {
+	first := deployer(program.New().Sstore(1, 2).Bytes())
+	second := append(bytes.Repeat([]byte{0xaa}, 40), 0x00, 0x01)
+	p := program.New().Sstore(3, 4)
+	createAndCall(p, first, false)
+	p.Push(0).Op(vm.POP)
+	createAndCall(p, second, true)
+	// Stores that aren't deployed don't count.
+	p.Mstore([]byte{1, 2, 3}, 0)
+
+	insts := mutator.Disassemble(p.Bytes())
+	found := findEmbeddings(insts)
+	if len(found) != 2 {
+		t.Fatalf("found %d embeddings, want 2", len(found))
+	}
+	if !bytes.Equal(found[0].code, first) || !bytes.Equal(found[1].code, second) {
+		t.Errorf("found %x and %x, want %x and %x", found[0].code, found[1].code, first, second)
+	}
+	if runtime, ok := deployedRuntime(found[0].code); !ok || !bytes.Equal(runtime, first[len(first)-5:]) {
+		t.Errorf("deployed runtime %x, %v", runtime, ok)
+	}
+	if _, ok := deployedRuntime(second); ok {
+		t.Errorf("%x is not a constructor", second)
+	}
+	// Replacing embedded code is the same as embedding the other code.
+	want := program.New().Sstore(3, 4)
+	createAndCall(want, first, false)
+	want.Push(0).Op(vm.POP)
+	createAndCall(want, []byte{0xfe}, true)
+	want.Mstore([]byte{1, 2, 3}, 0)
+	if got := found[1].replace(insts, []byte{0xfe}); !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("replaced code is\n%x, want\n%x", got, want.Bytes())
+	}
+}
+
+// TestMinimizeEmbedded checks that minimization reaches into the runtime code
+// a program deploys.
+func TestMinimizeEmbedded(t *testing.T) {
+	marker := []byte{byte(vm.PUSH1), 0x42, byte(vm.POP)}
+	runtime := append(deadCode(), marker...)
+	code := createAndCall(program.New(), deployer(runtime), true).Bytes()
+	// Keep any program that deploys runtime code using the marker.
+	keep := func(code []byte) bool {
+		found := findEmbeddings(mutator.Disassemble(code))
+		if len(found) != 1 {
+			return false
+		}
+		runtime, ok := deployedRuntime(found[0].code)
+		return ok && bytes.Contains(runtime, marker)
+	}
+	probes := 1000
+	minimized := shrinkCode(code, keep, &probes)
+	// The POP after the CREATE2 doesn't matter to keep either.
+	want := createAndCall(program.New(), deployer(marker), true).Bytes()
+	want = want[:len(want)-1]
+	if !bytes.Equal(minimized, want) {
+		t.Errorf("minimized to\n%x, want\n%x", minimized, want)
+	}
+}
+
+// TestMinimizeAccounts checks that every account is minimized, not just the
+// one with the longest code.
+func TestMinimizeAccounts(t *testing.T) {
+	callee := common.Address{0xca, 0x11, 0xee}
+	jumpOver := func(extra int) []byte {
+		dead := deadCode()[:extra*4]
+		p := program.New()
+		p.Push(p.Size() + 4 + len(dead)).Op(vm.JUMP).Append(dead)
+		return p.Op(vm.JUMPDEST).Sstore(1, 2).Bytes()
+	}
+	caller := program.New().Call(nil, callee, 0, 0, 0, 0, 0).Append(jumpOver(90)).Bytes()
+	gst := generator.CreateGstMaker(filler.NewFiller([]byte("minimizeminimizeminimize")), caller)
+	gst.AddAccount(callee, fuzzing.GenesisAccount{
+		Code:    jumpOver(80),
+		Balance: new(big.Int),
+		Storage: make(map[common.Hash]common.Hash),
+	})
+
+	minimized, code, err := MinimizeProgram(gst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) >= len(caller) {
+		t.Errorf("caller not minimized: %x", code)
+	}
+	pre := (*minimized.ToGeneralStateTest("name"))["name"].Pre
+	if got := pre[callee].Code; len(got) >= 100 {
+		t.Errorf("callee not minimized: %x", got)
+	}
+}
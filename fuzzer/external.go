@@ -0,0 +1,186 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/holiman/goevmlab/evms"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// EVMEnvKey names the environment variable that passes the external EVMs to
+// the fuzzing workers, as a comma separated list of kind=path entries.
+const EVMEnvKey = "FUZZYEVMS"
+
+// evmKinds maps the client names accepted by ParseEVMs to goevmlab's runners.
+var evmKinds = map[string]func(path, name string) evms.Evm{
+	"besu":       evms.NewBesuVM,
+	"eels":       evms.NewEelsEVM,
+	"erigon":     evms.NewErigonVM,
+	"evmone":     evms.NewEvmoneVM,
+	"geth":       evms.NewGethEVM,
+	"nethermind": evms.NewNethermindVM,
+	"nimbus":     evms.NewNimbusEVM,
+	"revme":      evms.NewRethVM,
+}
+
+// externalVMs are the EVMs every new test is run on, set from EVMEnvKey.
+var externalVMs []evms.Evm
+
+// ParseEVMs turns kind=path entries (e.g. geth=/usr/bin/evm) into goevmlab
+// runners. A kind may be given more than once; each runner is named after its
+// kind and position so traces from two builds of one client can be told apart.
+func ParseEVMs(specs []string) ([]evms.Evm, error) {
+	var vms []evms.Evm
+	for i, spec := range specs {
+		kind, path, ok := strings.Cut(spec, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid evm %q, want kind=/path/to/binary", spec)
+		}
+		newVM, ok := evmKinds[kind]
+		if !ok {
+			kinds := slices.Sorted(maps.Keys(evmKinds))
+			return nil, fmt.Errorf("unknown evm kind %q, want one of %v", kind, strings.Join(kinds, ", "))
+		}
+		vms = append(vms, newVM(path, fmt.Sprintf("%v-%d", kind, i)))
+	}
+	return vms, nil
+}
+
+// RunExternal runs the named test on every vm and compares their EIP-3155
+// traces against each other and their state roots against the one geth
+// computes in-process, so a single external EVM is already checked. On a
+// discrepancy the test, every trace, the seed that generated the test and a
+// report are saved to dir, and RunExternal returns true.
+func RunExternal(vms []evms.Evm, dir string, test *fuzzing.GeneralStateTest, name string, seed []byte) (bool, error) {
+	tmp, err := os.MkdirTemp("", "fuzzyvm-evms-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tmp)
+	data, err := json.Marshal(test)
+	if err != nil {
+		return false, err
+	}
+	testPath := filepath.Join(tmp, name+".json")
+	if err := os.WriteFile(testPath, data, 0644); err != nil {
+		return false, err
+	}
+	traces, errs := runVMs(vms, testPath)
+
+	var report strings.Builder
+	readers := make([]io.Reader, len(traces))
+	for i, trace := range traces {
+		readers[i] = bytes.NewReader(trace)
+	}
+	if eq, _, diff := evms.CompareFiles(vms, readers); !eq {
+		fmt.Fprintf(&report, "Trace mismatch:\n%v", diff)
+	}
+	st, err := toStateTest(test, name)
+	if err != nil {
+		return false, err
+	}
+	want := execute(st, execMatrix[0]).Root.Hex()
+	for i, trace := range traces {
+		// An EVM that wrote no root failed rather than computed another one.
+		if root, err := traceRoot(trace); err != nil {
+			fmt.Fprintf(&report, "%v: %v\n", vms[i].Name(), err)
+		} else if root != want {
+			fmt.Fprintf(&report, "%v: state root %v, want %v\n", vms[i].Name(), root, want)
+		}
+	}
+	if report.Len() == 0 {
+		return false, nil
+	}
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(&report, "%v exited with: %v\n", vms[i].Name(), err)
+		}
+	}
+	fmt.Printf("Discrepancy found in %v:\n%v", name, report.String())
+
+	path := filepath.Join(dir, name)
+	files := map[string][]byte{
+		path + ".json": data,
+		path + ".txt":  []byte(report.String()),
+		path + ".seed": seed,
+	}
+	for i, vm := range vms {
+		files[fmt.Sprintf("%v-%v.jsonl", path, vm.Name())] = traces[i]
+	}
+	for file, content := range files {
+		if err := os.WriteFile(file, content, 0755); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// runVMs runs the test at path on every vm in parallel, returning their
+// canonicalized trace output and exit errors.
+func runVMs(vms []evms.Evm, path string) ([][]byte, []error) {
+	var (
+		wg     sync.WaitGroup
+		traces = make([][]byte, len(vms))
+		errs   = make([]error, len(vms))
+	)
+	for i, vm := range vms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			_, errs[i] = vm.RunStateTest(path, &buf, false)
+			traces[i] = buf.Bytes()
+		}()
+	}
+	wg.Wait()
+	return traces, errs
+}
+
+// errNoStateRoot is returned for a trace that doesn't end in a state root.
+var errNoStateRoot = errors.New("no state root in trace")
+
+// traceRoot returns the state root from the last line of a canonicalized
+// trace, where the goevmlab runners put it.
+func traceRoot(trace []byte) (string, error) {
+	lines := bytes.Split(bytes.TrimSpace(trace), []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return "", errNoStateRoot
+	}
+	var root struct {
+		StateRoot string `json:"stateRoot"`
+	}
+	if err := json.Unmarshal(last, &root); err != nil {
+		return "", fmt.Errorf("reading the state root: %w", err)
+	}
+	if root.StateRoot == "" {
+		return "", errNoStateRoot
+	}
+	return root.StateRoot, nil
+}
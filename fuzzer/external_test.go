@@ -0,0 +1,147 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+var externalSeed = []byte("externalexternalexternalexternalexternal")
+
+// cannedTrace returns the trace geth's `evm statetest --trace` prints for the
+// test generated from externalSeed, ending in the given state root.
+func cannedTrace(t *testing.T, root common.Hash) (*fuzzing.GeneralStateTest, []byte) {
+	t.Helper()
+	testMaker, _ := generator.GenerateProgram(filler.NewFiller(externalSeed))
+	var trace bytes.Buffer
+	if err := testMaker.Fill(&trace, maxTraceSize); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(&trace, "{\"stateRoot\": \"%v\"}\n", root.Hex())
+	return testMaker.ToGeneralStateTest("external"), trace.Bytes()
+}
+
+// stubEVM writes a script that ignores its arguments and replays trace on
+// stderr, the way geth's evm binary reports a traced state test.
+func stubEVM(t *testing.T, trace []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	tracePath := filepath.Join(dir, "trace.jsonl")
+	if err := os.WriteFile(tracePath, trace, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "evm")
+	script := fmt.Sprintf("#!/bin/sh\ncat %q >&2\n", tracePath)
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+// gethRoot returns the post-state root the in-process geth computes for test.
+func gethRoot(t *testing.T, test *fuzzing.GeneralStateTest) common.Hash {
+	t.Helper()
+	st, err := toStateTest(test, "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return execute(st, execMatrix[0]).Root
+}
+
+func TestParseEVMs(t *testing.T) {
+	vms, err := ParseEVMs([]string{"geth=/bin/evm", "geth=/bin/evm2", "besu=/bin/besu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 3 || vms[1].Name() != "geth-1" {
+		t.Fatalf("unexpected vms: %v", vms)
+	}
+	for _, bad := range []string{"geth", "geth=", "parity=/bin/parity"} {
+		if _, err := ParseEVMs([]string{bad}); err == nil {
+			t.Errorf("ParseEVMs(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRunExternal(t *testing.T) {
+	test, _ := cannedTrace(t, common.Hash{})
+	root := gethRoot(t, test)
+	test, good := cannedTrace(t, root)
+	// Drop the first opcode, as a client skipping a step would.
+	lines := bytes.SplitAfter(good, []byte("\n"))
+	skipped := bytes.Join(lines[1:], nil)
+	_, wrongRoot := cannedTrace(t, common.Hash{0x01})
+	// Drop the root, as a client that crashed at the end would.
+	noRoot := bytes.Join(lines[:len(lines)-2], nil)
+
+	for _, tt := range []struct {
+		name     string
+		traces   [][]byte
+		mismatch bool
+		report   string // in the report of a mismatch
+	}{
+		{"agree", [][]byte{good, good}, false, ""},
+		{"single", [][]byte{good}, false, ""},
+		{"trace", [][]byte{good, skipped}, true, "Trace mismatch"},
+		{"root", [][]byte{wrongRoot}, true, "state root " + common.Hash{0x01}.Hex()},
+		{"noroot", [][]byte{noRoot}, true, "no state root in trace"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var specs []string
+			for _, trace := range tt.traces {
+				specs = append(specs, "geth="+stubEVM(t, trace))
+			}
+			vms, err := ParseEVMs(specs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			found, err := RunExternal(vms, dir, test, "external", externalSeed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.mismatch {
+				t.Fatalf("RunExternal = %v, want %v", found, tt.mismatch)
+			}
+			entries, _ := os.ReadDir(dir)
+			if want := 3 + len(vms); tt.mismatch && len(entries) != want {
+				t.Fatalf("saved %d files, want %d", len(entries), want)
+			} else if !tt.mismatch && len(entries) != 0 {
+				t.Fatalf("saved %d files without a discrepancy", len(entries))
+			}
+			if tt.mismatch {
+				seed, err := os.ReadFile(filepath.Join(dir, "external.seed"))
+				if err != nil || !bytes.Equal(seed, externalSeed) {
+					t.Fatalf("seed not saved: %v", err)
+				}
+				report, err := os.ReadFile(filepath.Join(dir, "external.txt"))
+				if err != nil || !bytes.Contains(report, []byte(tt.report)) {
+					t.Fatalf("report %q lacks %q: %v", report, tt.report, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// FillEnvKey names the environment variable that makes Fuzz store filled
+// tests, see FillTest.
+const FillEnvKey = "FUZZYFILL"
+
+// fillTests is set from FillEnvKey.
+var fillTests = false
+
+// filledPost is one post state of a filled test, in the layout of the tests in
+// ethereum/tests.
+type filledPost struct {
+	Root            common.Hash `json:"hash"`
+	Logs            common.Hash `json:"logs"`
+	ExpectException string      `json:"expectException,omitempty"`
+	Indexes         struct {
+		Data  int `json:"data"`
+		Gas   int `json:"gas"`
+		Value int `json:"value"`
+	} `json:"indexes"`
+}
+
+// exceptions names the errors go-ethereum rejects transactions with the way
+// the tests in ethereum/tests expect them.
+var exceptions = []struct {
+	err  error
+	name string
+}{
+	{core.ErrNonceTooLow, "TransactionException.NONCE_MISMATCH_TOO_LOW"},
+	{core.ErrNonceTooHigh, "TransactionException.NONCE_MISMATCH_TOO_HIGH"},
+	{core.ErrNonceMax, "TransactionException.NONCE_IS_MAX"},
+	{core.ErrGasLimitReached, "TransactionException.GAS_ALLOWANCE_EXCEEDED"},
+	{core.ErrGasLimitTooHigh, "TransactionException.GAS_LIMIT_EXCEEDS_MAXIMUM"},
+	{core.ErrInsufficientFunds, "TransactionException.INSUFFICIENT_ACCOUNT_FUNDS"},
+	{core.ErrInsufficientFundsForTransfer, "TransactionException.INSUFFICIENT_ACCOUNT_FUNDS"},
+	{core.ErrIntrinsicGas, "TransactionException.INTRINSIC_GAS_TOO_LOW"},
+	{core.ErrFloorDataGas, "TransactionException.INTRINSIC_GAS_BELOW_FLOOR_GAS_COST"},
+	{core.ErrTipAboveFeeCap, "TransactionException.PRIORITY_GREATER_THAN_MAX_FEE_PER_GAS"},
+	{core.ErrFeeCapTooLow, "TransactionException.INSUFFICIENT_MAX_FEE_PER_GAS"},
+	{core.ErrSenderNoEOA, "TransactionException.SENDER_NOT_EOA"},
+	{core.ErrBlobFeeCapTooLow, "TransactionException.INSUFFICIENT_MAX_FEE_PER_BLOB_GAS"},
+	{core.ErrMissingBlobHashes, "TransactionException.TYPE_3_TX_ZERO_BLOBS"},
+	{core.ErrBlobTxCreate, "TransactionException.TYPE_3_TX_CONTRACT_CREATION"},
+	{core.ErrEmptyAuthList, "TransactionException.TYPE_4_EMPTY_AUTHORIZATION_LIST"},
+	{core.ErrSetCodeTxCreate, "TransactionException.TYPE_4_TX_CONTRACT_CREATION"},
+}
+
+// exception names the error go-ethereum rejected a transaction with, or
+// returns the error itself if ethereum/tests has no name for it.
+func exception(err error) string {
+	for _, e := range exceptions {
+		if errors.Is(err, e.err) {
+			return e.name
+		}
+	}
+	return err.Error()
+}
+
+// FillTest runs every subtest of the named test, for every fork, through
+// go-ethereum and fills in what it computes: the post-state root, the logs
+// hash and, if the transaction is rejected, the exception to expect. It checks
+// the result with geth's own state test runner, and returns it as JSON in the
+// layout of the tests in ethereum/tests.
+func FillTest(test *fuzzing.GeneralStateTest, name string) ([]byte, error) {
+	sub, ok := (*test)[name]
+	if !ok {
+		return nil, fmt.Errorf("no test named %q", name)
+	}
+	st, err := toStateTest(test, name)
+	if err != nil {
+		return nil, err
+	}
+	post := make(map[string][]filledPost, len(sub.Post))
+	for _, fork := range slices.Sorted(maps.Keys(sub.Post)) {
+		for i, p := range sub.Post[fork] {
+			filled, err := fillPost(st, tests.StateSubtest{Fork: fork, Index: i}, sub.Env.Number)
+			if err != nil {
+				return nil, fmt.Errorf("could not fill %v/%d: %w", fork, i, err)
+			}
+			filled.Indexes.Data, filled.Indexes.Gas, filled.Indexes.Value = p.Indexes.Data, p.Indexes.Gas, p.Indexes.Value
+			post[fork] = append(post[fork], filled)
+		}
+	}
+	// Swap the post section of the test for the filled one, leaving the rest
+	// as goevmlab writes it.
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields["post"], err = json.Marshal(post); err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	if err := validateFilled(data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]json.RawMessage{name: data})
+}
+
+// fillPost runs one subtest of a test for block number and returns the post
+// state it leaves behind. A rejected transaction leaves the pre-state, which
+// geth's runner checks the root of too.
+func fillPost(st *tests.StateTest, subtest tests.StateSubtest, number uint64) (filledPost, error) {
+	var filled filledPost
+	state, root, _, err := st.RunNoVerify(subtest, vm.Config{}, false, rawdb.HashScheme)
+	defer state.Close()
+	switch {
+	case err != nil:
+		// An unsupported fork fails here, before the state is needed.
+		config, _, cerr := tests.GetChainConfig(subtest.Fork)
+		if cerr != nil {
+			return filled, cerr
+		}
+		filled.Root = state.StateDB.IntermediateRoot(config.IsEIP158(new(big.Int).SetUint64(number)))
+		filled.Logs = logsHash(nil)
+		filled.ExpectException = exception(err)
+	default:
+		filled.Root = root
+		filled.Logs = logsHash(state.StateDB.Logs())
+	}
+	return filled, nil
+}
+
+// validateFilled runs every subtest of a filled test through geth's state test
+// runner, which checks the post-state root, the logs hash and the exception.
+func validateFilled(data []byte) error {
+	var st tests.StateTest
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	for _, subtest := range st.Subtests() {
+		if err := st.Run(subtest, vm.Config{}, false, rawdb.HashScheme, func(error, *tests.StateTestState) {}); err != nil {
+			return fmt.Errorf("filled test fails %v/%d: %w", subtest.Fork, subtest.Index, err)
+		}
+	}
+	return nil
+}
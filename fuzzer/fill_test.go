@@ -0,0 +1,75 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+func TestFillTest(t *testing.T) {
+	code := program.New().Sstore(1, 2).Op(vm.LOG0).Bytes()
+	test := generator.CreateGstMaker(filler.NewFiller([]byte("fillfillfillfillfillfillfillfill")), code).ToGeneralStateTest("name")
+	sub := (*test)["name"]
+	// A second subtest whose transaction is rejected.
+	sub.Tx.GasLimit = append(sub.Tx.GasLimit, 0)
+	for fork, posts := range sub.Post {
+		rejected := posts[0]
+		rejected.Indexes.Gas = 1
+		sub.Post[fork] = append(posts, rejected)
+	}
+	filled, err := FillTest(test, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]struct {
+		Post map[string][]filledPost `json:"post"`
+	}
+	if err := json.Unmarshal(filled, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	for fork, posts := range parsed["name"].Post {
+		if len(posts) != 2 {
+			t.Fatalf("%v: %d post states, want 2", fork, len(posts))
+		}
+		if posts[0].ExpectException != "" || posts[0].Root == (filledPost{}).Root {
+			t.Errorf("%v: executed transaction filled as %+v", fork, posts[0])
+		}
+		if posts[1].ExpectException != "TransactionException.INTRINSIC_GAS_TOO_LOW" {
+			t.Errorf("%v: rejected transaction filled as %+v", fork, posts[1])
+		}
+	}
+	// A test that geth's runner disagrees with doesn't validate.
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(filled, &fields); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := bytes.Replace(fields["name"], []byte(`"expectException":"TransactionException.INTRINSIC_GAS_TOO_LOW",`), nil, 1)
+	if err := validateFilled(corrupt); err == nil {
+		t.Error("test without the expected exception validated")
+	}
+	if err := validateFilled(fields["name"]); err != nil {
+		t.Error(err)
+	}
+}
@@ -19,38 +19,68 @@ package fuzzer
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"sort"
+	"slices"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/rawdb"
-	"github.com/ethereum/go-ethereum/core/vm"
-	"github.com/ethereum/go-ethereum/tests"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
 	"github.com/holiman/goevmlab/fuzzing"
 	"golang.org/x/crypto/sha3"
 
 	"github.com/MariusVanDerWijden/FuzzyVM/filler"
 	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/sink"
 )
 
 var (
 	outputDir   = "out"
+	crashDir    = "crashes"
 	EnvKey      = "FUZZYDIR"
+	CrashEnvKey = "FUZZYCRASHDIR"
+	SinkEnvKey  = "FUZZYSINK"
 	shouldTrace = false
+	testSink    sink.Sink
 )
 
 // SetFuzzyVMDir sets the output directory for FuzzyVM
 // If the environment variable FUZZYDIR is set, the output directory
 // will be set to that, otherwise it will be set to a temp dir (for unit tests)
+// The crashes directory is set from FUZZYCRASHDIR the same way, and tests
+// are stored filled if FUZZYFILL is set. If FUZZYSINK names the socket of a
+// sink the parent process serves, tests are stored there instead of in the
+// output directory.
 func SetFuzzyVMDir() {
 	if dir, ok := os.LookupEnv(EnvKey); ok {
 		outputDir = dir
 	} else {
 		outputDir = os.TempDir()
 	}
+	if dir, ok := os.LookupEnv(CrashEnvKey); ok {
+		crashDir = dir
+	} else {
+		crashDir = os.TempDir()
+	}
+	fillTests = os.Getenv(FillEnvKey) != ""
+	if addr := os.Getenv(SinkEnvKey); addr != "" {
+		remote, err := sink.Dial(addr)
+		if err != nil {
+			panic(err)
+		}
+		testSink = remote
+	}
+	// The parent process validated the EVMs already, so a failure here is a bug.
+	if specs, ok := os.LookupEnv(EVMEnvKey); ok && specs != "" {
+		vms, err := ParseEVMs(strings.Split(specs, ","))
+		if err != nil {
+			panic(err)
+		}
+		externalVMs = vms
+	}
 }
 
 func FuzzStateless(data []byte) int {
@@ -69,7 +99,7 @@ func Fuzz(data []byte) int {
 		return -1
 	}
 	f := filler.NewFiller(data)
-	testMaker, _ := generator.GenerateProgram(f)
+	testMaker, code, invocations := generator.RecordProgram(f)
 	// Minimize the test. MinimizeProgram runs a full Fill internally, so it is
 	// also our execution check: if it succeeds, the test is fillable.
 	minimized, _, err := MinimizeProgram(testMaker)
@@ -98,9 +128,48 @@ func Fuzz(data []byte) int {
 			return 0
 		}
 	}
-	// Save the test
+	// Check the invariants every correct EVM upholds. A violation is stored
+	// like any other test, under a name that stands out, with the report
+	// next to it.
 	test := testMaker.ToGeneralStateTest(finalName)
-	dup, err := storeTest(test, hashed, finalName)
+	violations, err := CheckInvariants(test, finalName)
+	if err != nil {
+		fmt.Printf("skipping invariant checks: %v\n", err)
+	}
+	// Save the test, filled by geth if requested.
+	if len(violations) > 0 {
+		finalName = fmt.Sprintf("FuzzyVM-invariant-%v", common.Bytes2Hex(hashed))
+		fmt.Printf("Invariant violated in %v:\n%v\n", finalName, strings.Join(violations, "\n"))
+		if explained := strategyReport(data, ViolatesInvariants); explained != "" {
+			violations = append(violations, explained)
+		}
+		test = testMaker.ToGeneralStateTest(finalName)
+	}
+	var stored any = test
+	if fillTests {
+		filled, err := FillTest(test, finalName)
+		if err == nil {
+			stored = json.RawMessage(filled)
+		} else {
+			// geth can't fill or pass the test it just executed, which is a
+			// finding of its own: keep the test unfilled, with the reason.
+			fmt.Printf("Could not fill %v: %v\n", finalName, err)
+			if len(violations) == 0 {
+				finalName = fmt.Sprintf("FuzzyVM-unfillable-%v", common.Bytes2Hex(hashed))
+				test = testMaker.ToGeneralStateTest(finalName)
+				stored = test
+			}
+			violations = append(violations, fmt.Sprintf("could not fill the test: %v", err))
+		}
+	}
+	// Record how the test was made next to it.
+	meta := NewProvenance(data, invocations, code, test, finalName)
+	var dup bool
+	if len(violations) > 0 {
+		dup, err = storeFinding(stored, hashed, finalName, violations, meta)
+	} else {
+		dup, err = storeTest(stored, hashed, finalName, meta)
+	}
 	if err != nil {
 		// A filesystem problem is not a reason to crash the campaign.
 		fmt.Printf("skipping test that could not be stored: %v\n", err)
@@ -109,6 +178,22 @@ func Fuzz(data []byte) int {
 	if dup {
 		return 0
 	}
+	// Run the new test under every geth configuration; they must agree.
+	report, err := Differential(test, finalName)
+	if err != nil {
+		fmt.Printf("skipping differential run: %v\n", err)
+	} else if report != "" {
+		fmt.Printf("Discrepancy found in %v:\n%v", finalName, report)
+		report += strategyReport(data, SameDiscrepancy)
+		if err := storeCrash(test, finalName, report); err != nil {
+			fmt.Printf("could not store discrepancy: %v\n", err)
+		}
+	}
+	if len(externalVMs) > 0 {
+		if _, err := RunExternal(externalVMs, crashDir, test, finalName, data); err != nil {
+			fmt.Printf("could not run external evms: %v\n", err)
+		}
+	}
 	if f.UsedUp() {
 		return 0
 	}
@@ -142,13 +227,15 @@ const (
 var ErrTraceTooLarge = errors.New("trace too large to minimize")
 
 // cappedBuffer buffers writes and flags overflow once it comes within
-// traceSizeMargin of maxTraceSize.
+// traceSizeMargin of maxTraceSize. Every trace line is written to it as the
+// test executes, so it also checks the budget of the input being handled.
 type cappedBuffer struct {
 	buf      bytes.Buffer
 	overflow bool
 }
 
 func (c *cappedBuffer) Write(p []byte) (int, error) {
+	checkWatch()
 	if c.overflow || c.buf.Len()+len(p) > maxTraceSize-traceSizeMargin {
 		c.overflow = true
 		return len(p), nil
@@ -156,86 +243,156 @@ func (c *cappedBuffer) Write(p []byte) (int, error) {
 	return c.buf.Write(p)
 }
 
+// MinimizeProgram minimizes the code of a test while it executes the same
+// instructions, see Minimize and SameRelocatedTrace.
 func MinimizeProgram(test *fuzzing.GstMaker) (*fuzzing.GstMaker, []byte, error) {
+	return Minimize(test, SameRelocatedTrace)
+}
+
+// ExecSummary sums up an execution of a program.
+type ExecSummary struct {
+	GasUsed uint64
+	Steps   uint64
+	// Halt is the error the outermost call frame ended with, or why the
+	// transaction is invalid; empty if it succeeded.
+	Halt string
+}
+
+// MinimizeProgramSummarized is MinimizeProgram that also sums up how the
+// program executes, which its minimized form does alike. The summary comes
+// from the execution minimization starts with, so it costs none of its own
+// unless the program is too short to minimize.
+func MinimizeProgramSummarized(test *fuzzing.GstMaker) (*fuzzing.GstMaker, []byte, *ExecSummary, error) {
+	var summary *ExecSummary
+	// The first observation is of the original program.
+	pred := sameObservation(func(test *fuzzing.GeneralStateTest, name string) (uint64, error) {
+		tr := newRelocatableHashTracer()
+		sum, err := traceHash(test, name, tr)
+		if summary == nil && err == nil {
+			summary = tr.summary()
+		}
+		return sum, err
+	})
+	minimized, code, err := Minimize(test, pred)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if summary == nil {
+		tr := newRelocatableHashTracer()
+		if _, err := traceHash(test.ToGeneralStateTest(""), "", tr); err != nil {
+			return nil, nil, nil, err
+		}
+		summary = tr.summary()
+	}
+	return minimized, code, summary, nil
+}
+
+// Minimize shrinks the code of every account in test while pred holds: each to
+// its shortest prefix first, then by dropping instructions anywhere and
+// minimizing the init code it deploys. It returns the test with the minimized
+// codes, and the minimized code of the account whose code was the longest.
+func Minimize(test *fuzzing.GstMaker, pred Predicate) (*fuzzing.GstMaker, []byte, error) {
+	name := ""
+	gstPtr := test.ToGeneralStateTest(name)
+	gst := (*gstPtr)
+	// Programs whose trace is too large to compare aren't minimized. Like
+	// every execution that follows, this one is watched.
 	original := new(cappedBuffer)
-	if err := test.Fill(original, maxTraceSize); err != nil {
+	_, done, err := runTraced(gstPtr, name, logger.NewJSONLogger(&logger.Config{Limit: maxTraceSize}, original))
+	done()
+	if err != nil {
 		return nil, nil, err
 	}
 	if original.overflow {
 		return nil, nil, ErrTraceTooLarge
 	}
-	name := ""
-	gstPtr := test.ToGeneralStateTest(name)
-	gst := (*gstPtr)
-	var addr common.Address
-	var code []byte
+	// Minimize every account's code, the longest first. Programs this short
+	// aren't worth the re-executions.
+	var (
+		accounts []common.Address
+		longest  []byte
+	)
 	for ad, acc := range gst[name].Pre {
-		if len(acc.Code) > len(code) {
-			code = acc.Code
-			addr = ad
+		if len(acc.Code) >= minMinimizeSize {
+			accounts = append(accounts, ad)
+		}
+		if len(acc.Code) > len(longest) {
+			longest = acc.Code
 		}
 	}
-	// Programs this short aren't worth the re-executions.
-	if len(code) < minMinimizeSize {
-		return test, code, nil
+	if len(accounts) == 0 {
+		return test, longest, nil
+	}
+	slices.SortFunc(accounts, func(a, b common.Address) int {
+		return cmp.Or(len(gst[name].Pre[b].Code)-len(gst[name].Pre[a].Code), a.Cmp(b))
+	})
+	holds, err := pred(gstPtr, name)
+	if err != nil {
+		return nil, nil, err
 	}
-	traceHash := func(n int) (sum uint64, ok bool) {
+	setCode := func(addr common.Address, code []byte) {
 		acc := gst[name].Pre[addr]
-		acc.Code = code[0:n]
+		acc.Code = code
 		gst[name].Pre[addr] = acc
-		var gethStateTest tests.StateTest
-		data, err := json.Marshal(gst[name])
-		if err != nil {
-			panic(err)
+	}
+	probes := maxMinimizeProbes
+	for i, addr := range accounts {
+		code := gst[name].Pre[addr].Code
+		keep := func(candidate []byte) bool {
+			setCode(addr, candidate)
+			defer setCode(addr, code)
+			return holds(gstPtr)
 		}
-		if err := json.Unmarshal(data, &gethStateTest); err != nil {
-			panic(err)
+		// Fast first pass: the shortest prefix for which pred still holds.
+		foundLength := prefix(code, keep, &probes)
+		// Then drop instructions anywhere in it, and minimize the init code
+		// it deploys.
+		minimized := shrinkCode(code[0:foundLength], keep, &probes)
+		setCode(addr, minimized)
+		test.SetCode(addr, minimized)
+		if i == 0 {
+			longest = minimized
 		}
-		tr := newHashTracer()
-		state, _, _, _ := gethStateTest.RunNoVerify(gethStateTest.Subtests()[0], vm.Config{Tracer: tr.hooks()}, false, rawdb.HashScheme)
-		// Close the state db RunNoVerify returns, or its trie db (and any snapshot
-		// goroutine) leaks across every probe. Close is nil-safe.
-		state.Close()
-		return tr.sum, !tr.overflow
-	}
-	orgHash, _ := traceHash(len(code))
-	foundLength := sort.Search(len(code), func(i int) bool {
-		sum, ok := traceHash(i)
-		return ok && sum == orgHash
-	})
-	if foundLength+100 < len(code) {
-		// Add some bytes to make it easier to proof differences in execution
-		foundLength += 100
 	}
-	test.SetCode(addr, code[0:foundLength])
-	return test, code[0:foundLength], nil
+	return test, longest, nil
 }
 
-// storeTest saves a testcase to disk. It returns (duplicate, err): duplicate is
-// true if the test was already present. A filesystem error (disk full,
-// permissions, …) is returned rather than panicked, so a transient problem
-// mid-campaign is skipped and logged instead of crashing the fuzzer (and being
-// misreported by the harness as a discrepancy).
-func storeTest(test *fuzzing.GeneralStateTest, hashed []byte, testName string) (bool, error) {
-	path := fmt.Sprintf("%v/%02x/%v.json", outputDir, hashed[0], testName)
-	// check if the test is already on disk
-	if _, err := os.Stat(path); err == nil {
-		fmt.Println("Duplicate test found")
-		return true, nil
-	} else if !os.IsNotExist(err) {
-		return false, err
-	}
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0755)
+// storeTest saves a testcase, encoded as JSON, to the output sink, with its
+// provenance if meta is not nil. It returns
+// (duplicate, err): duplicate is true if the test was already present. A
+// storage error (disk full, permissions, …) is returned rather than panicked,
+// so a transient problem mid-campaign is skipped and logged instead of
+// crashing the fuzzer (and being misreported by the harness as a discrepancy).
+func storeTest(test any, hashed []byte, testName string, meta *Provenance) (bool, error) {
+	return store(test, hashed, testName, "", meta)
+}
+
+// store saves a testcase to the output sink, with the report of a finding.
+func store(test any, hashed []byte, testName, report string, meta *Provenance) (bool, error) {
+	data, err := json.Marshal(test)
 	if err != nil {
-		return false, fmt.Errorf("could not open test file %q: %w", testName, err)
-	}
-	defer f.Close()
-	// Write to file
-	encoder := json.NewEncoder(f)
-	if err = encoder.Encode(test); err != nil {
 		return false, fmt.Errorf("could not encode state test %q: %w", testName, err)
 	}
-	return false, nil
+	var metaData []byte
+	if meta != nil {
+		if metaData, err = json.Marshal(meta); err != nil {
+			return false, fmt.Errorf("could not encode provenance of %q: %w", testName, err)
+		}
+	}
+	dup, err := output().Store(sink.Test{Name: testName, Hash: hashed, JSON: data, Report: report, Meta: metaData})
+	if dup {
+		fmt.Println("Duplicate test found")
+	}
+	return dup, err
+}
+
+// output returns the sink tests are stored in: the one the parent process
+// serves, or else the output directory.
+func output() sink.Sink {
+	if testSink != nil {
+		return testSink
+	}
+	return sink.NewDir(outputDir)
 }
 
 func hash(test *fuzzing.GeneralStateTest) []byte {
@@ -127,7 +127,7 @@ func TestMinimizeProgram(t *testing.T) {
 	// Save the test
 	test := testMaker.ToGeneralStateTest("name")
 	hashed := hash(testMaker.ToGeneralStateTest("hashName"))
-	if _, err := storeTest(test, hashed, "name"); err != nil {
+	if _, err := storeTest(test, hashed, "name", nil); err != nil {
 		t.Fatal(err)
 	}
 	// minimize
@@ -139,7 +139,26 @@ func TestMinimizeProgram(t *testing.T) {
 	_ = minTest
 	fmt.Printf("%v", minTest)
 	minHashed := hash(testMaker.ToGeneralStateTest("hashName"))
-	if _, err := storeTest(minTest, minHashed, "name_min"); err != nil {
+	if _, err := storeTest(minTest, minHashed, "name_min", nil); err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestMinimizeProgramSummarized(t *testing.T) {
+	f := filler.NewFiller([]byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf"))
+	testMaker, _ := generator.GenerateProgram(f)
+	_, _, summary, err := MinimizeProgramSummarized(testMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The summary must match a separate execution of the program.
+	p := NewProvenance(nil, nil, nil, testMaker.ToGeneralStateTest("name"), "name")
+	var steps uint64
+	for _, n := range p.Opcodes {
+		steps += n
+	}
+	want := ExecSummary{GasUsed: p.GasUsed, Steps: steps, Halt: p.Halt}
+	if *summary != want {
+		t.Errorf("summary mismatch: have %+v, want %+v", *summary, want)
+	}
+}
@@ -18,7 +18,11 @@
 
 package fuzzer
 
-import "github.com/ethereum/go-ethereum/core/tracing"
+import (
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
 
 // maxTraceSteps bounds how many opcodes a single probe may trace during
 // minimization.
@@ -34,18 +38,49 @@ type hashTracer struct {
 	sum      uint64
 	steps    int
 	overflow bool
+
+	// gasUsed and halt sum up the execution along with steps, see summary.
+	gasUsed uint64
+	halt    string
+
+	// relocatable leaves out what moves when code is removed in front of the
+	// executed instructions: the pc and the destination a JUMP or JUMPI pops.
+	// Two runs then hash equal if they execute the same instructions on the
+	// same operands, wherever those instructions sit in the code.
+	relocatable bool
 }
 
 func newHashTracer() *hashTracer {
 	return &hashTracer{sum: 1469598103934665603} // FNV-1a offset basis
 }
 
+func newRelocatableHashTracer() *hashTracer {
+	h := newHashTracer()
+	h.relocatable = true
+	return h
+}
+
 func (h *hashTracer) fold(x uint64) {
 	h.sum = (h.sum ^ x) * 1099511628211 // FNV-1a prime
 }
 
+// summary sums up the execution the tracer traced.
+func (h *hashTracer) summary() *ExecSummary {
+	return &ExecSummary{GasUsed: h.gasUsed, Steps: uint64(h.steps), Halt: h.halt}
+}
+
 func (h *hashTracer) hooks() *tracing.Hooks {
 	return &tracing.Hooks{
+		OnExit: func(depth int, _ []byte, _ uint64, err error, _ bool) {
+			if depth == 0 && err != nil {
+				h.halt = err.Error()
+			}
+		},
+		OnTxEnd: func(receipt *types.Receipt, _ error) {
+			if receipt != nil {
+				h.gasUsed = receipt.GasUsed
+			}
+		},
 		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, _ []byte, depth int, _ error) {
 			if h.overflow {
 				return
@@ -54,7 +89,14 @@ func (h *hashTracer) hooks() *tracing.Hooks {
 				h.overflow = true
 				return
 			}
-			h.fold(pc)
+			stack := scope.StackData()
+			if h.relocatable {
+				if vm.OpCode(op) == vm.JUMP || vm.OpCode(op) == vm.JUMPI {
+					stack = stack[:max(len(stack)-1, 0)]
+				}
+			} else {
+				h.fold(pc)
+			}
 			h.fold(uint64(op))
 			h.fold(gas)
 			h.fold(cost)
@@ -62,7 +104,7 @@ func (h *hashTracer) hooks() *tracing.Hooks {
 			// Fold the whole stack so a prefix that reaches the same opcodes with
 			// different operands still counts as diverged — matching the fidelity
 			// of the JSON structlog this replaces (which included the stack).
-			for _, s := range scope.StackData() {
+			for _, s := range stack {
 				h.fold(s[0])
 				h.fold(s[1])
 				h.fold(s[2])
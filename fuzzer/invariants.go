@@ -0,0 +1,324 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// frame collects the creations and self-destructs of one call frame. They only
+// take effect if the frame and all its parents return without reverting.
+type frame struct {
+	created    map[common.Address]bool
+	destructed map[common.Address]*big.Int // burned value, if sent to itself
+}
+
+func newFrame() *frame {
+	return &frame{
+		created:    make(map[common.Address]bool),
+		destructed: make(map[common.Address]*big.Int),
+	}
+}
+
+func (f *frame) merge(child *frame) {
+	maps.Copy(f.created, child.created)
+	maps.Copy(f.destructed, child.destructed)
+}
+
+// invariantTracer records what the invariant checks need to know about an
+// execution beyond its post-state. State tests run on a plain StateDB, so only
+// the VM hooks fire: the accounts and slots an execution touched are gathered
+// from calls and SSTOREs rather than from state change events.
+type invariantTracer struct {
+	vmctx   *tracing.VMContext
+	frames  []*frame
+	outcome *frame // effective creations and self-destructs of the whole tx
+
+	touched map[common.Address]map[common.Hash]bool // accounts and storage slots
+	refund  uint64                                  // gas refunded at the end of the tx
+	spent   uint64                                  // gas used before the refund
+	limit   uint64
+
+	burnBound  *big.Int // most ether self-destructs may have burned
+	violations []string
+}
+
+func newInvariantTracer(gasLimit uint64) *invariantTracer {
+	return &invariantTracer{
+		touched:   make(map[common.Address]map[common.Hash]bool),
+		limit:     gasLimit,
+		burnBound: new(big.Int),
+	}
+}
+
+func (t *invariantTracer) touch(addr common.Address) map[common.Hash]bool {
+	if t.touched[addr] == nil {
+		t.touched[addr] = make(map[common.Hash]bool)
+	}
+	return t.touched[addr]
+}
+
+func (t *invariantTracer) violate(format string, args ...any) {
+	t.violations = append(t.violations, fmt.Sprintf(format, args...))
+}
+
+func (t *invariantTracer) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnTxStart: func(vmctx *tracing.VMContext, _ *types.Transaction, from common.Address) {
+			t.vmctx = vmctx
+			t.touch(from)
+			t.touch(vmctx.Coinbase)
+		},
+		OnEnter: func(depth int, typ byte, from, to common.Address, _ []byte, _ uint64, value *big.Int) {
+			t.touch(from)
+			t.touch(to)
+			f := newFrame()
+			switch vm.OpCode(typ) {
+			case vm.CREATE, vm.CREATE2:
+				f.created[to] = true
+			case vm.SELFDESTRUCT:
+				burned := new(big.Int)
+				if from == to && value != nil {
+					burned.Set(value)
+				}
+				f.destructed[from] = burned
+			}
+			t.frames = append(t.frames, f)
+		},
+		OnExit: func(depth int, _ []byte, _ uint64, _ error, reverted bool) {
+			f := t.frames[len(t.frames)-1]
+			t.frames = t.frames[:len(t.frames)-1]
+			if reverted {
+				f = newFrame()
+			}
+			if len(t.frames) > 0 {
+				t.frames[len(t.frames)-1].merge(f)
+				return
+			}
+			// The transaction is done, but not yet finalised: whatever a
+			// self-destructed new contract still holds may be burned.
+			t.outcome = f
+			for addr, burned := range f.destructed {
+				t.burnBound.Add(t.burnBound, burned)
+				if f.created[addr] {
+					t.burnBound.Add(t.burnBound, t.vmctx.StateDB.GetBalance(addr).ToBig())
+				}
+			}
+		},
+		OnGasChange: func(old, new uint64, reason tracing.GasChangeReason) {
+			if reason == tracing.GasChangeTxRefunds {
+				t.refund = new - old
+				t.spent = t.limit - old
+			}
+		},
+		OnOpcode: func(_ uint64, op byte, _, _ uint64, scope tracing.OpContext, _ []byte, _ int, _ error) {
+			if vm.OpCode(op) == vm.SSTORE {
+				if stack := scope.StackData(); len(stack) > 0 {
+					t.touch(scope.Address())[stack[len(stack)-1].Bytes32()] = true
+				}
+			}
+		},
+	}
+}
+
+// CheckInvariants executes the named test and checks consensus invariants that
+// must hold for any correct EVM, so they expose bugs without a second client:
+//
+//   - ether is conserved, except for the burned base fee and self-destruct burns
+//   - gas used never exceeds the gas limit
+//   - the refund is capped (EIP-3529 from London on)
+//   - nonces only increase
+//   - self-destructs obey EIP-6780 from Cancun on
+//   - the post-state reloaded from its root matches the in-memory state
+//
+// It returns one line per violation, or nil if the test is fine.
+func CheckInvariants(test *fuzzing.GeneralStateTest, name string) ([]string, error) {
+	run, err := runInvariants(test, name)
+	if run == nil || err != nil {
+		return nil, err
+	}
+	defer run.close()
+	if run.post == nil {
+		return []string{fmt.Sprintf("post-state root %x cannot be loaded: %v", run.root, run.loadErr)}, nil
+	}
+	return run.check(), nil
+}
+
+// invariantRun is an execution of a test, with everything the invariants are
+// checked against.
+type invariantRun struct {
+	tr      *invariantTracer
+	config  *params.ChainConfig
+	pre     fuzzing.GenesisAlloc
+	sender  common.Address
+	gasUsed uint64
+	mem     *state.StateDB // the post-state as the execution left it
+	post    *state.StateDB // the post-state reloaded from its root
+	root    common.Hash
+	loadErr error // why the post-state could not be reloaded
+	close   func()
+}
+
+// runInvariants executes the named test under an invariantTracer. It returns
+// nil if the transaction is invalid, as it then executes nothing to check.
+func runInvariants(test *fuzzing.GeneralStateTest, name string) (*invariantRun, error) {
+	st, err := toStateTest(test, name)
+	if err != nil {
+		return nil, err
+	}
+	subtest := st.Subtests()[0]
+	config, _, err := tests.GetChainConfig(subtest.Fork)
+	if err != nil {
+		return nil, err
+	}
+	tr := newInvariantTracer((*test)[name].Tx.GasLimit[0])
+	result, root, gasUsed, err := st.RunNoVerify(subtest, vm.Config{Tracer: tr.hooks()}, false, rawdb.HashScheme)
+	if err != nil || tr.outcome == nil {
+		result.Close()
+		return nil, nil
+	}
+	run := &invariantRun{
+		tr:      tr,
+		config:  config,
+		pre:     (*test)[name].Pre,
+		sender:  (*test)[name].Tx.Sender,
+		gasUsed: gasUsed,
+		mem:     result.StateDB,
+		root:    root,
+		close:   result.Close,
+	}
+	run.post, run.loadErr = state.New(root, result.StateDB.Database())
+	return run, nil
+}
+
+// check checks the invariants against the run and returns one line per
+// violation.
+func (r *invariantRun) check() []string {
+	var (
+		tr       = r.tr
+		pre      = r.pre
+		post     = r.post
+		gasUsed  = r.gasUsed
+		gasLimit = tr.limit
+	)
+	for addr, acc := range pre {
+		tr.touch(addr)
+		for slot := range acc.Storage {
+			tr.touch(addr)[slot] = true
+		}
+	}
+
+	// Gas used never exceeds the gas limit.
+	if gasUsed > gasLimit {
+		tr.violate("gas used %d exceeds the gas limit %d", gasUsed, gasLimit)
+	}
+	// The refund is capped at a fraction of the gas spent.
+	quotient := params.RefundQuotient
+	if r.config.IsLondon(new(big.Int)) {
+		quotient = params.RefundQuotientEIP3529
+	}
+	if tr.refund > tr.spent/quotient {
+		tr.violate("refund %d exceeds 1/%d of the %d gas spent", tr.refund, quotient, tr.spent)
+	}
+	// Ether is conserved: whatever the accounts lost went to the base fee
+	// burn, or was burned by self-destructs.
+	before, after := new(big.Int), new(big.Int)
+	for _, acc := range pre {
+		if acc.Balance != nil {
+			before.Add(before, acc.Balance)
+		}
+	}
+	for addr := range tr.touched {
+		after.Add(after, post.GetBalance(addr).ToBig())
+	}
+	lost := new(big.Int).Sub(before, after)
+	if baseFee := tr.vmctx.BaseFee; baseFee != nil {
+		lost.Sub(lost, new(big.Int).Mul(baseFee, new(big.Int).SetUint64(gasUsed)))
+	}
+	if lost.Sign() < 0 {
+		tr.violate("%v wei was created", new(big.Int).Neg(lost))
+	} else if lost.Cmp(tr.burnBound) > 0 {
+		tr.violate("%v wei disappeared, but self-destructs burned at most %v", lost, tr.burnBound)
+	}
+	// Nonces only increase, and the sender's by exactly one.
+	if sender := r.sender; post.GetNonce(sender) != pre[sender].Nonce+1 {
+		tr.violate("sender nonce went from %d to %d", pre[sender].Nonce, post.GetNonce(sender))
+	}
+	for addr, acc := range pre {
+		if _, ok := tr.outcome.destructed[addr]; !ok && post.GetNonce(addr) < acc.Nonce {
+			tr.violate("nonce of %v dropped from %d to %d", addr, acc.Nonce, post.GetNonce(addr))
+		}
+		// EIP-6780: a contract that existed before the tx survives it.
+		if r.config.IsCancun(new(big.Int), 0) && len(acc.Code) > 0 && !isDelegation(acc.Code) {
+			if code := post.GetCode(addr); !bytes.Equal(code, acc.Code) {
+				tr.violate("pre-existing contract %v changed its code to %x", addr, code)
+			}
+		}
+	}
+	// EIP-6780: a contract created and self-destructed in the tx is deleted.
+	for addr := range tr.outcome.destructed {
+		if tr.outcome.created[addr] && len(post.GetCode(addr)) > 0 {
+			tr.violate("contract %v created and self-destructed in the tx still has code", addr)
+		}
+	}
+	// The committed state reloads to what was in memory.
+	for addr, slots := range tr.touched {
+		mem := r.mem
+		if a, b := mem.GetBalance(addr), post.GetBalance(addr); !a.Eq(b) {
+			tr.violate("balance of %v is %v in memory but %v after reload", addr, a, b)
+		}
+		if a, b := mem.GetNonce(addr), post.GetNonce(addr); a != b {
+			tr.violate("nonce of %v is %d in memory but %d after reload", addr, a, b)
+		}
+		if a, b := mem.GetCodeHash(addr), post.GetCodeHash(addr); a != b {
+			tr.violate("code hash of %v is %x in memory but %x after reload", addr, a, b)
+		}
+		for slot := range slots {
+			if a, b := mem.GetState(addr, slot), post.GetState(addr, slot); a != b {
+				tr.violate("slot %x of %v is %x in memory but %x after reload", slot, addr, a, b)
+			}
+		}
+	}
+	return tr.violations
+}
+
+// isDelegation reports whether code is an EIP-7702 delegation designator,
+// which authorizations in the tx may legitimately replace.
+func isDelegation(code []byte) bool {
+	_, ok := types.ParseDelegation(code)
+	return ok
+}
+
+// storeFinding saves a test that violated an invariant, or that geth could not
+// fill, like storeTest, with the violations as its report.
+func storeFinding(test any, hashed []byte, testName string, violations []string, meta *Provenance) (bool, error) {
+	return store(test, hashed, testName, strings.Join(violations, "\n")+"\n", meta)
+}
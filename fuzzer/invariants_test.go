@@ -0,0 +1,182 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+	"github.com/holiman/uint256"
+)
+
+func TestInvariantsHold(t *testing.T) {
+	// A contract created and self-destructed in the same tx exercises the
+	// EIP-6780 and burn accounting.
+	selfdestruct := program.New().Op(vm.ADDRESS).Op(vm.SELFDESTRUCT).Bytes()
+	codes := [][]byte{
+		program.New().Create2(selfdestruct, 1).Op(vm.POP).Sstore(1, 2).Bytes(),
+		program.New().Selfdestruct(common.Address{0x42}).Bytes(),
+	}
+	for _, code := range codes {
+		gst := generator.CreateGstMaker(filler.NewFiller([]byte("invariantsinvariantsinvariants")), code)
+		violations, err := CheckInvariants(gst.ToGeneralStateTest("name"), "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(violations) > 0 {
+			t.Errorf("code %x violates invariants:\n%v", code, strings.Join(violations, "\n"))
+		}
+	}
+	for _, seed := range []string{"asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf", strings.Repeat("\xf0\xff", 64)} {
+		testMaker, _ := generator.GenerateProgram(filler.NewFiller([]byte(seed)))
+		violations, err := CheckInvariants(testMaker.ToGeneralStateTest("name"), "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(violations) > 0 {
+			t.Errorf("seed %x violates invariants:\n%v", seed, strings.Join(violations, "\n"))
+		}
+	}
+}
+
+// balanceState is a tracing.StateDB that only knows balances.
+type balanceState struct {
+	tracing.StateDB
+	balances map[common.Address]*uint256.Int
+}
+
+func (s *balanceState) GetBalance(addr common.Address) *uint256.Int {
+	if b, ok := s.balances[addr]; ok {
+		return b
+	}
+	return new(uint256.Int)
+}
+
+// TestInvariantFrames checks that creations and self-destructs only count if
+// no enclosing frame reverted, and what they may burn.
+func TestInvariantFrames(t *testing.T) {
+	var (
+		origin  = common.Address{1}
+		kept    = common.Address{2}
+		dropped = common.Address{3}
+	)
+	statedb := &balanceState{balances: map[common.Address]*uint256.Int{
+		kept:    uint256.NewInt(100),
+		dropped: uint256.NewInt(1000),
+	}}
+	tr := newInvariantTracer(100000)
+	hooks := tr.hooks()
+	hooks.OnTxStart(&tracing.VMContext{StateDB: statedb}, nil, origin)
+	hooks.OnEnter(0, byte(vm.CALL), origin, origin, nil, 0, nil)
+	// A creation that self-destructs to itself, kept.
+	hooks.OnEnter(1, byte(vm.CREATE), origin, kept, nil, 0, nil)
+	hooks.OnEnter(2, byte(vm.SELFDESTRUCT), kept, kept, nil, 0, big.NewInt(7))
+	hooks.OnExit(2, nil, 0, nil, false)
+	hooks.OnExit(1, nil, 0, nil, false)
+	// The same inside a reverted call, dropped.
+	hooks.OnEnter(1, byte(vm.CALL), origin, origin, nil, 0, nil)
+	hooks.OnEnter(2, byte(vm.CREATE2), origin, dropped, nil, 0, nil)
+	hooks.OnEnter(3, byte(vm.SELFDESTRUCT), dropped, dropped, nil, 0, big.NewInt(9))
+	hooks.OnExit(3, nil, 0, nil, false)
+	hooks.OnExit(2, nil, 0, nil, false)
+	hooks.OnExit(1, nil, 0, nil, true)
+	hooks.OnExit(0, nil, 0, nil, false)
+
+	if tr.outcome == nil {
+		t.Fatal("no outcome after the top-level exit")
+	}
+	if !tr.outcome.created[kept] || tr.outcome.created[dropped] {
+		t.Errorf("creations: %v", tr.outcome.created)
+	}
+	if _, ok := tr.outcome.destructed[dropped]; ok {
+		t.Error("reverted self-destruct counted")
+	}
+	// The value sent to itself, plus what the new contract still holds.
+	if tr.burnBound.Cmp(big.NewInt(107)) != 0 {
+		t.Errorf("burn bound %v, want 107", tr.burnBound)
+	}
+}
+
+func TestStoreFinding(t *testing.T) {
+	testMaker, _ := generator.GenerateProgram(filler.NewFiller([]byte("findingfindingfindingfindingfinding")))
+	test := testMaker.ToGeneralStateTest("finding")
+	hashed := hash(test)
+	defer func(dir string) { outputDir = dir }(outputDir)
+	outputDir = t.TempDir()
+	ensureDirs(filepath.Join(outputDir, common.Bytes2Hex(hashed[:1])))
+	if dup, err := storeFinding(test, hashed, "finding", []string{"broken"}, nil); err != nil || dup {
+		t.Fatalf("storeFinding = (%v, %v)", dup, err)
+	}
+	report, err := os.ReadFile(filepath.Join(outputDir, common.Bytes2Hex(hashed[:1]), "finding.txt"))
+	if err != nil || string(report) != "broken\n" {
+		t.Fatalf("report %q, %v", report, err)
+	}
+	if dup, _ := storeFinding(test, hashed, "finding", []string{"broken"}, nil); !dup {
+		t.Fatal("second store not reported as duplicate")
+	}
+}
+
+// TestInvariantViolations doctors the outcome of a clean execution the way a
+// broken EVM would, and checks that each invariant flags it.
+func TestInvariantViolations(t *testing.T) {
+	code := program.New().Sstore(1, 2).Sstore(1, 0).Bytes()
+	for _, tt := range []struct {
+		name   string
+		doctor func(r *invariantRun)
+		want   string
+	}{
+		{"refund above the EIP-3529 cap", func(r *invariantRun) {
+			r.tr.refund = r.tr.spent/5 + 1
+		}, "refund"},
+		{"gas used above the limit", func(r *invariantRun) {
+			r.gasUsed = r.tr.limit + 1
+		}, "exceeds the gas limit"},
+		{"pre-existing contract deleted", func(r *invariantRun) {
+			for addr, acc := range r.pre {
+				if len(acc.Code) > 0 {
+					r.post.SelfDestruct(addr)
+				}
+			}
+			r.post.Finalise(true)
+		}, "pre-existing contract"},
+	} {
+		gst := generator.CreateGstMaker(filler.NewFiller([]byte("invariantsinvariantsinvariants")), code)
+		run, err := runInvariants(gst.ToGeneralStateTest("name"), "name")
+		if err != nil || run == nil || run.post == nil {
+			t.Fatalf("%v: run = (%v, %v)", tt.name, run, err)
+		}
+		if violations := run.check(); len(violations) > 0 {
+			t.Fatalf("%v: clean run violates invariants:\n%v", tt.name, strings.Join(violations, "\n"))
+		}
+		run.tr.violations = nil
+		tt.doctor(run)
+		violations := run.check()
+		run.close()
+		if !strings.Contains(strings.Join(violations, "\n"), tt.want) {
+			t.Errorf("%v: violations %q, want one about %q", tt.name, violations, tt.want)
+		}
+	}
+}
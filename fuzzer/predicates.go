@@ -0,0 +1,316 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// A Predicate says what minimizing a test has to preserve. Given the original
+// test, it returns the check every smaller candidate (a test of the same name)
+// must pass, or an error if the original has nothing to preserve.
+type Predicate func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error)
+
+// sameObservation builds a Predicate from an observation of a test run:
+// candidates must observe exactly what the original did.
+func sameObservation[T comparable](observe func(*fuzzing.GeneralStateTest, string) (T, error)) Predicate {
+	return func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error) {
+		want, err := observe(test, name)
+		if err != nil {
+			return nil, err
+		}
+		return func(candidate *fuzzing.GeneralStateTest) bool {
+			got, err := observe(candidate, name)
+			return err == nil && got == want
+		}, nil
+	}
+}
+
+// runTraced executes the named test on the hash scheme with hooks attached.
+// It returns the post-state, which the caller must close, and the error of an
+// invalid transaction. The execution is watched, and closes its state itself if
+// a watchdog abort unwinds out of it, see budgetExceeded.
+func runTraced(test *fuzzing.GeneralStateTest, name string, hooks *tracing.Hooks) (*state.StateDB, func(), error) {
+	st, err := toStateTest(test, name)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	// RunNoVerify hands out the state it opens only when it returns, so it is
+	// caught as the transaction starts.
+	h := new(tracing.Hooks)
+	if w := watched(hooks); w != nil {
+		*h = *w
+	}
+	var (
+		opened    state.Database
+		onTxStart = h.OnTxStart
+		returned  bool
+	)
+	h.OnTxStart = func(env *tracing.VMContext, tx *types.Transaction, from common.Address) {
+		if sdb, ok := env.StateDB.(*state.StateDB); ok {
+			opened = sdb.Database()
+		}
+		if onTxStart != nil {
+			onTxStart(env, tx, from)
+		}
+	}
+	defer func() {
+		if !returned && opened != nil {
+			opened.TrieDB().Close()
+		}
+	}()
+	result, root, _, err := st.RunNoVerify(st.Subtests()[0], vm.Config{Tracer: h}, false, rawdb.HashScheme)
+	returned = true
+	if err != nil || result.StateDB == nil {
+		result.Close()
+		return nil, func() {}, err
+	}
+	post, err := state.New(root, result.StateDB.Database())
+	return post, result.Close, err
+}
+
+// SameTrace keeps candidates that execute the same instructions at the same
+// positions on the same operands as the original. Code can only be removed
+// behind the last instruction executed, or where nothing jumps past it.
+var SameTrace = sameObservation(func(test *fuzzing.GeneralStateTest, name string) (uint64, error) {
+	return traceHash(test, name, newHashTracer())
+})
+
+// SameRelocatedTrace keeps candidates that execute the same instructions on
+// the same operands as the original, wherever those instructions sit in the
+// code. It is what minimizing new tests for the corpus preserves.
+var SameRelocatedTrace = sameObservation(func(test *fuzzing.GeneralStateTest, name string) (uint64, error) {
+	return traceHash(test, name, newRelocatableHashTracer())
+})
+
+// traceHash executes the named test under tr and returns its hash.
+func traceHash(test *fuzzing.GeneralStateTest, name string, tr *hashTracer) (uint64, error) {
+	_, done, err := runTraced(test, name, tr.hooks())
+	done()
+	if err != nil {
+		tr.halt = "invalid transaction: " + err.Error()
+	}
+	if tr.overflow {
+		return 0, ErrTraceTooLarge
+	}
+	return tr.sum, nil
+}
+
+// SamePostState keeps candidates that leave the same post-state behind: the
+// same balances, nonces, storage and deployed code. What minimization changes
+// by design doesn't count: the code of the pre-state accounts unless execution
+// changed it, and the balances of the sender and coinbase, which pay and
+// receive fees for whatever gas the code uses.
+var SamePostState = sameObservation(func(test *fuzzing.GeneralStateTest, name string) (common.Hash, error) {
+	post, done, err := runTraced(test, name, nil)
+	defer done()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var (
+		sub  = (*test)[name]
+		fees = []common.Address{sub.Tx.Sender, sub.Env.Coinbase}
+		dump = post.RawDump(&state.DumpConfig{})
+	)
+	for key, acc := range dump.Accounts {
+		addr := common.HexToAddress(key)
+		acc.Root = nil
+		if pre, ok := sub.Pre[addr]; ok && bytes.Equal(pre.Code, acc.Code) {
+			acc.Code, acc.CodeHash = nil, nil
+		}
+		if slices.Contains(fees, addr) {
+			acc.Balance = ""
+		}
+		dump.Accounts[key] = acc
+	}
+	data, err := json.Marshal(dump.Accounts)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+})
+
+// SameHalt keeps candidates whose outermost call frame ends with the same error
+// as the original's (or without one, if the original's didn't), and candidates
+// whose transaction is rejected for the same reason.
+var SameHalt = sameObservation(func(test *fuzzing.GeneralStateTest, name string) (string, error) {
+	halt := ""
+	_, done, err := runTraced(test, name, &tracing.Hooks{
+		OnExit: func(depth int, _ []byte, _ uint64, err error, _ bool) {
+			if depth == 0 && err != nil {
+				halt = err.Error()
+			}
+		},
+	})
+	done()
+	if err != nil {
+		return "invalid transaction: " + err.Error(), nil
+	}
+	return halt, nil
+})
+
+// SameDiscrepancy keeps candidates on which the same configurations of the
+// differential oracle disagree about the same parts of the outcome. The
+// original must expose a discrepancy.
+var SameDiscrepancy Predicate = func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error) {
+	want, err := discrepancy(test, name)
+	if err != nil {
+		return nil, err
+	}
+	if want == "" {
+		return nil, errors.New("no discrepancy to preserve")
+	}
+	return func(candidate *fuzzing.GeneralStateTest) bool {
+		got, err := discrepancy(candidate, name)
+		return err == nil && got == want
+	}, nil
+}
+
+// ViolatesInvariants keeps candidates that violate any of the invariants
+// CheckInvariants checks. The original must violate one.
+var ViolatesInvariants Predicate = func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error) {
+	violates := func(test *fuzzing.GeneralStateTest) bool {
+		violations, err := CheckInvariants(test, name)
+		return err == nil && len(violations) > 0
+	}
+	if !violates(test) {
+		return nil, errors.New("no invariant violation to preserve")
+	}
+	return violates, nil
+}
+
+// CommandSucceeds keeps candidates for which an external command exits with
+// status 0. The command is args[0], run with args[1:] and the path of a file
+// holding the candidate test. The original must make it succeed.
+func CommandSucceeds(args ...string) Predicate {
+	return func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error) {
+		succeeds := func(test *fuzzing.GeneralStateTest) (bool, error) {
+			f, err := os.CreateTemp("", "fuzzyvm-candidate-*.json")
+			if err != nil {
+				return false, err
+			}
+			defer os.Remove(f.Name())
+			err = json.NewEncoder(f).Encode(test)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return false, err
+			}
+			cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+			if err := cmd.Run(); err != nil {
+				var exit *exec.ExitError
+				if errors.As(err, &exit) {
+					return false, nil
+				}
+				return false, err
+			}
+			return true, nil
+		}
+		if len(args) == 0 {
+			return nil, errors.New("no command given")
+		}
+		if ok, err := succeeds(test); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("%v fails on the original test", args[0])
+		}
+		return func(candidate *fuzzing.GeneralStateTest) bool {
+			ok, err := succeeds(candidate)
+			return err == nil && ok
+		}, nil
+	}
+}
+
+// discrepancy describes which configurations of the differential oracle
+// disagree about which parts of the outcome, without the values, or returns ""
+// if they all agree.
+func discrepancy(test *fuzzing.GeneralStateTest, name string) (string, error) {
+	st, err := toStateTest(test, name)
+	if err != nil {
+		return "", err
+	}
+	want := execute(st, execMatrix[0])
+	var sig strings.Builder
+	for _, cfg := range execMatrix[1:] {
+		res := execute(st, cfg)
+		for _, field := range []struct {
+			name  string
+			equal bool
+		}{
+			{"root", res.Root == want.Root},
+			{"logs", res.Logs == want.Logs},
+			{"gas", res.GasUsed == want.GasUsed},
+			{"error", res.Err == want.Err},
+		} {
+			if !field.equal {
+				fmt.Fprintf(&sig, "%v:%v ", cfg, field.name)
+			}
+		}
+	}
+	return sig.String(), nil
+}
+
+// CoversLine keeps candidates that still execute a line of go-ethereum, given
+// as the file and line go tool cover reports it, e.g.
+// github.com/ethereum/go-ethereum/core/vm/instructions.go:123. It reads the
+// coverage counters of the running binary, so it needs a binary built with
+// go build -cover -covermode=atomic whose -coverpkg lists both the main
+// package and the package of the line (go test binaries do not work), and it
+// must not run concurrently with anything else that executes the instrumented
+// code.
+func CoversLine(location string) Predicate {
+	return func(test *fuzzing.GeneralStateTest, name string) (func(*fuzzing.GeneralStateTest) bool, error) {
+		covers := func(test *fuzzing.GeneralStateTest) (bool, error) {
+			if err := ClearCoverage(); err != nil {
+				return false, err
+			}
+			_, done, _ := runTraced(test, name, nil)
+			done()
+			blocks, err := ReadCoverage()
+			if err != nil {
+				return false, err
+			}
+			return blocks.Covers(location)
+		}
+		if ok, err := covers(test); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("%v is not covered", location)
+		}
+		return func(candidate *fuzzing.GeneralStateTest) bool {
+			ok, err := covers(candidate)
+			return err == nil && ok
+		}, nil
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+// busyWork is a block of instructions that execute without leaving a trace
+// in the post-state.
+func busyWork() []byte {
+	p := program.New()
+	for range 100 {
+		p.Push(0x1234).Op(vm.POP)
+	}
+	return p.Bytes()
+}
+
+func TestPredicates(t *testing.T) {
+	var (
+		seed     = []byte("predicatespredicatespredicates")
+		sstore   = program.New().Sstore(1, 2).Bytes()
+		busy     = append(busyWork(), sstore...)
+		other    = program.New().Sstore(1, 3).Bytes()
+		invalid  = append(busyWork(), byte(vm.INVALID))
+		original = generator.CreateGstMaker(filler.NewFiller(seed), busy).ToGeneralStateTest("name")
+	)
+	for _, tt := range []struct {
+		pred       Predicate
+		name       string
+		keep, drop []byte
+	}{
+		{SameTrace, "trace", busy, sstore},
+		{SameRelocatedTrace, "relocated trace", busy, sstore},
+		{SamePostState, "post-state", sstore, other},
+		{SameHalt, "halt", sstore, invalid},
+	} {
+		holds, err := tt.pred(original, "name")
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if !holds(generator.CreateGstMaker(filler.NewFiller(seed), tt.keep).ToGeneralStateTest("name")) {
+			t.Errorf("%v: %x rejected", tt.name, tt.keep)
+		}
+		if holds(generator.CreateGstMaker(filler.NewFiller(seed), tt.drop).ToGeneralStateTest("name")) {
+			t.Errorf("%v: %x kept", tt.name, tt.drop)
+		}
+	}
+	// Predicates about findings need a finding to preserve.
+	for _, pred := range []Predicate{SameDiscrepancy, ViolatesInvariants, CoversLine("core/vm/nowhere.go:1")} {
+		if _, err := pred(original, "name"); err == nil {
+			t.Errorf("predicate holds without a finding")
+		}
+	}
+}
+
+// deadJump is a program that jumps over dead code to an SSTORE.
+func deadJump() []byte {
+	dead := busyWork()
+	dest := 4 + len(dead)
+	p := program.New()
+	p.Append([]byte{byte(vm.PUSH2), byte(dest >> 8), byte(dest)}).Op(vm.JUMP).Append(dead)
+	return p.Op(vm.JUMPDEST).Sstore(1, 2).Bytes()
+}
+
+// TestMinimizePredicates checks that looser predicates let minimization
+// remove code that executes.
+func TestMinimizePredicates(t *testing.T) {
+	seed := []byte("predicatespredicatespredicates")
+	sstore := program.New().Sstore(1, 2).Bytes()
+	for _, tt := range []struct {
+		pred       Predicate
+		name       string
+		code, want []byte
+	}{
+		{SameTrace, "trace", append(busyWork(), sstore...), append(busyWork(), sstore...)},
+		// Only the relocated trace lets the dead code the jump skips go.
+		{SameTrace, "trace", deadJump(), deadJump()},
+		{SameRelocatedTrace, "relocated trace", deadJump(), program.New().Append([]byte{byte(vm.PUSH2), 0, 4}).Op(vm.JUMP, vm.JUMPDEST).Sstore(1, 2).Bytes()},
+		{SamePostState, "post-state", append(busyWork(), sstore...), sstore},
+		{SameHalt, "halt", append(busyWork(), byte(vm.INVALID)), []byte{byte(vm.INVALID)}},
+	} {
+		_, minimized, err := Minimize(generator.CreateGstMaker(filler.NewFiller(seed), tt.code), tt.pred)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if !bytes.Equal(minimized, tt.want) {
+			t.Errorf("%v: minimized to %x, want %x", tt.name, minimized, tt.want)
+		}
+	}
+}
+
+func TestParseCoverage(t *testing.T) {
+	profile, err := ParseCoverage(strings.NewReader(`mode: atomic
+github.com/ethereum/go-ethereum/core/vm/instructions.go:10.2,12.16 2 0
+github.com/ethereum/go-ethereum/core/vm/instructions.go:12.16,14.3 1 5
+github.com/ethereum/go-ethereum/core/vm/interpreter.go:30.1,31.2 1 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile) != 3 || profile[1] != (CoverageBlock{"github.com/ethereum/go-ethereum/core/vm/instructions.go", 12, 16, 14, 3, 1, 5}) {
+		t.Fatalf("parsed %+v", profile)
+	}
+	for location, want := range map[string]bool{
+		"core/vm/instructions.go:11": false,
+		"core/vm/instructions.go:13": true,
+		"instructions.go:12":         true,
+		"core/vm/interpreter.go:30":  false,
+	} {
+		if got, err := profile.Covers(location); err != nil || got != want {
+			t.Errorf("%v covered: %v, %v; want %v", location, got, err, want)
+		}
+	}
+	if _, err := profile.Covers("core/vm/instructions.go:99"); err == nil {
+		t.Errorf("covered a line without a block")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/goevmlab/fuzzing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+// Provenance records how a test was made, so it can be reproduced and triaged
+// long after the campaign that generated it. It is stored next to the test.
+type Provenance struct {
+	// Seed is the filler input the generator made the test from.
+	Seed hexutil.Bytes `json:"seed"`
+	// Generator is the generator.Version that turns Seed into the test.
+	Generator int `json:"generator"`
+	// Revision is the VCS revision the binary was built from, if it was
+	// stamped with one.
+	Revision string `json:"revision,omitempty"`
+	Fork     string `json:"fork"`
+	// Strategies are the strategies the generator selected, in order.
+	Strategies []generator.Invocation `json:"strategies"`
+	// CodeSize is the length of the generated program, MinimizedSize that of
+	// the longest code in the stored test.
+	CodeSize      int    `json:"codeSize"`
+	MinimizedSize int    `json:"minimizedSize"`
+	GasUsed       uint64 `json:"gasUsed"`
+	// Halt is the error the outermost call frame ended with, or why the
+	// transaction is invalid. It is empty if the call succeeded.
+	Halt string `json:"halt,omitempty"`
+	// Opcodes counts the executed instructions by opcode.
+	Opcodes map[string]uint64 `json:"opcodes"`
+}
+
+// NewProvenance describes the test name, which the generator made from seed by
+// selecting invocations, and whose program was code before minimization. It
+// runs the test to observe its execution.
+func NewProvenance(seed []byte, invocations []generator.Invocation, code []byte, test *fuzzing.GeneralStateTest, name string) *Provenance {
+	p := &Provenance{
+		Seed:       seed,
+		Generator:  generator.Version,
+		Revision:   revision(),
+		Fork:       generator.Fork(),
+		Strategies: invocations,
+		CodeSize:   len(code),
+		Opcodes:    make(map[string]uint64),
+	}
+	for _, acc := range (*test)[name].Pre {
+		p.MinimizedSize = max(p.MinimizedSize, len(acc.Code))
+	}
+	_, done, err := runTraced(test, name, &tracing.Hooks{
+		OnOpcode: func(_ uint64, op byte, _, _ uint64, _ tracing.OpContext, _ []byte, _ int, _ error) {
+			p.Opcodes[vm.OpCode(op).String()]++
+		},
+		OnExit: func(depth int, _ []byte, _ uint64, err error, _ bool) {
+			if depth == 0 && err != nil {
+				p.Halt = err.Error()
+			}
+		},
+		OnTxEnd: func(receipt *types.Receipt, _ error) {
+			if receipt != nil {
+				p.GasUsed = receipt.GasUsed
+			}
+		},
+	})
+	done()
+	if err != nil {
+		p.Halt = "invalid transaction: " + err.Error()
+	}
+	return p
+}
+
+// revision returns the VCS revision the binary was built from, marked if the
+// tree had local changes. go test binaries are not stamped.
+var revision = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var rev, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if rev != "" && modified == "true" {
+		rev += "-dirty"
+	}
+	return rev
+})
@@ -0,0 +1,69 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+func TestProvenance(t *testing.T) {
+	seed := []byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf")
+	gst, code, invocations := generator.RecordProgram(filler.NewFiller(seed))
+	test := gst.ToGeneralStateTest("name")
+	data, err := json.Marshal(NewProvenance(seed, invocations, code, test, "name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stored provenance is enough to generate the same program again.
+	var p Provenance
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Generator != generator.Version || p.Fork != generator.Fork() || len(p.Strategies) != len(invocations) {
+		t.Errorf("unexpected provenance %s", data)
+	}
+	if _, again, _ := generator.RecordProgram(filler.NewFiller(p.Seed)); !bytes.Equal(again, code) {
+		t.Errorf("seed regenerates %x, want %x", again, code)
+	}
+	if p.CodeSize != len(code) || p.MinimizedSize != len(code) || p.GasUsed == 0 {
+		t.Errorf("unexpected sizes or gas in %s", data)
+	}
+
+	// A program that halts on an invalid instruction after some busy work.
+	halting := append(busyWork(), byte(vm.INVALID))
+	test = generator.CreateGstMaker(filler.NewFiller(seed), halting).ToGeneralStateTest("name")
+	p = *NewProvenance(seed, nil, halting, test, "name")
+	if p.Halt != "invalid opcode: INVALID" {
+		t.Errorf("halt %q", p.Halt)
+	}
+	if p.Opcodes["PUSH2"] != 100 || p.Opcodes["POP"] != 100 || p.Opcodes["INVALID"] != 1 || len(p.Opcodes) != 3 {
+		t.Errorf("opcode histogram %v", p.Opcodes)
+	}
+	// An invalid transaction executes nothing.
+	(*test)["name"].Tx.GasLimit[0] = 0
+	p = *NewProvenance(seed, nil, halting, test, "name")
+	if p.GasUsed != 0 || len(p.Opcodes) != 0 || p.Halt == "" {
+		t.Errorf("invalid transaction: gas %v, opcodes %v, halt %q", p.GasUsed, p.Opcodes, p.Halt)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// SelectSubtest narrows the named test to one of its subtests: the post state
+// at index among the post states of fork, or of the first fork if fork is "".
+// The transaction keeps only the data, gas limit and value that post state
+// refers to.
+func SelectSubtest(test *fuzzing.GeneralStateTest, name, fork string, index int) error {
+	sub, ok := (*test)[name]
+	if !ok {
+		return fmt.Errorf("no test named %q", name)
+	}
+	if fork == "" {
+		forks := slices.Sorted(maps.Keys(sub.Post))
+		if len(forks) == 0 {
+			return fmt.Errorf("%v has no post states", name)
+		}
+		fork = forks[0]
+	}
+	posts := sub.Post[fork]
+	if index < 0 || index >= len(posts) {
+		return fmt.Errorf("%v has no post state %d for %v", name, index, fork)
+	}
+	post := posts[index]
+	data, gas, value := post.Indexes.Data, post.Indexes.Gas, post.Indexes.Value
+	if data >= len(sub.Tx.Data) || gas >= len(sub.Tx.GasLimit) || value >= len(sub.Tx.Value) {
+		return fmt.Errorf("post state %d of %v refers to a missing transaction field", index, fork)
+	}
+	sub.Tx.Data = sub.Tx.Data[data : data+1]
+	sub.Tx.GasLimit = sub.Tx.GasLimit[gas : gas+1]
+	sub.Tx.Value = sub.Tx.Value[value : value+1]
+	if data < len(sub.Tx.AccessLists) {
+		sub.Tx.AccessLists = sub.Tx.AccessLists[data : data+1]
+	} else {
+		sub.Tx.AccessLists = nil
+	}
+	post.Indexes.Data, post.Indexes.Gas, post.Indexes.Value = 0, 0, 0
+	for f := range sub.Post {
+		if f != fork {
+			delete(sub.Post, f)
+		}
+	}
+	sub.Post[fork] = append(posts[:0:0], post)
+	return nil
+}
+
+// Reduce shrinks the named test, which may come from anywhere, while pred
+// holds. Round after round it drops pre-state accounts, storage slots,
+// access-list entries, calldata bytes and the value, lowers the gas limit and
+// minimizes the code of every account, until a round changes nothing or it has
+// executed the test probes times. The test must be narrowed to a single
+// subtest first, see SelectSubtest. Its expected post states are left as they
+// were, see FillTest.
+func Reduce(test *fuzzing.GeneralStateTest, name string, pred Predicate, probes int) error {
+	sub, ok := (*test)[name]
+	if !ok {
+		return fmt.Errorf("no test named %q", name)
+	}
+	if len(sub.Tx.Data) != 1 || len(sub.Tx.GasLimit) != 1 || len(sub.Tx.Value) != 1 || len(sub.Post) != 1 {
+		return errors.New("test has more than one subtest")
+	}
+	holds, err := pred(test, name)
+	if err != nil {
+		return err
+	}
+	r := &reduction{test: test, name: name, holds: holds, probes: probes}
+	for r.probes > 0 {
+		before, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		r.accounts()
+		r.storage()
+		r.accessList()
+		r.calldata()
+		r.value()
+		r.gas()
+		r.code()
+		after, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(before, after) {
+			break
+		}
+	}
+	return nil
+}
+
+// drop removes items while keep holds: all of them if it can, otherwise as
+// many as ddmin finds.
+func drop[T any](items []T, keep func([]T) bool, probes *int) []T {
+	if len(items) == 0 || *probes <= 0 {
+		return items
+	}
+	*probes--
+	if keep(items[:0]) {
+		return items[:0]
+	}
+	return ddmin(items, keep, probes)
+}
+
+// reduction is the state of one Reduce: each step changes one part of the
+// test in place, and puts it back for every candidate the predicate rejects.
+type reduction struct {
+	test   *fuzzing.GeneralStateTest
+	name   string
+	holds  func(*fuzzing.GeneralStateTest) bool
+	probes int
+}
+
+func (r *reduction) keep() bool {
+	return r.holds(r.test)
+}
+
+// try applies change and keeps it if the predicate still holds.
+func (r *reduction) try(change, undo func()) {
+	if r.probes <= 0 {
+		return
+	}
+	r.probes--
+	change()
+	if !r.keep() {
+		undo()
+	}
+}
+
+// accounts drops pre-state accounts.
+func (r *reduction) accounts() {
+	sub := (*r.test)[r.name]
+	all := sub.Pre
+	kept := drop(slices.SortedFunc(maps.Keys(all), common.Address.Cmp), func(addrs []common.Address) bool {
+		pre := make(fuzzing.GenesisAlloc, len(addrs))
+		for _, addr := range addrs {
+			pre[addr] = all[addr]
+		}
+		sub.Pre = pre
+		defer func() { sub.Pre = all }()
+		return r.keep()
+	}, &r.probes)
+	pre := make(fuzzing.GenesisAlloc, len(kept))
+	for _, addr := range kept {
+		pre[addr] = all[addr]
+	}
+	sub.Pre = pre
+}
+
+// storage drops the storage slots of every account.
+func (r *reduction) storage() {
+	sub := (*r.test)[r.name]
+	for _, addr := range slices.SortedFunc(maps.Keys(sub.Pre), common.Address.Cmp) {
+		acc := sub.Pre[addr]
+		slots := func(keys []common.Hash) map[common.Hash]common.Hash {
+			storage := make(map[common.Hash]common.Hash, len(keys))
+			for _, key := range keys {
+				storage[key] = acc.Storage[key]
+			}
+			return storage
+		}
+		set := func(storage map[common.Hash]common.Hash) {
+			changed := acc
+			changed.Storage = storage
+			sub.Pre[addr] = changed
+		}
+		kept := drop(slices.SortedFunc(maps.Keys(acc.Storage), common.Hash.Cmp), func(keys []common.Hash) bool {
+			set(slots(keys))
+			defer set(acc.Storage)
+			return r.keep()
+		}, &r.probes)
+		set(slots(kept))
+	}
+}
+
+// accessList drops the entries of the access list, then the storage keys of
+// the entries that are left.
+func (r *reduction) accessList() {
+	sub := (*r.test)[r.name]
+	if len(sub.Tx.AccessLists) == 0 || sub.Tx.AccessLists[0] == nil {
+		return
+	}
+	list := sub.Tx.AccessLists[0]
+	set := func(tuples types.AccessList) {
+		sub.Tx.AccessLists = []*types.AccessList{&tuples}
+	}
+	tuples := drop(*list, func(tuples []types.AccessTuple) bool {
+		set(tuples)
+		defer set(*list)
+		return r.keep()
+	}, &r.probes)
+	tuples = slices.Clone(tuples)
+	for i, tuple := range tuples {
+		tuples[i].StorageKeys = drop(tuple.StorageKeys, func(keys []common.Hash) bool {
+			tuples[i].StorageKeys = keys
+			set(tuples)
+			defer func() { tuples[i].StorageKeys = tuple.StorageKeys }()
+			return r.keep()
+		}, &r.probes)
+	}
+	set(tuples)
+}
+
+// calldata drops calldata bytes. The data of a transaction that creates a
+// contract is init code, which is minimized like code.
+func (r *reduction) calldata() {
+	sub := (*r.test)[r.name]
+	data, err := hexutil.Decode(sub.Tx.Data[0])
+	if err != nil {
+		return
+	}
+	original := sub.Tx.Data[0]
+	keep := func(data []byte) bool {
+		sub.Tx.Data[0] = hexutil.Encode(data)
+		defer func() { sub.Tx.Data[0] = original }()
+		return r.keep()
+	}
+	if sub.Tx.To == "" {
+		data = minimizeCode(data, keep, &r.probes)
+	} else {
+		data = drop(data, keep, &r.probes)
+	}
+	sub.Tx.Data[0] = hexutil.Encode(data)
+}
+
+// value sends no value if the predicate allows it.
+func (r *reduction) value() {
+	sub := (*r.test)[r.name]
+	value := sub.Tx.Value[0]
+	if v, ok := math.ParseBig256(value); !ok || v.Sign() == 0 {
+		return
+	}
+	r.try(func() { sub.Tx.Value[0] = "0x00" }, func() { sub.Tx.Value[0] = value })
+}
+
+// gas searches for the lowest gas limit the predicate allows. The predicate
+// need not be monotonic in the gas limit, so the result of the binary search
+// is only kept if it holds.
+func (r *reduction) gas() {
+	sub := (*r.test)[r.name]
+	limit := sub.Tx.GasLimit[0]
+	lowest := sort.Search(int(min(limit, 1<<62)), func(gas int) bool {
+		if r.probes <= 0 {
+			return false
+		}
+		r.probes--
+		sub.Tx.GasLimit[0] = uint64(gas)
+		defer func() { sub.Tx.GasLimit[0] = limit }()
+		return r.keep()
+	})
+	if uint64(lowest) < limit {
+		r.try(func() { sub.Tx.GasLimit[0] = uint64(lowest) }, func() { sub.Tx.GasLimit[0] = limit })
+	}
+}
+
+// code minimizes the code of every account, see minimizeCode.
+func (r *reduction) code() {
+	sub := (*r.test)[r.name]
+	for _, addr := range slices.SortedFunc(maps.Keys(sub.Pre), common.Address.Cmp) {
+		acc := sub.Pre[addr]
+		if len(acc.Code) == 0 {
+			continue
+		}
+		set := func(code []byte) {
+			changed := acc
+			changed.Code = code
+			sub.Pre[addr] = changed
+		}
+		code := minimizeCode(acc.Code, func(code []byte) bool {
+			set(code)
+			defer set(acc.Code)
+			return r.keep()
+		}, &r.probes)
+		set(code)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+	"github.com/holiman/goevmlab/fuzzing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+func TestReduce(t *testing.T) {
+	var (
+		seed  = []byte("reducereducereducereducereducereduce")
+		code  = append(busyWork(), byte(vm.INVALID))
+		maker = generator.CreateGstMaker(filler.NewFiller(seed), code)
+		other = common.HexToAddress("0x1234")
+	)
+	maker.AddAccount(other, fuzzing.GenesisAccount{
+		Code:    program.New().Sstore(1, 2).Bytes(),
+		Balance: big.NewInt(1),
+		Storage: map[common.Hash]common.Hash{{1}: {2}},
+	})
+	test := maker.ToGeneralStateTest("name")
+	sub := (*test)["name"]
+	gas := sub.Tx.GasLimit[0]
+	// Give the transaction two subtests and an access list.
+	sub.Tx.Data = append(sub.Tx.Data, "0x01020304")
+	sub.Tx.AccessLists = []*types.AccessList{nil, {{Address: other, StorageKeys: []common.Hash{{1}, {2}}}}}
+	for fork, posts := range sub.Post {
+		second := posts[0]
+		second.Indexes.Data = 1
+		sub.Post[fork] = append(posts, second)
+	}
+	if err := Reduce(test, "name", SameHalt, 1000); err == nil {
+		t.Fatal("reduced a test with two subtests")
+	}
+	if err := SelectSubtest(test, "name", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reduce(test, "name", SameHalt, 1000); err != nil {
+		t.Fatal(err)
+	}
+	// Only the sender, paying for the gas, and the invalid instruction are
+	// needed to halt the same way.
+	dest := common.HexToAddress(sub.Tx.To)
+	if len(sub.Pre) != 2 || !bytes.Equal(sub.Pre[dest].Code, []byte{byte(vm.INVALID)}) {
+		t.Errorf("pre-state not reduced: %v", sub.Pre)
+	}
+	if sub.Tx.Data[0] != "0x" || sub.Tx.Value[0] != "0x00" || len(*sub.Tx.AccessLists[0]) != 0 {
+		t.Errorf("transaction not reduced: data %v, value %v, access list %v", sub.Tx.Data, sub.Tx.Value, sub.Tx.AccessLists)
+	}
+	// The gas limit is as low as the halt allows.
+	holds, err := SameHalt(test, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Tx.GasLimit[0]--
+	if holds(test) || sub.Tx.GasLimit[0] >= gas {
+		t.Errorf("gas limit %d not reduced", sub.Tx.GasLimit[0]+1)
+	}
+}
+
+func TestCommandSucceeds(t *testing.T) {
+	test := generator.CreateGstMaker(filler.NewFiller([]byte("command")), nil).ToGeneralStateTest("name")
+	holds, err := CommandSucceeds("grep", "-q", "name")(test, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !holds(test) {
+		t.Error("command failed")
+	}
+	if _, err := CommandSucceeds("grep", "-q", "missing")(test, "name"); err == nil {
+		t.Error("predicate holds although the command fails")
+	}
+}
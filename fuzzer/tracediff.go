@@ -0,0 +1,484 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/goevmlab/fuzzing"
+	"github.com/holiman/uint256"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+	"github.com/MariusVanDerWijden/FuzzyVM/mutator"
+)
+
+// TraceStep is one step of an EIP-3155 trace.
+type TraceStep struct {
+	Pc      uint64              `json:"pc"`
+	Op      vm.OpCode           `json:"op"`
+	Gas     math.HexOrDecimal64 `json:"gas"`
+	GasCost math.HexOrDecimal64 `json:"gasCost"`
+	MemSize math.HexOrDecimal64 `json:"memSize"`
+	Stack   []string            `json:"stack"`
+	Depth   int                 `json:"depth"`
+	Refund  math.HexOrDecimal64 `json:"refund"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// TraceDiff says where two traces diverge.
+type TraceDiff struct {
+	// Step is the index of the first step the traces disagree on.
+	Step int
+	// A and B are the steps the traces have there, nil for a trace that
+	// ended before it.
+	A, B *TraceStep
+	// Fields lists the fields of A and B that differ.
+	Fields []string
+	// Before holds the steps leading up to the divergence, which both traces
+	// share, and AfterA and AfterB the ones each trace continues with.
+	Before         []TraceStep
+	AfterA, AfterB []TraceStep
+	// Summary holds the values of the summary lines (state root, output, gas
+	// used...) the traces disagree on, if their steps all agree.
+	Summary map[string][2]string
+	// Address is the account whose code runs at step A, or B if A is nil. It
+	// is nil where the trace doesn't tell: for the code of the transaction
+	// itself, at depth 1, and for code being deployed.
+	Address *common.Address
+}
+
+// traceReader reads the steps of a trace one at a time, collecting the
+// summary lines in between.
+type traceReader struct {
+	r       *bufio.Reader
+	summary map[string]json.RawMessage
+}
+
+func newTraceReader(r io.Reader) *traceReader {
+	return &traceReader{r: bufio.NewReaderSize(r, 1<<20), summary: make(map[string]json.RawMessage)}
+}
+
+// next returns the next step, or nil at the end of the trace.
+func (t *traceReader) next() (*TraceStep, error) {
+	for {
+		line, err := t.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err == io.EOF {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, fmt.Errorf("invalid trace line %q: %w", line, err)
+		}
+		if _, ok := fields["pc"]; !ok {
+			// The time it took is the one thing traces may differ in.
+			delete(fields, "time")
+			maps.Copy(t.summary, fields)
+			continue
+		}
+		step := new(TraceStep)
+		if err := json.Unmarshal(line, step); err != nil {
+			return nil, fmt.Errorf("invalid trace step %q: %w", line, err)
+		}
+		return step, nil
+	}
+}
+
+// DiffTraces reads two EIP-3155 traces step by step and returns the first
+// step they differ in, with up to context steps around it. If the steps all
+// agree, it compares the summary lines the traces end with. It returns nil if
+// the traces agree.
+func DiffTraces(a, b io.Reader, context int) (*TraceDiff, error) {
+	var (
+		ra, rb = newTraceReader(a), newTraceReader(b)
+		before []TraceStep
+		frames = []*common.Address{nil}
+		callee *common.Address
+	)
+	for i := 0; ; i++ {
+		sa, err := ra.next()
+		if err != nil {
+			return nil, err
+		}
+		sb, err := rb.next()
+		if err != nil {
+			return nil, err
+		}
+		if sa == nil && sb == nil {
+			return diffSummaries(ra.summary, rb.summary, i), nil
+		}
+		step := sa
+		if step == nil {
+			step = sb
+		}
+		// Follow the calls into other accounts' code.
+		switch {
+		case step.Depth > len(frames):
+			frames = append(frames, callee)
+		case step.Depth >= 1 && step.Depth < len(frames):
+			frames = frames[:step.Depth]
+		}
+		callee = calledCode(step)
+		if fields := diffSteps(sa, sb); len(fields) > 0 {
+			d := &TraceDiff{Step: i, A: sa, B: sb, Fields: fields, Before: before}
+			if step.Depth >= 1 && step.Depth <= len(frames) {
+				d.Address = frames[step.Depth-1]
+			}
+			if d.AfterA, err = ra.take(context); err != nil {
+				return nil, err
+			}
+			if d.AfterB, err = rb.take(context); err != nil {
+				return nil, err
+			}
+			return d, nil
+		}
+		if before = append(before, *sa); len(before) > context {
+			before = before[1:]
+		}
+	}
+}
+
+// take reads up to n more steps.
+func (t *traceReader) take(n int) ([]TraceStep, error) {
+	var steps []TraceStep
+	for range n {
+		step, err := t.next()
+		if err != nil || step == nil {
+			return steps, err
+		}
+		steps = append(steps, *step)
+	}
+	return steps, nil
+}
+
+// calledCode returns the account whose code a call instruction runs, or nil
+// if step doesn't call into the code of an account.
+func calledCode(step *TraceStep) *common.Address {
+	switch step.Op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+	default:
+		return nil
+	}
+	// The stack is listed bottom to top; the address is below the gas.
+	if len(step.Stack) < 2 {
+		return nil
+	}
+	v, err := uint256.FromHex(step.Stack[len(step.Stack)-2])
+	if err != nil {
+		return nil
+	}
+	addr := common.Address(v.Bytes20())
+	return &addr
+}
+
+// diffSteps lists the fields of two steps that differ; a missing step differs
+// in every field.
+func diffSteps(a, b *TraceStep) []string {
+	if a == nil || b == nil {
+		return []string{"end of trace"}
+	}
+	var fields []string
+	add := func(name string, equal bool) {
+		if !equal {
+			fields = append(fields, name)
+		}
+	}
+	add("op", a.Op == b.Op)
+	add("pc", a.Pc == b.Pc)
+	add("gas", a.Gas == b.Gas)
+	add("gasCost", a.GasCost == b.GasCost)
+	add("stack", slices.EqualFunc(a.Stack, b.Stack, sameWord))
+	add("memSize", a.MemSize == b.MemSize)
+	add("refund", a.Refund == b.Refund)
+	add("depth", a.Depth == b.Depth)
+	add("error", a.Error == b.Error)
+	return fields
+}
+
+// sameWord compares stack items, which EVMs may print with leading zeros.
+func sameWord(a, b string) bool {
+	x, errA := uint256.FromHex(a)
+	y, errB := uint256.FromHex(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return x.Eq(y)
+}
+
+// diffSummaries compares the summary lines both traces have.
+func diffSummaries(a, b map[string]json.RawMessage, steps int) *TraceDiff {
+	d := &TraceDiff{Step: steps, Summary: make(map[string][2]string)}
+	for key, va := range a {
+		if vb, ok := b[key]; ok && !bytes.Equal(va, vb) {
+			d.Summary[key] = [2]string{string(va), string(vb)}
+			d.Fields = append(d.Fields, key)
+		}
+	}
+	if len(d.Fields) == 0 {
+		return nil
+	}
+	slices.Sort(d.Fields)
+	return d
+}
+
+// String reports the divergence: the fields that differ side by side, and the
+// steps around it.
+func (d *TraceDiff) String() string {
+	var b strings.Builder
+	if d.Summary != nil {
+		fmt.Fprintf(&b, "All %d steps agree, the summaries differ:\n", d.Step)
+		w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "\tfield\ta\tb\n")
+		for _, key := range d.Fields {
+			fmt.Fprintf(w, "\t%v\t%v\t%v\n", key, d.Summary[key][0], d.Summary[key][1])
+		}
+		w.Flush()
+		return b.String()
+	}
+	step := d.A
+	if step == nil {
+		step = d.B
+	}
+	fmt.Fprintf(&b, "First divergence at step %d, depth %d, pc %d (%v): %v\n", d.Step, step.Depth, step.Pc, step.Op, strings.Join(d.Fields, ", "))
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\tfield\ta\tb\n")
+	for _, row := range d.rows() {
+		fmt.Fprintf(w, "\t%v\t%v\t%v\n", row[0], row[1], row[2])
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "\nContext:\n")
+	w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for i, s := range d.Before {
+		printStep(w, "", d.Step-len(d.Before)+i, s)
+	}
+	for _, side := range []struct {
+		name  string
+		step  *TraceStep
+		after []TraceStep
+	}{{"a", d.A, d.AfterA}, {"b", d.B, d.AfterB}} {
+		if side.step == nil {
+			fmt.Fprintf(w, "%v\t%d\t(end of trace)\n", side.name, d.Step)
+			continue
+		}
+		printStep(w, side.name, d.Step, *side.step)
+		for i, s := range side.after {
+			printStep(w, side.name, d.Step+1+i, s)
+		}
+	}
+	w.Flush()
+	return b.String()
+}
+
+// rows returns the differing fields of the diverging steps, as field name and
+// the values of a and b. Stack items are numbered from the top.
+func (d *TraceDiff) rows() [][3]string {
+	if d.A == nil || d.B == nil {
+		show := func(s *TraceStep) string {
+			if s == nil {
+				return "(end of trace)"
+			}
+			return fmt.Sprintf("%v at pc %d", s.Op, s.Pc)
+		}
+		return [][3]string{{"step", show(d.A), show(d.B)}}
+	}
+	a, b := d.A, d.B
+	var rows [][3]string
+	for _, field := range d.Fields {
+		switch field {
+		case "op":
+			rows = append(rows, [3]string{field, a.Op.String(), b.Op.String()})
+		case "pc":
+			rows = append(rows, [3]string{field, fmt.Sprint(a.Pc), fmt.Sprint(b.Pc)})
+		case "gas":
+			rows = append(rows, [3]string{field, fmt.Sprint(uint64(a.Gas)), fmt.Sprint(uint64(b.Gas))})
+		case "gasCost":
+			rows = append(rows, [3]string{field, fmt.Sprint(uint64(a.GasCost)), fmt.Sprint(uint64(b.GasCost))})
+		case "memSize":
+			rows = append(rows, [3]string{field, fmt.Sprint(uint64(a.MemSize)), fmt.Sprint(uint64(b.MemSize))})
+		case "refund":
+			rows = append(rows, [3]string{field, fmt.Sprint(uint64(a.Refund)), fmt.Sprint(uint64(b.Refund))})
+		case "depth":
+			rows = append(rows, [3]string{field, fmt.Sprint(a.Depth), fmt.Sprint(b.Depth)})
+		case "error":
+			rows = append(rows, [3]string{field, a.Error, b.Error})
+		case "stack":
+			if len(a.Stack) != len(b.Stack) {
+				rows = append(rows, [3]string{"stack size", fmt.Sprint(len(a.Stack)), fmt.Sprint(len(b.Stack))})
+			}
+			for i := range max(len(a.Stack), len(b.Stack)) {
+				x, y := stackItem(a.Stack, i), stackItem(b.Stack, i)
+				if !sameWord(x, y) {
+					rows = append(rows, [3]string{fmt.Sprintf("stack[%d]", i), x, y})
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// stackItem returns the i-th item from the top of stack, or "-" if it is not
+// that high.
+func stackItem(stack []string, i int) string {
+	if i >= len(stack) {
+		return "-"
+	}
+	return stack[len(stack)-1-i]
+}
+
+func printStep(w io.Writer, side string, i int, s TraceStep) {
+	top := "-"
+	if len(s.Stack) > 0 {
+		top = s.Stack[len(s.Stack)-1]
+	}
+	fmt.Fprintf(w, "%v\t%d\tdepth %d\tpc %d\t%v\tgas %d\tcost %d\ttop %v\t%v\n", side, i, s.Depth, s.Pc, s.Op, uint64(s.Gas), uint64(s.GasCost), top, s.Error)
+}
+
+// DisassembleDiff disassembles the code that runs at the diverging step of
+// the named test, window instructions either side of it. Given the provenance
+// of the test, it annotates the instructions of the generated program with the
+// strategy that emitted them. Minimization removes instructions, so they are
+// matched to the instructions of the program the seed regenerates in order.
+func DisassembleDiff(test *fuzzing.GeneralStateTest, name string, d *TraceDiff, meta *Provenance, window int) (string, error) {
+	sub, ok := (*test)[name]
+	if !ok {
+		return "", fmt.Errorf("no test named %q", name)
+	}
+	step := d.A
+	if step == nil {
+		step = d.B
+	}
+	if step == nil {
+		return "", errors.New("the traces diverge after the last step")
+	}
+	var (
+		code   []byte
+		target bool
+	)
+	switch {
+	case step.Depth <= 1 && sub.Tx.To == "":
+		if len(sub.Tx.Data) == 0 {
+			return "", errors.New("the creation has no init code")
+		}
+		data, err := hexutil.Decode(sub.Tx.Data[0])
+		if err != nil {
+			return "", err
+		}
+		code = data
+	case step.Depth <= 1:
+		code = sub.Pre[common.HexToAddress(sub.Tx.To)].Code
+		target = true
+	case d.Address != nil:
+		acc, ok := sub.Pre[*d.Address]
+		if !ok {
+			return "", fmt.Errorf("the code of %v is not in the pre-state", d.Address)
+		}
+		code = acc.Code
+		target = sub.Tx.To != "" && *d.Address == common.HexToAddress(sub.Tx.To)
+	default:
+		return "", errors.New("the code is deployed during the transaction")
+	}
+	var (
+		insts   = mutator.Disassemble(code)
+		origins []string
+		note    string
+	)
+	switch {
+	case meta == nil:
+	case !target:
+		note = "not generated from the seed, no strategies to show"
+	case meta.Generator != generator.Version:
+		note = fmt.Sprintf("provenance is from generator version %d, this is version %d; no strategies to show", meta.Generator, generator.Version)
+	default:
+		origins = strategyOrigins(insts, meta.Seed)
+	}
+	at := slices.IndexFunc(insts, func(in mutator.Instruction) bool { return in.PC == step.Pc })
+	if at < 0 {
+		return "", fmt.Errorf("pc %d is not at an instruction of the code", step.Pc)
+	}
+	var b strings.Builder
+	if note != "" {
+		fmt.Fprintf(&b, "(%v)\n", note)
+	}
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for i := max(at-window, 0); i < min(at+window+1, len(insts)); i++ {
+		in := insts[i]
+		mark := ""
+		if i == at {
+			mark = ">"
+		}
+		arg := ""
+		if len(in.Arg) > 0 {
+			arg = hexutil.Encode(in.Arg)
+		}
+		origin := ""
+		if origins != nil {
+			origin = origins[i]
+		}
+		fmt.Fprintf(w, "%v\t%d\t%v %v\t%v\n", mark, in.PC, in.Op, arg, origin)
+	}
+	w.Flush()
+	return b.String(), nil
+}
+
+// strategyOrigins names the strategy that emitted each of insts, as far as
+// they can be found, in order, among the instructions of the program seed
+// generates. Instructions that can't be found are marked with a question mark.
+func strategyOrigins(insts []mutator.Instruction, seed []byte) []string {
+	_, code, invocations := generator.RecordProgram(filler.NewFiller(seed))
+	byPC := make([]string, len(code))
+	for _, in := range invocations {
+		if in.Depth == 0 && !in.Skipped {
+			for pc := in.Start; pc < in.End && pc < len(code); pc++ {
+				byPC[pc] = in.Strategy
+			}
+		}
+	}
+	var (
+		generated = mutator.Disassemble(code)
+		origins   = make([]string, len(insts))
+		next      = 0
+	)
+	for i, in := range insts {
+		origins[i] = "?"
+		for j := next; j < len(generated); j++ {
+			if generated[j].Op == in.Op && bytes.Equal(generated[j].Arg, in.Arg) {
+				origins[i] = byPC[generated[j].PC]
+				next = j + 1
+				break
+			}
+		}
+	}
+	return origins
+}
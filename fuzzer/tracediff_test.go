@@ -0,0 +1,117 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+// perturb rewrites the step-th step of a trace with edit.
+func perturb(t *testing.T, trace []byte, step int, edit func(map[string]any)) []byte {
+	t.Helper()
+	lines := bytes.Split(trace, []byte("\n"))
+	var fields map[string]any
+	if err := json.Unmarshal(lines[step], &fields); err != nil {
+		t.Fatal(err)
+	}
+	edit(fields)
+	line, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines[step] = line
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func TestDiffTraces(t *testing.T) {
+	seed := []byte("asdfadfasdfasdfasdfasdfasdfadsfldlafdsgoinsfandofaijdsf")
+	gst, code, invocations := generator.RecordProgram(filler.NewFiller(seed))
+	var trace bytes.Buffer
+	if err := gst.Fill(&trace, maxTraceSize); err != nil {
+		t.Fatal(err)
+	}
+	steps := bytes.Count(trace.Bytes(), []byte(`"pc"`))
+	if steps < 10 {
+		t.Fatalf("trace of %d steps too short", steps)
+	}
+	if d, err := DiffTraces(bytes.NewReader(trace.Bytes()), bytes.NewReader(trace.Bytes()), 3); err != nil || d != nil {
+		t.Fatalf("trace differs from itself: %v, %v", d, err)
+	}
+
+	// One EVM charges more gas at step 7.
+	other := perturb(t, trace.Bytes(), 7, func(step map[string]any) { step["gas"] = "0x1" })
+	d, err := DiffTraces(bytes.NewReader(trace.Bytes()), bytes.NewReader(other), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || d.Step != 7 || !slices.Equal(d.Fields, []string{"gas"}) || len(d.Before) != 3 || len(d.AfterA) != 3 {
+		t.Fatalf("unexpected divergence %+v", d)
+	}
+	if report := d.String(); !strings.Contains(report, "First divergence at step 7") || !strings.Contains(report, "gas") {
+		t.Errorf("unexpected report:\n%v", report)
+	}
+	// The disassembly points at the step, and names the strategy that emitted
+	// it.
+	test := gst.ToGeneralStateTest("name")
+	out, err := DisassembleDiff(test, "name", d, NewProvenance(seed, invocations, code, test, "name"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want string
+	for _, in := range invocations {
+		if in.Depth == 0 && !in.Skipped && in.Start <= int(d.A.Pc) && int(d.A.Pc) < in.End {
+			want = in.Strategy
+		}
+	}
+	var marked string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, ">") {
+			marked = line
+		}
+	}
+	if want == "" || !strings.HasSuffix(strings.TrimSpace(marked), want) {
+		t.Errorf("divergence at pc %d not attributed to %q:\n%v", d.A.Pc, want, out)
+	}
+
+	// Stack items are compared by value, and reported from the top.
+	other = perturb(t, trace.Bytes(), 7, func(step map[string]any) {
+		stack := step["stack"].([]any)
+		stack = append(stack, "0x0001")
+		step["stack"] = stack
+	})
+	if d, _ := DiffTraces(bytes.NewReader(trace.Bytes()), bytes.NewReader(other), 3); d == nil || !strings.Contains(d.String(), "stack[0]") {
+		t.Errorf("stack difference not reported: %v", d)
+	}
+	// A trace that stops early diverges where it ends.
+	short := bytes.Join(bytes.Split(trace.Bytes(), []byte("\n"))[:5], []byte("\n"))
+	if d, _ := DiffTraces(bytes.NewReader(trace.Bytes()), bytes.NewReader(short), 3); d == nil || d.Step != 5 || d.B != nil {
+		t.Errorf("early end not reported: %+v", d)
+	}
+	// With the same steps, the summaries are compared.
+	a := []byte("{\"pc\":0,\"op\":0,\"gas\":\"0x5\",\"depth\":1}\n{\"stateRoot\":\"0x01\",\"time\":5}\n")
+	b := []byte("{\"pc\":0,\"op\":0,\"gas\":\"0x5\",\"depth\":1}\n{\"stateRoot\":\"0x02\",\"time\":6}\n")
+	if d, _ := DiffTraces(bytes.NewReader(a), bytes.NewReader(b), 3); d == nil || !slices.Equal(d.Fields, []string{"stateRoot"}) {
+		t.Errorf("summary difference not reported: %+v", d)
+	}
+}
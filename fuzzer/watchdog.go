@@ -0,0 +1,217 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrBudgetExceeded is returned for an input that ran over its budget.
+var ErrBudgetExceeded = errors.New("input exceeded its execution budget")
+
+// A Budget bounds what handling one input may cost: the time it takes, and
+// the gas of all executions of its tests together. A zero field is no limit.
+type Budget struct {
+	Time time.Duration `json:"time"`
+	Gas  uint64        `json:"gas"`
+}
+
+// DefaultBudget keeps well clear of the 10s after which go test's fuzzing
+// coordinator declares a worker hung, even on a loaded machine. Minimizing a
+// program re-executes it at most maxMinimizeProbes times, each for up to 16M
+// gas, so the gas limit only stops programs whose probes all run long.
+var DefaultBudget = Budget{Time: 5 * time.Second, Gas: 4_000_000_000}
+
+// Phase is how long one phase of handling an input took.
+type Phase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+}
+
+// A Watch records the cost of handling an input under a Budget.
+type Watch struct {
+	Budget Budget  `json:"budget"`
+	Phases []Phase `json:"phases"`
+	// GasUsed and Executions sum up the test executions.
+	GasUsed    uint64 `json:"gasUsed"`
+	Executions int    `json:"executions"`
+	// Exceeded names the limit the input ran over, "time" or "gas".
+	Exceeded string `json:"exceeded,omitempty"`
+
+	start      time.Time
+	phaseStart time.Time
+	steps      int
+}
+
+// watching is the Watch of the input being handled. The executions that check
+// it are deep inside minimization, which has no way to pass a Watch down, so
+// it is global, and only one input per process can be watched at a time, as go
+// test -fuzz handles them. Watched fails if another input is being watched.
+var watching atomic.Pointer[Watch]
+
+// errWatching is returned by Watched while another input is being watched.
+var errWatching = errors.New("another input is already being watched")
+
+// budgetExceeded is panicked with to unwind out of an execution that ran over
+// its budget.
+//
+// This is not EVM cancellation. go-ethereum's EVM.Cancel is only checked when a
+// call starts, not between the steps of the interpreter, and the EVM of a state
+// test is built inside RunNoVerify where the hooks can't reach it. Panicking
+// out of a tracer hook is the one way to stop a long loop. The panic unwinds
+// through RunNoVerify before it returns the state it opened, so runTraced
+// catches that state when the transaction starts and closes it on the way out.
+type budgetExceeded struct{}
+
+// Watched handles an input with fn, which starts out in the named phase and
+// marks the start of the next ones on the Watch. Every execution of a test fn
+// minimizes is checked against budget, and aborted once the input ran over it,
+// in which case Watched returns ErrBudgetExceeded. It must not be called while
+// another input is being watched.
+func Watched(budget Budget, phase string, fn func(w *Watch) error) (w *Watch, err error) {
+	now := time.Now()
+	w = &Watch{Budget: budget, Phases: []Phase{{Name: phase}}, start: now, phaseStart: now}
+	if !watching.CompareAndSwap(nil, w) {
+		return nil, errWatching
+	}
+	defer func() {
+		watching.Store(nil)
+		w.Phases[len(w.Phases)-1].Duration = time.Since(w.phaseStart)
+		if r := recover(); r != nil {
+			if _, ok := r.(budgetExceeded); !ok {
+				panic(r)
+			}
+			err = ErrBudgetExceeded
+		}
+	}()
+	return w, fn(w)
+}
+
+// Phase ends the current phase and starts the named one. It aborts fn if the
+// input already ran over its budget.
+func (w *Watch) Phase(name string) {
+	now := time.Now()
+	w.Phases[len(w.Phases)-1].Duration = now.Sub(w.phaseStart)
+	w.Phases = append(w.Phases, Phase{Name: name})
+	w.phaseStart = now
+	w.check()
+}
+
+// check aborts execution once the input ran over its budget.
+func (w *Watch) check() {
+	switch {
+	case w.Budget.Time > 0 && time.Since(w.start) > w.Budget.Time:
+		w.Exceeded = "time"
+	case w.Budget.Gas > 0 && w.GasUsed >= w.Budget.Gas:
+		w.Exceeded = "gas"
+	default:
+		return
+	}
+	panic(budgetExceeded{})
+}
+
+// checkWatch checks the budget of the input being handled, if any, every so
+// many calls.
+func checkWatch() {
+	if w := watching.Load(); w != nil {
+		if w.steps++; w.steps%256 == 0 {
+			w.check()
+		}
+	}
+}
+
+// watched adds the checks of the input being handled, if any, to the hooks of
+// an execution.
+func watched(hooks *tracing.Hooks) *tracing.Hooks {
+	w := watching.Load()
+	if w == nil {
+		return hooks
+	}
+	w.Executions++
+	w.check()
+	h := new(tracing.Hooks)
+	if hooks != nil {
+		*h = *hooks
+	}
+	onOpcode, onTxEnd := h.OnOpcode, h.OnTxEnd
+	h.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		checkWatch()
+		if onOpcode != nil {
+			onOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+	h.OnTxEnd = func(receipt *types.Receipt, err error) {
+		if receipt != nil {
+			w.GasUsed += receipt.GasUsed
+		}
+		if onTxEnd != nil {
+			onTxEnd(receipt, err)
+		}
+	}
+	return h
+}
+
+// Quarantined is an input that ran over its budget, with what it cost.
+type Quarantined struct {
+	Seed  hexutil.Bytes `json:"seed"`
+	Watch *Watch        `json:"watch"`
+}
+
+// Quarantine saves seed, which ran over its budget as w recorded, to dir as
+// <hash>.json. Slow inputs are worth keeping: the programs they generate are
+// benchmark material for mispriced operations.
+func Quarantine(dir string, seed []byte, w *Watch) error {
+	data, err := json.MarshalIndent(Quarantined{Seed: seed, Watch: w}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, common.Bytes2Hex(crypto.Keccak256(seed))+".json")
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadQuarantine reads the inputs quarantined in dir.
+func ReadQuarantine(dir string) ([]Quarantined, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]Quarantined, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var q Quarantined
+		if err := json.Unmarshal(data, &q); err != nil {
+			return nil, fmt.Errorf("invalid quarantined input %v: %w", path, err)
+		}
+		inputs = append(inputs, q)
+	}
+	return inputs, nil
+}
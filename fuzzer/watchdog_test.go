@@ -0,0 +1,117 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package fuzzer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/MariusVanDerWijden/FuzzyVM/generator"
+)
+
+func TestWatched(t *testing.T) {
+	seed := []byte("watchwatchwatchwatchwatchwatchwatchwatch")
+	// A loop that runs until it is out of gas.
+	p := program.New()
+	p.Jumpdest()
+	loop := p.Push(0).Op(vm.JUMP).Bytes()
+	test := generator.CreateGstMaker(filler.NewFiller(seed), loop).ToGeneralStateTest("name")
+	run := func() {
+		_, done, _ := runTraced(test, "name", nil)
+		done()
+	}
+
+	// Within budget, the phases and executions are recorded.
+	w, err := Watched(Budget{}, "generate", func(w *Watch) error {
+		w.Phase("minimize")
+		run()
+		return nil
+	})
+	if err != nil || len(w.Phases) != 2 || w.Phases[1].Name != "minimize" || w.Executions != 1 || w.GasUsed == 0 {
+		t.Fatalf("unexpected watch %+v, %v", w, err)
+	}
+	gas := w.GasUsed
+	// So is the execution minimization starts with, which finds the loop's
+	// trace too large to go on.
+	w, err = Watched(Budget{}, "minimize", func(*Watch) error {
+		_, _, err := MinimizeProgram(generator.CreateGstMaker(filler.NewFiller(seed), loop))
+		return err
+	})
+	if !errors.Is(err, ErrTraceTooLarge) || w.Executions != 1 || w.GasUsed != gas {
+		t.Fatalf("minimization not watched: %+v, %v", w, err)
+	}
+
+	// The loop is aborted once it runs out of time.
+	start := time.Now()
+	w, err = Watched(Budget{Time: time.Millisecond}, "minimize", func(*Watch) error {
+		for range 100 {
+			run()
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrBudgetExceeded) || w.Exceeded != "time" || time.Since(start) > time.Second {
+		t.Errorf("time budget not enforced: %+v, %v after %v", w, err, time.Since(start))
+	}
+	// And the second run once the first used up the gas.
+	w, err = Watched(Budget{Gas: gas}, "minimize", func(*Watch) error {
+		run()
+		run()
+		run()
+		return nil
+	})
+	if !errors.Is(err, ErrBudgetExceeded) || w.Exceeded != "gas" || w.Executions != 2 {
+		t.Errorf("gas budget not enforced: %+v, %v", w, err)
+	}
+	if watching.Load() != nil {
+		t.Error("watch still active")
+	}
+	// Only one input is watched at a time.
+	Watched(Budget{}, "generate", func(*Watch) error {
+		if _, err := Watched(Budget{}, "generate", func(*Watch) error { return nil }); !errors.Is(err, errWatching) {
+			t.Errorf("nested watch: %v, want %v", err, errWatching)
+		}
+		return nil
+	})
+	// Other panics are not budget overruns.
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want boom", r)
+			}
+		}()
+		Watched(Budget{}, "generate", func(*Watch) error { panic("boom") })
+	}()
+
+	// Quarantined inputs are read back with their watch.
+	dir := t.TempDir()
+	if err := Quarantine(dir, seed, w); err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := ReadQuarantine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || !bytes.Equal(inputs[0].Seed, seed) || inputs[0].Watch.Exceeded != "gas" {
+		t.Errorf("unexpected quarantine %+v", inputs)
+	}
+}
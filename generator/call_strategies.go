@@ -82,7 +82,7 @@ func (*createCallGenerator) Execute(env Environment) {
 		seedLen   = env.f.Uint16()
 		seed      = env.f.ByteSlice(int(seedLen))
 		newFiller = filler.NewFiller(seed)
-		code      = generateCode(newFiller, env.recursionLevel+1, env.budget)
+		code      = generateCode(newFiller, env.recursionLevel+1, env.budget, env.rec)
 		isCreate2 = env.f.Bool()
 		callOp    = randomCallOp(env)
 	)
@@ -42,16 +42,33 @@ var (
 	maxRecursionLevel = 10
 )
 
+// Version identifies how the generator turns filler input into programs. It is
+// bumped whenever the same input starts generating a different program, so
+// an input recorded with a test only reproduces it at the same version.
+const Version = 1
+
+// Fork returns the fork every generated state test is enabled for.
+func Fork() string {
+	return fork
+}
+
 var strategies *selector
 
 func init() {
+	strategies = newSelector(defaultStrategies())
+}
+
+// defaultStrategies returns the strategies every generation selects from.
+// Strategies that need loaded data, like verbatimChunkGenerator, are added on
+// top of them once it is.
+func defaultStrategies() []Strategy {
 	strats := []Strategy{}
 	strats = append(strats, basicStrategies...)
 	strats = append(strats, callStrategies...)
 	strats = append(strats, jumpStrategies...)
 	strats = append(strats, stackStrategies...)
 	strats = append(strats, coverageStrategies...)
-	strategies = newSelector(strats)
+	return strats
 }
 
 // maxTotalBytes caps the total bytecode emitted across a whole generation tree
@@ -64,12 +81,13 @@ const maxTotalBytes = 10000
 // a gstMaker based on it as well as its program code.
 func GenerateProgram(f *filler.Filler) (*fuzzing.GstMaker, []byte) {
 	budget := maxTotalBytes
-	code := generateCode(f, 0, &budget)
+	code := generateCode(f, 0, &budget, nil)
 	return CreateGstMaker(f, code), code
 }
 
 // generateCode builds the bytecode recursively, limited by a byte length budget.
-func generateCode(f *filler.Filler, recursionLevel int, budget *int) []byte {
+// Every strategy it selects is reported to rec, which may be nil.
+func generateCode(f *filler.Filler, recursionLevel int, budget *int, rec *recorder) []byte {
 	if budget == nil {
 		// Defensive: a direct caller (e.g. a test) may not supply one. Give this
 		// subtree its own budget rather than dereferencing nil.
@@ -86,6 +104,7 @@ func generateCode(f *filler.Filler, recursionLevel int, budget *int) []byte {
 			labels:         &labels,
 			stackHeight:    &stackHeight,
 			budget:         budget,
+			rec:            rec,
 		}
 	)
 
@@ -103,8 +122,16 @@ func generateCode(f *filler.Filler, recursionLevel int, budget *int) []byte {
 		if Debug {
 			fmt.Fprintf(os.Stderr, "%*sstrategy: %s\n", recursionLevel*2, "", strategy.String())
 		}
+		at := rec.count()
+		if rec.next(strategy, recursionLevel) {
+			// Leave the strategy out, but let it draw from the filler as usual.
+			rec.skipped(env, strategy)
+			rec.emitted(at, prev, prev)
+			continue
+		}
 		// Execute the strategy.
 		strategy.Execute(env)
+		rec.emitted(at, prev, len(env.p.Bytes()))
 		grown := len(env.p.Bytes()) - prev
 		prev = len(env.p.Bytes())
 		*budget -= grown
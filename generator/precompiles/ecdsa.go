@@ -17,8 +17,6 @@
 package precompiles
 
 import (
-	"crypto/ecdsa"
-
 	"github.com/MariusVanDerWijden/FuzzyVM/filler"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm/program"
@@ -31,7 +29,10 @@ var ecdsaAddr = common.HexToAddress("0x1")
 type ecdsaCaller struct{}
 
 func (*ecdsaCaller) call(p *program.Program, f *filler.Filler) error {
-	sk, err := ecdsa.GenerateKey(crypto.S256(), f)
+	// Derive the key from filler bytes rather than ecdsa.GenerateKey, which
+	// reads a random number of bytes from its reader (or ignores it entirely),
+	// so the same input would not always generate the same program.
+	sk, err := crypto.ToECDSA(f.ByteSlice(32))
 	if err != nil {
 		return err
 	}
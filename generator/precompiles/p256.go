@@ -17,8 +17,11 @@
 package precompiles
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
 
 	"github.com/MariusVanDerWijden/FuzzyVM/filler"
 	"github.com/ethereum/go-ethereum/common"
@@ -64,24 +67,31 @@ func (*p256Caller) call(p *program.Program, f *filler.Filler) error {
 // hash(32) || r(32) || s(32) || pubX(32) || pubY(32), each big-endian and
 // left-padded to 32 bytes.
 func validP256Input(f *filler.Filler) ([]byte, error) {
-	sk, err := ecdsa.GenerateKey(elliptic.P256(), f)
+	// Neither the key nor the signature may come from the filler as a reader:
+	// crypto/ecdsa reads a random number of bytes from it (or ignores it
+	// entirely), so the same input would not always generate the same program.
+	sk, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), f.ByteSlice(32))
 	if err != nil {
 		return nil, err
 	}
 	hash := f.ByteSlice(32)
-	r, s, err := ecdsa.Sign(f, sk, hash)
+	der, err := sk.Sign(nil, hash, crypto.SHA256) // RFC 6979
+	if err != nil {
+		return nil, err
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+	pub, err := sk.PublicKey.Bytes() // 0x04 || X || Y
 	if err != nil {
 		return nil, err
 	}
 	input := make([]byte, 160)
 	copy(input[0:32], hash)
-	copy(input[32:64], leftPad32(r.Bytes()))
-	copy(input[64:96], leftPad32(s.Bytes()))
-	// sk.X/sk.Y are the deprecated raw-coordinate accessors, but we only read
-	// them to build the precompile's fixed-width big-endian pubkey fields, which
-	// is exactly what the precompile decodes with big.Int.SetBytes.
-	copy(input[96:128], leftPad32(sk.X.Bytes()))
-	copy(input[128:160], leftPad32(sk.Y.Bytes()))
+	copy(input[32:64], leftPad32(sig.R.Bytes()))
+	copy(input[64:96], leftPad32(sig.S.Bytes()))
+	copy(input[96:160], pub[1:])
 	return input, nil
 }
 
@@ -1,6 +1,7 @@
 package precompiles
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/MariusVanDerWijden/FuzzyVM/filler"
@@ -29,4 +30,9 @@ func TestValidP256Input(t *testing.T) {
 	if len(out) != 32 || out[31] != 1 {
 		t.Fatalf("valid signature did not verify: out=%x", out)
 	}
+	// The same filler bytes must always produce the same input.
+	again, _ := validP256Input(filler.NewFiller([]byte{1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89, 144, 233}))
+	if !bytes.Equal(input, again) {
+		t.Fatalf("input is not deterministic: %x != %x", input, again)
+	}
 }
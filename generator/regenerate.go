@@ -0,0 +1,126 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+	"github.com/holiman/goevmlab/fuzzing"
+)
+
+// Invocation is one strategy the generator selected while building a program.
+type Invocation struct {
+	Strategy string `json:"strategy"`
+	// Depth is the recursion level, 0 for the top-level program and one more
+	// for every sub-program a strategy generates.
+	Depth int `json:"depth"`
+	// Skipped is set if the strategy was left out of the program, either
+	// because it was asked to be or because an enclosing strategy was.
+	Skipped bool `json:"skipped,omitempty"`
+	// Start and End delimit the bytes the strategy emitted into the code
+	// generated at its depth, sub-programs it generated included.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// recorder numbers the strategy invocations of a generation tree in the order
+// they are selected, nested generations included, and decides which to skip.
+type recorder struct {
+	invocations []Invocation
+	skip        map[int]bool
+	muted       int // > 0 while running a skipped strategy
+}
+
+// next records the selection of s and reports whether it should be skipped.
+func (r *recorder) next(s Strategy, depth int) bool {
+	if r == nil {
+		return false
+	}
+	skip := r.muted > 0 || r.skip[len(r.invocations)]
+	r.invocations = append(r.invocations, Invocation{Strategy: s.String(), Depth: depth, Skipped: skip})
+	return skip && r.muted == 0
+}
+
+// emitted records that invocation i emitted the code between start and end.
+func (r *recorder) emitted(i, start, end int) {
+	if r != nil {
+		r.invocations[i].Start, r.invocations[i].End = start, end
+	}
+}
+
+// count returns how many invocations have been recorded so far.
+func (r *recorder) count() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.invocations)
+}
+
+// skipped runs s against a scratch copy of env and throws away what it emits.
+// It still draws the same bytes from the filler, so the strategies after it
+// see the same randomness as in the full program. Strategies that look at what
+// was emitted before them (jump labels, the stack height model) can still come
+// out differently.
+func (r *recorder) skipped(env Environment, s Strategy) {
+	var (
+		labels      = slices.Clone(*env.labels)
+		stackHeight = *env.stackHeight
+		budget      = *env.budget
+	)
+	env.p = program.New()
+	env.labels = &labels
+	env.stackHeight = &stackHeight
+	env.budget = &budget
+	r.muted++
+	s.Execute(env)
+	r.muted--
+}
+
+// RecordProgram generates the same program as GenerateProgram, and also
+// returns the strategy invocations it was built from.
+func RecordProgram(f *filler.Filler) (*fuzzing.GstMaker, []byte, []Invocation) {
+	return RegenerateProgram(f, nil)
+}
+
+// RegenerateProgram generates the program f describes with the strategy
+// invocations at the indices in skip left out. Indices count invocations in
+// the order RecordProgram reports them. The returned invocations are those of
+// the regenerated program, with the left out ones marked Skipped.
+func RegenerateProgram(f *filler.Filler, skip map[int]bool) (*fuzzing.GstMaker, []byte, []Invocation) {
+	var (
+		budget = maxTotalBytes
+		rec    = &recorder{skip: skip}
+		code   = generateCode(f, 0, &budget, rec)
+	)
+	return CreateGstMaker(f, code), code, rec.invocations
+}
+
+// FormatInvocations lists the strategies that were not skipped, one per line
+// and indented by depth.
+func FormatInvocations(invocations []Invocation) string {
+	var b strings.Builder
+	for _, in := range invocations {
+		if !in.Skipped {
+			fmt.Fprintf(&b, "%*s%v\n", 2*in.Depth, "", in.Strategy)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+)
+
+func TestRegenerateProgram(t *testing.T) {
+	seed := []byte(strings.Repeat("regenerate the same program ", 40))
+	_, want := GenerateProgram(filler.NewFiller(seed))
+	_, code, invocations := RecordProgram(filler.NewFiller(seed))
+	if !bytes.Equal(code, want) {
+		t.Fatalf("recording changed the program")
+	}
+	if len(invocations) < 2 {
+		t.Fatalf("only %d invocations recorded", len(invocations))
+	}
+	// The top-level invocations emitted the program between them, in order.
+	end := 0
+	for _, in := range invocations {
+		if in.Depth == 0 {
+			if in.Start != end || in.End < in.Start {
+				t.Fatalf("%v emitted %d..%d, want it to start at %d", in.Strategy, in.Start, in.End, end)
+			}
+			end = in.End
+		}
+	}
+	if end != len(code) {
+		t.Errorf("invocations emitted %d bytes of %d", end, len(code))
+	}
+	// Regenerating is deterministic and skipping nothing changes nothing.
+	if _, again, _ := RegenerateProgram(filler.NewFiller(seed), map[int]bool{}); !bytes.Equal(again, want) {
+		t.Errorf("regenerating without skips changed the program")
+	}
+	// Leaving out every top-level invocation leaves nothing behind.
+	skip := make(map[int]bool)
+	for i, in := range invocations {
+		skip[i] = in.Depth == 0
+	}
+	_, code, skipped := RegenerateProgram(filler.NewFiller(seed), skip)
+	if len(code) != 0 {
+		t.Errorf("skipping everything left %x", code)
+	}
+	if out := FormatInvocations(skipped); out != "" {
+		t.Errorf("skipped invocations are listed:\n%v", out)
+	}
+	// Leaving out one shortens the program, and the list.
+	_, code, fewer := RegenerateProgram(filler.NewFiller(seed), map[int]bool{0: true})
+	if !fewer[0].Skipped || len(code) >= len(want) {
+		t.Errorf("skipping %v did not shorten the program", fewer[0].Strategy)
+	}
+	if lines := strings.Count(FormatInvocations(fewer), "\n"); lines >= len(invocations) {
+		t.Errorf("%d strategies listed, want fewer than %d", lines, len(invocations))
+	}
+}
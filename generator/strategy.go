@@ -61,6 +61,9 @@ type Environment struct {
 	// maxRecursionLevel could emit megabytes of code and take near-forever to
 	// generate, execute and minimize.
 	budget *int
+	// rec records the strategies run in this generation tree, if it is being
+	// recorded for strategy-level minimization.
+	rec *recorder
 }
 
 // addLabel emits a JUMPDEST and records its PC as a reusable jump target.
@@ -0,0 +1,96 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"sync"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/mutator"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// verbatimChunks is the pool of code fragments verbatimChunkGenerator draws
+// from. It is filled at startup from imported state tests (see
+// AddVerbatimChunks) and only read afterwards.
+var verbatimChunks struct {
+	sync.RWMutex
+	list [][]mutator.Instruction
+}
+
+// AddVerbatimChunks splits each code into basic blocks and adds them to the
+// pool of fragments generated programs may copy verbatim. Fragments from
+// hand-written tests (ethereum/tests, earlier campaigns) carry operand patterns
+// and opcode sequences the strategies would never produce on their own.
+//
+// The first chunks added register verbatimChunkGenerator with the selector.
+// Until then the selector is untouched, so inputs generate the same programs
+// they always did unless chunks are loaded. It must be called before any
+// program is generated.
+func AddVerbatimChunks(codes ...[]byte) {
+	verbatimChunks.Lock()
+	defer verbatimChunks.Unlock()
+	empty := len(verbatimChunks.list) == 0
+	for _, code := range codes {
+		insts := mutator.Disassemble(code)
+		for _, b := range mutator.BasicBlocks(insts) {
+			var chunk []mutator.Instruction
+			for _, in := range insts[b[0]:b[1]] {
+				// A PUSH cut off by the end of the code would swallow the
+				// bytes emitted after it.
+				if in.Op.IsPush() && len(in.Arg) < int(in.Op-vm.PUSH0) {
+					break
+				}
+				chunk = append(chunk, in)
+			}
+			if len(chunk) > 0 {
+				verbatimChunks.list = append(verbatimChunks.list, chunk)
+			}
+		}
+	}
+	if empty && len(verbatimChunks.list) > 0 {
+		strategies = newSelector(append(defaultStrategies(), new(verbatimChunkGenerator)))
+	}
+}
+
+// verbatimChunkGenerator copies one imported code fragment into the program.
+// Jumps inside the fragment keep their original targets, so they usually land
+// somewhere invalid; the straight-line part of the fragment is what matters.
+// It is only selected once AddVerbatimChunks loaded fragments.
+type verbatimChunkGenerator struct{}
+
+func (*verbatimChunkGenerator) Execute(env Environment) {
+	verbatimChunks.RLock()
+	defer verbatimChunks.RUnlock()
+	chunk := verbatimChunks.list[int(env.f.Uint16())%len(verbatimChunks.list)]
+	if env.budget != nil {
+		// Copy only whole instructions that fit, so no PUSH loses its operand.
+		size, n := 0, 0
+		for ; n < len(chunk) && size+chunk[n].Size() <= *env.budget; n++ {
+			size += chunk[n].Size()
+		}
+		chunk = chunk[:n]
+	}
+	env.p.Append(mutator.Assemble(chunk))
+}
+
+func (*verbatimChunkGenerator) Importance() int {
+	return 2
+}
+
+func (*verbatimChunkGenerator) String() string {
+	return "verbatimChunkGenerator"
+}
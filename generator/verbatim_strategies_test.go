@@ -0,0 +1,59 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+)
+
+// TestVerbatimChunks checks that loading chunks is what registers the strategy,
+// and that chunks never end in a PUSH missing its operand.
+func TestVerbatimChunks(t *testing.T) {
+	defer func(sel *selector) {
+		strategies = sel
+		verbatimChunks.list = nil
+	}(strategies)
+
+	for _, s := range strategies.strats {
+		if _, ok := s.(*verbatimChunkGenerator); ok {
+			t.Fatal("verbatimChunkGenerator selectable without chunks")
+		}
+	}
+	// PUSH1 1 PUSH1 2 ADD PUSH2 0x03 (cut off)
+	AddVerbatimChunks([]byte{0x60, 0x01, 0x60, 0x02, 0x01, 0x61, 0x03})
+	if n := len(strategies.strats); n != len(defaultStrategies())+2 {
+		t.Fatalf("%d strategies after loading chunks, want the defaults, the chunks and the fallback", n)
+	}
+	for _, tt := range []struct {
+		budget int
+		want   []byte
+	}{
+		{100, []byte{0x60, 0x01, 0x60, 0x02, 0x01}},
+		// The second PUSH1 doesn't fit whole.
+		{3, []byte{0x60, 0x01}},
+	} {
+		env := Environment{p: program.New(), f: filler.NewFiller([]byte{0, 0}), budget: &tt.budget}
+		new(verbatimChunkGenerator).Execute(env)
+		if got := env.p.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("budget %d: emitted %x, want %x", tt.budget, got, tt.want)
+		}
+	}
+}
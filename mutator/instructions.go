@@ -0,0 +1,200 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package mutator
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// noPC marks an instruction that has no position in the original code, e.g. one
+// inserted by a mutation or spliced in from another program.
+const noPC = ^uint64(0)
+
+// Instruction is a single decoded EVM instruction.
+type Instruction struct {
+	// PC is the position of the instruction in the code it was decoded from, or
+	// noPC if it was created by a mutation. Relocation uses it to map jump
+	// targets from the old layout to the new one.
+	PC  uint64
+	Op  vm.OpCode
+	Arg []byte
+}
+
+// Size returns the number of code bytes the instruction occupies.
+func (in Instruction) Size() int {
+	return 1 + len(in.Arg)
+}
+
+// Disassemble splits code into instructions. Unlike the goevmlab iterator it
+// does not error on a PUSH whose operand runs past the end of the code (which
+// random bytecode frequently has); the operand is simply truncated, exactly as
+// the EVM reads it.
+func Disassemble(code []byte) []Instruction {
+	var out []Instruction
+	for pc := 0; pc < len(code); {
+		op := vm.OpCode(code[pc])
+		in := Instruction{PC: uint64(pc), Op: op}
+		n := 1
+		if op.IsPush() {
+			width := int(op - vm.PUSH0)
+			end := min(pc+1+width, len(code))
+			in.Arg = code[pc+1 : end]
+			n += width
+		}
+		out = append(out, in)
+		pc += n
+	}
+	return out
+}
+
+// Assemble concatenates instructions back into bytecode.
+func Assemble(insts []Instruction) []byte {
+	size := 0
+	for _, in := range insts {
+		size += in.Size()
+	}
+	code := make([]byte, 0, size)
+	for _, in := range insts {
+		code = append(code, byte(in.Op))
+		code = append(code, in.Arg...)
+	}
+	return code
+}
+
+// Clone returns a deep copy of insts, so edits don't alias the decoded code.
+func Clone(insts []Instruction) []Instruction {
+	out := make([]Instruction, len(insts))
+	for i, in := range insts {
+		out[i] = Instruction{PC: in.PC, Op: in.Op, Arg: append([]byte(nil), in.Arg...)}
+	}
+	return out
+}
+
+// isJumpTarget reports whether insts[i] is the PUSH that supplies the
+// destination of a JUMP or JUMPI. The generator always emits the destination as
+// the push right before the jump (program.Jump, program.JumpIf and the bounded
+// loop all do), so that is the only shape relocation needs to understand.
+func isJumpTarget(insts []Instruction, i int) bool {
+	if i+1 >= len(insts) || !insts[i].Op.IsPush() || insts[i].Op == vm.PUSH0 {
+		return false
+	}
+	next := insts[i+1].Op
+	return next == vm.JUMP || next == vm.JUMPI
+}
+
+// Relocate assembles insts into bytecode, rewriting every jump-target PUSH that
+// pointed at a JUMPDEST in the original layout so it points at that JUMPDEST's
+// new position. Targets whose JUMPDEST was removed are left untouched (the jump
+// just becomes invalid, which is a perfectly good fuzz input). A PUSH that is
+// too narrow for its new target is widened, which shifts everything after it, so
+// layout is recomputed until it is stable.
+func Relocate(insts []Instruction) []byte {
+	insts = Clone(insts)
+	// Remember which original JUMPDEST every jump-target PUSH refers to before
+	// any operand is rewritten.
+	dests := make(map[uint64]bool)
+	for _, in := range insts {
+		if in.Op == vm.JUMPDEST && in.PC != noPC {
+			dests[in.PC] = true
+		}
+	}
+	targets := make(map[int]uint64)
+	for i := range insts {
+		if !isJumpTarget(insts, i) {
+			continue
+		}
+		old := new(big.Int).SetBytes(insts[i].Arg)
+		if old.IsUint64() && dests[old.Uint64()] {
+			targets[i] = old.Uint64()
+		}
+	}
+	// Widening only ever grows a push, so this converges; the bound is just a
+	// guard against a bug turning it into an endless loop.
+	for range 8 {
+		moved := make(map[uint64]uint64)
+		pc := uint64(0)
+		for _, in := range insts {
+			if in.Op == vm.JUMPDEST && in.PC != noPC {
+				moved[in.PC] = pc
+			}
+			pc += uint64(in.Size())
+		}
+		widened := false
+		for i, old := range targets {
+			dest, ok := moved[old]
+			if !ok {
+				continue
+			}
+			arg := new(big.Int).SetUint64(dest).Bytes()
+			if width := len(insts[i].Arg); len(arg) <= width {
+				insts[i].Arg = leftPad(arg, width)
+				continue
+			}
+			insts[i].Op = vm.PUSH0 + vm.OpCode(len(arg))
+			insts[i].Arg = arg
+			widened = true
+		}
+		if !widened {
+			break
+		}
+	}
+	return Assemble(insts)
+}
+
+// BasicBlocks splits insts into basic blocks: a block starts at a JUMPDEST (or
+// the beginning of the code) and ends after an instruction that transfers
+// control or halts. It returns the [start, end) instruction index of each block.
+func BasicBlocks(insts []Instruction) [][2]int {
+	var (
+		blocks [][2]int
+		start  = 0
+	)
+	for i, in := range insts {
+		if in.Op == vm.JUMPDEST && i > start {
+			blocks = append(blocks, [2]int{start, i})
+			start = i
+		}
+		if endsBlock(in.Op) {
+			blocks = append(blocks, [2]int{start, i + 1})
+			start = i + 1
+		}
+	}
+	if start < len(insts) {
+		blocks = append(blocks, [2]int{start, len(insts)})
+	}
+	return blocks
+}
+
+// endsBlock reports whether op ends a basic block.
+func endsBlock(op vm.OpCode) bool {
+	switch op {
+	case vm.JUMP, vm.JUMPI, vm.STOP, vm.RETURN, vm.REVERT, vm.INVALID, vm.SELFDESTRUCT:
+		return true
+	}
+	return false
+}
+
+func leftPad(b []byte, width int) []byte {
+	if len(b) >= width {
+		return b
+	}
+	out := make([]byte, width)
+	copy(out[width-len(b):], b)
+	return out
+}
@@ -0,0 +1,300 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package mutator applies EVM-aware mutations to existing bytecode.
+//
+// The generator builds programs from scratch, so all of its novelty comes from
+// the filler bytes the Go fuzzer mutates. The mutator instead starts from codes
+// that already proved interesting (the fuzzyvm-db corpus) and edits them at the
+// instruction level: it keeps PUSH operands intact, only swaps opcodes for ones
+// with the same stack shape, and relocates jump targets after every edit so the
+// control flow of the original program survives.
+package mutator
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/goevmlab/ops"
+)
+
+const (
+	// maxMutations bounds how many mutations a single Mutate call stacks.
+	maxMutations = 4
+	// maxInsert bounds the instructions a single insertion or deletion touches.
+	maxInsert = 4
+	// maxSpliceLen bounds the instructions copied by a single splice, so a
+	// donor with one enormous block can't double the program size.
+	maxSpliceLen = 256
+)
+
+// mutation is one EVM-aware edit. apply returns the edited instructions; it
+// must not modify its inputs.
+type mutation struct {
+	name  string
+	apply func(f *filler.Filler, insts, donor []Instruction) []Instruction
+}
+
+var mutations = []mutation{
+	{"substituteOpcode", substituteOpcode},
+	{"tweakPush", tweakPush},
+	{"spliceBlock", spliceBlock},
+	{"insertInstructions", insertInstructions},
+	{"deleteInstructions", deleteInstructions},
+}
+
+// Mutate applies between one and maxMutations mutations to code, drawing every
+// decision from f so a mutation is reproducible from its filler input. donor is
+// a second program that splicing may copy basic blocks from; it may be nil. It
+// returns the mutated code and the names of the mutations applied, in order.
+// The result never exceeds the EIP-170 code size limit: a mutation that would
+// grow past it is dropped.
+func Mutate(f *filler.Filler, code, donor []byte) ([]byte, []string) {
+	var (
+		insts      = Disassemble(code)
+		donorInsts = Disassemble(donor)
+		applied    []string
+	)
+	rounds := int(f.Byte())%maxMutations + 1
+	for range rounds {
+		m := mutations[int(f.Byte())%len(mutations)]
+		next := m.apply(f, insts, donorInsts)
+		if len(Assemble(next)) > params.MaxCodeSize {
+			continue
+		}
+		insts = next
+		applied = append(applied, m.name)
+	}
+	return Relocate(insts), applied
+}
+
+// opcodeDefined[op] is true iff op is a defined EVM opcode in go-ethereum.
+var opcodeDefined = func() [256]bool {
+	var defined [256]bool
+	for i := range 256 {
+		// String() returns "opcode 0x.. not defined" for unassigned opcodes.
+		defined[i] = !strings.Contains(vm.OpCode(i).String(), "not defined")
+	}
+	return defined
+}()
+
+// substitutable reports whether op may be replaced by (or replace) another
+// opcode. PUSHes carry an immediate, and JUMP/JUMPI/JUMPDEST make up the control
+// flow the mutator tries to preserve, so none of them are swapped.
+func substitutable(op vm.OpCode) bool {
+	if !opcodeDefined[op] || op.IsPush() {
+		return false
+	}
+	switch op {
+	case vm.JUMP, vm.JUMPI, vm.JUMPDEST:
+		return false
+	}
+	return true
+}
+
+// arity is an opcode's stack shape: the number of items it pops and pushes.
+type arity struct{ pops, pushes int }
+
+// arityClasses groups the substitutable opcodes by stack shape, so an opcode is
+// only ever replaced by one that consumes and produces the same number of items.
+// The program around it then still sees the stack it expects.
+var arityClasses = func() map[arity][]vm.OpCode {
+	classes := make(map[arity][]vm.OpCode)
+	for i := range 256 {
+		op := vm.OpCode(i)
+		if !substitutable(op) || !ops.IsDefined(ops.OpCode(op)) {
+			continue
+		}
+		a := arityOf(op)
+		classes[a] = append(classes[a], op)
+	}
+	return classes
+}()
+
+func arityOf(op vm.OpCode) arity {
+	return arity{len(ops.OpCode(op).Pops()), len(ops.OpCode(op).Pushes())}
+}
+
+// pick returns a random index into a slice of length n, or -1 if it is empty.
+func pick(f *filler.Filler, n int) int {
+	if n == 0 {
+		return -1
+	}
+	return int(f.Uint16()) % n
+}
+
+// substituteOpcode replaces one opcode with another of the same stack arity.
+func substituteOpcode(f *filler.Filler, insts, _ []Instruction) []Instruction {
+	var candidates []int
+	for i, in := range insts {
+		if substitutable(in.Op) && len(arityClasses[arityOf(in.Op)]) > 1 {
+			candidates = append(candidates, i)
+		}
+	}
+	i := pick(f, len(candidates))
+	if i < 0 {
+		return insts
+	}
+	out := Clone(insts)
+	idx := candidates[i]
+	class := arityClasses[arityOf(out[idx].Op)]
+	out[idx].Op = class[pick(f, len(class))]
+	return out
+}
+
+// interestingValues are the operands PUSH tweaks move towards: the boundaries
+// where EVM arithmetic, memory expansion and comparisons historically diverge.
+var interestingValues = []*big.Int{
+	big.NewInt(0),
+	big.NewInt(1),
+	big.NewInt(2),
+	big.NewInt(31),
+	big.NewInt(32),
+	big.NewInt(255),
+	big.NewInt(256),
+	new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1)), // 2^255-1
+	new(big.Int).Lsh(big.NewInt(1), 255),                                  // 2^255
+	new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)), // 2^256-1
+}
+
+// tweakPush moves one PUSH operand towards an interesting value: a boundary
+// constant, or the current value plus or minus one. Jump-target pushes are left
+// alone, since relocation owns them. The operand keeps its width; values that
+// don't fit are truncated to their low bytes, which for the all-ones and
+// sign-bit values yields the same boundary at the narrower width.
+func tweakPush(f *filler.Filler, insts, _ []Instruction) []Instruction {
+	var candidates []int
+	for i, in := range insts {
+		if in.Op.IsPush() && len(in.Arg) > 0 && !isJumpTarget(insts, i) {
+			candidates = append(candidates, i)
+		}
+	}
+	i := pick(f, len(candidates))
+	if i < 0 {
+		return insts
+	}
+	out := Clone(insts)
+	in := &out[candidates[i]]
+	cur := new(big.Int).SetBytes(in.Arg)
+	var val *big.Int
+	switch f.Byte() % 4 {
+	case 0:
+		val = new(big.Int).Add(cur, big.NewInt(1))
+	case 1:
+		val = new(big.Int).Sub(cur, big.NewInt(1))
+	default:
+		val = interestingValues[pick(f, len(interestingValues))]
+	}
+	in.Arg = truncate(val, len(in.Arg))
+	return out
+}
+
+// truncate returns the low width bytes of v in two's complement, big-endian.
+func truncate(v *big.Int, width int) []byte {
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(8*width))
+	return leftPad(new(big.Int).Mod(v, mod).Bytes(), width)
+}
+
+// spliceBlock copies a basic block from the donor program and inserts it at a
+// block boundary of the recipient. The copied instructions get no original PC,
+// so their JUMPDESTs don't capture the recipient's jump targets.
+func spliceBlock(f *filler.Filler, insts, donor []Instruction) []Instruction {
+	if len(donor) == 0 {
+		return insts
+	}
+	blocks := BasicBlocks(donor)
+	b := blocks[pick(f, len(blocks))]
+	end := min(b[1], b[0]+maxSpliceLen)
+	chunk := Clone(donor[b[0]:end])
+	for i := range chunk {
+		chunk[i].PC = noPC
+	}
+	// Insert at the start of one of the recipient's blocks, or at the very end.
+	at := len(insts)
+	if recv := BasicBlocks(insts); len(recv) > 0 {
+		if i := pick(f, len(recv)+1); i < len(recv) {
+			at = recv[i][0]
+		}
+	}
+	return insertAt(insts, at, chunk)
+}
+
+// insertInstructions inserts a few random instructions at a random position:
+// defined opcodes other than JUMPDEST and the jumps, or a PUSH of an interesting
+// value. The position is never between a jump and the PUSH of its destination.
+func insertInstructions(f *filler.Filler, insts, _ []Instruction) []Instruction {
+	n := int(f.Byte())%maxInsert + 1
+	chunk := make([]Instruction, 0, n)
+	// Undefined draws are retried, but boundedly: the filler wraps around, so a
+	// degenerate input could otherwise keep producing undefined opcodes forever.
+	for tries := 0; len(chunk) < n && tries < 4*maxInsert; tries++ {
+		if f.Byte() < 64 {
+			width := 1
+			if f.Bool() {
+				width = 32
+			}
+			val := interestingValues[pick(f, len(interestingValues))]
+			chunk = append(chunk, Instruction{PC: noPC, Op: vm.PUSH0 + vm.OpCode(width), Arg: truncate(val, width)})
+			continue
+		}
+		op := vm.OpCode(f.Byte())
+		if !opcodeDefined[op] || op.IsPush() || controlFlow(op) {
+			continue
+		}
+		chunk = append(chunk, Instruction{PC: noPC, Op: op})
+	}
+	at := pick(f, len(insts)+1)
+	if at > 0 && isJumpTarget(insts, at-1) {
+		at--
+	}
+	return insertAt(insts, at, chunk)
+}
+
+// controlFlow reports whether op is a JUMPDEST or a jump.
+func controlFlow(op vm.OpCode) bool {
+	return op == vm.JUMPDEST || op == vm.JUMP || op == vm.JUMPI
+}
+
+// deleteInstructions removes a few consecutive instructions, stopping short of
+// any JUMPDEST, jump or jump-destination PUSH so every jump of the original
+// program survives.
+func deleteInstructions(f *filler.Filler, insts, _ []Instruction) []Instruction {
+	keep := func(i int) bool { return controlFlow(insts[i].Op) || isJumpTarget(insts, i) }
+	start := pick(f, len(insts))
+	if start < 0 || keep(start) {
+		return insts
+	}
+	end := start
+	for n := int(f.Byte())%maxInsert + 1; end < len(insts) && end-start < n; end++ {
+		if keep(end) {
+			break
+		}
+	}
+	out := make([]Instruction, 0, len(insts)-(end-start))
+	out = append(out, Clone(insts[:start])...)
+	return append(out, Clone(insts[end:])...)
+}
+
+// insertAt returns a copy of insts with chunk inserted before index at.
+func insertAt(insts []Instruction, at int, chunk []Instruction) []Instruction {
+	out := make([]Instruction, 0, len(insts)+len(chunk))
+	out = append(out, Clone(insts[:at])...)
+	out = append(out, chunk...)
+	return append(out, Clone(insts[at:])...)
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package mutator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/MariusVanDerWijden/FuzzyVM/filler"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/program"
+)
+
+// jumpyProgram returns code with two labels and jumps to both, so relocation
+// has something to do.
+func jumpyProgram() []byte {
+	p := program.New()
+	p.Push(1).Op(vm.POP)
+	_, a := p.Jumpdest()
+	p.Push(2).Push(3).Op(vm.ADD, vm.POP)
+	_, b := p.Jumpdest()
+	p.JumpIf(a, big.NewInt(0))
+	p.Jump(b)
+	return p.Bytes()
+}
+
+// checkJumps fails the test if any jump-target push in code does not point at a
+// JUMPDEST.
+func checkJumps(t *testing.T, code []byte) {
+	t.Helper()
+	insts := Disassemble(code)
+	for i := range insts {
+		if !isJumpTarget(insts, i) {
+			continue
+		}
+		dest := new(big.Int).SetBytes(insts[i].Arg).Uint64()
+		if dest >= uint64(len(code)) || vm.OpCode(code[dest]) != vm.JUMPDEST {
+			t.Fatalf("jump at instruction %d targets %d, which is not a JUMPDEST\ncode: %x", i, dest, code)
+		}
+	}
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	code := jumpyProgram()
+	if got := Assemble(Disassemble(code)); !bytes.Equal(got, code) {
+		t.Fatalf("round trip mismatch: got %x want %x", got, code)
+	}
+	// A PUSH running off the end of the code is kept truncated.
+	trunc := []byte{byte(vm.PUSH4), 0x01, 0x02}
+	if got := Assemble(Disassemble(trunc)); !bytes.Equal(got, trunc) {
+		t.Fatalf("truncated push round trip mismatch: got %x want %x", got, trunc)
+	}
+}
+
+func TestRelocateAfterInsertion(t *testing.T) {
+	insts := Disassemble(jumpyProgram())
+	// Insert a large chunk in front of everything, shifting both labels.
+	pad := make([]Instruction, 300)
+	for i := range pad {
+		pad[i] = Instruction{PC: noPC, Op: vm.GAS}
+	}
+	code := Relocate(insertAt(insts, 0, pad))
+	checkJumps(t, code)
+}
+
+func TestMutatePreservesJumps(t *testing.T) {
+	code := jumpyProgram()
+	for range 500 {
+		seed := make([]byte, 64)
+		rand.Read(seed)
+		out, applied := Mutate(filler.NewFiller(seed), code, code)
+		if len(applied) == 0 {
+			t.Fatalf("no mutation applied")
+		}
+		checkJumps(t, out)
+	}
+}
+
+func TestSubstituteKeepsArity(t *testing.T) {
+	code := program.New().Push(1).Push(2).Op(vm.ADD).Op(vm.ISZERO).Bytes()
+	insts := Disassemble(code)
+	for b := range 256 {
+		f := filler.NewFiller([]byte{byte(b), 0, byte(b), 7})
+		out := substituteOpcode(f, insts, nil)
+		for i := range insts {
+			if arityOf(out[i].Op) != arityOf(insts[i].Op) {
+				t.Fatalf("substitution %v -> %v changed the stack arity", insts[i].Op, out[i].Op)
+			}
+		}
+	}
+}
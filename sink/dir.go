@@ -0,0 +1,75 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores every test as <dir>/<first hash byte>/<name>.json, and next to it
+// the report of a finding as <name>.txt and its metadata as <name>.meta.json.
+// It keeps no state, so any number of processes can share one directory.
+type Dir struct {
+	dir string
+}
+
+// NewDir returns a sink that writes below dir, creating the shards as needed.
+func NewDir(dir string) *Dir {
+	return &Dir{dir: dir}
+}
+
+func (d *Dir) Store(test Test) (bool, error) {
+	if len(test.Hash) == 0 {
+		return false, errors.New("test has no hash to shard by")
+	}
+	shard := filepath.Join(d.dir, fmt.Sprintf("%02x", test.Hash[0]))
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return false, err
+	}
+	path := filepath.Join(shard, test.Name)
+	// Creating the file exclusively is what makes a test a duplicate, so two
+	// processes storing the same test can't both write it.
+	f, err := os.OpenFile(path+".json", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not open test file %q: %w", test.Name, err)
+	}
+	_, err = f.Write(append(test.JSON, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not write test %q: %w", test.Name, err)
+	}
+	if test.Report != "" {
+		if err := os.WriteFile(path+".txt", []byte(test.Report), 0644); err != nil {
+			return false, err
+		}
+	}
+	if len(test.Meta) != 0 {
+		return false, os.WriteFile(path+".meta.json", append(test.Meta, '\n'), 0644)
+	}
+	return false, nil
+}
+
+func (d *Dir) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// NDJSON writes every test as one line of JSON, {"name", "test", "report",
+// "meta"},
+// for other tools to read from a pipe. Duplicates are only detected within
+// one run.
+type NDJSON struct {
+	w    io.Writer
+	seen seen
+}
+
+// NewNDJSON returns a sink that writes to w.
+func NewNDJSON(w io.Writer) *NDJSON {
+	return &NDJSON{w: w, seen: make(seen)}
+}
+
+func (n *NDJSON) Store(test Test) (bool, error) {
+	if !n.seen.add(test.Name) {
+		return true, nil
+	}
+	// The test must not span lines.
+	var compact, meta bytes.Buffer
+	if err := json.Compact(&compact, test.JSON); err != nil {
+		return false, err
+	}
+	if len(test.Meta) != 0 {
+		if err := json.Compact(&meta, test.Meta); err != nil {
+			return false, err
+		}
+	}
+	line, err := json.Marshal(struct {
+		Name   string          `json:"name"`
+		Test   json.RawMessage `json:"test"`
+		Report string          `json:"report,omitempty"`
+		Meta   json.RawMessage `json:"meta,omitempty"`
+	}{test.Name, compact.Bytes(), test.Report, meta.Bytes()})
+	if err != nil {
+		return false, err
+	}
+	_, err = n.w.Write(append(line, '\n'))
+	return false, err
+}
+
+func (n *NDJSON) Close() error {
+	return nil
+}
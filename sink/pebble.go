@@ -0,0 +1,88 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package sink
+
+import (
+	"errors"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Key prefixes of the tests, reports and metadata in a pebble database. They
+// keep the tests apart from anything else stored in the same database, like
+// the codes of fuzzyvm-db.
+const (
+	TestPrefix   = "test/"
+	ReportPrefix = "report/"
+	MetaPrefix   = "meta/"
+)
+
+// Pebble stores every test under TestPrefix plus its name, the report of a
+// finding under ReportPrefix plus its name and the metadata under MetaPrefix
+// plus its name.
+type Pebble struct {
+	db   *pebble.DB
+	owns bool
+}
+
+// OpenPebble opens or creates a pebble database at path for the sink.
+func OpenPebble(path string) (*Pebble, error) {
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &Pebble{db: db, owns: true}, nil
+}
+
+// NewPebble stores tests in a database that is already open. Closing the sink
+// leaves the database open.
+func NewPebble(db *pebble.DB) *Pebble {
+	return &Pebble{db: db}
+}
+
+func (p *Pebble) Store(test Test) (bool, error) {
+	key := []byte(TestPrefix + test.Name)
+	if _, closer, err := p.db.Get(key); err == nil {
+		closer.Close()
+		return true, nil
+	} else if !errors.Is(err, pebble.ErrNotFound) {
+		return false, err
+	}
+	batch := p.db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(key, test.JSON, nil); err != nil {
+		return false, err
+	}
+	if test.Report != "" {
+		if err := batch.Set([]byte(ReportPrefix+test.Name), []byte(test.Report), nil); err != nil {
+			return false, err
+		}
+	}
+	if len(test.Meta) != 0 {
+		if err := batch.Set([]byte(MetaPrefix+test.Name), test.Meta, nil); err != nil {
+			return false, err
+		}
+	}
+	return false, batch.Commit(pebble.NoSync)
+}
+
+func (p *Pebble) Close() error {
+	if !p.owns {
+		return nil
+	}
+	return p.db.Close()
+}
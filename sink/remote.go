@@ -0,0 +1,203 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package sink
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// Wire protocol between a Server and the Remote sinks of the workers. A
+// request is a 4-byte big-endian length and that many bytes of a Test encoded
+// as JSON. The server stores it and replies with a single byte.
+const (
+	respStored    byte = 0
+	respDuplicate byte = 1
+	respFailed    byte = 2
+
+	// maxRequest bounds a request to guard against a corrupt length header
+	// turning into a huge allocation.
+	maxRequest = 64 << 20
+)
+
+// Server serves a sink to the Remote sinks of other processes. It stores one
+// test at a time, so the sink needn't be safe for concurrent use.
+type Server struct {
+	sink Sink
+	ln   net.Listener
+
+	storeMu sync.Mutex // serializes Store on the sink
+
+	wg      sync.WaitGroup // tracks live connection handlers
+	mu      sync.Mutex     // guards conns / closing
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+// Serve serves sink on ln until Shutdown.
+func Serve(ln net.Listener, sink Sink) *Server {
+	s := &Server{sink: sink, ln: ln, conns: make(map[net.Conn]struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			// Listener closed on shutdown; stop quietly.
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// Shutdown stops accepting connections, closes the open ones and waits for
+// their handlers. It leaves the sink open, for the caller to close once no
+// test is on its way to it anymore.
+func (s *Server) Shutdown() {
+	s.ln.Close()
+	s.mu.Lock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		var test Test
+		if err := readRequest(conn, &test); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("sink: read error: %v", err)
+			}
+			return
+		}
+		s.storeMu.Lock()
+		dup, err := s.sink.Store(test)
+		s.storeMu.Unlock()
+		resp := respStored
+		switch {
+		case err != nil:
+			log.Printf("sink: could not store %v: %v", test.Name, err)
+			resp = respFailed
+		case dup:
+			resp = respDuplicate
+		}
+		if _, err := conn.Write([]byte{resp}); err != nil {
+			return
+		}
+	}
+}
+
+func readRequest(r io.Reader, test *Test) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxRequest {
+		return fmt.Errorf("request too large: %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, test)
+}
+
+// Remote is a sink served by a Server in another process.
+type Remote struct {
+	mu   sync.Mutex // one request/response at a time
+	conn net.Conn
+}
+
+// Dial connects to the Server listening on the Unix socket addr.
+func Dial(addr string) (*Remote, error) {
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{conn: conn}, nil
+}
+
+func (r *Remote) Store(test Test) (bool, error) {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return false, err
+	}
+	if len(data) > maxRequest {
+		return false, fmt.Errorf("test %v too large: %d", test.Name, len(data))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	if _, err := r.conn.Write(append(hdr[:], data...)); err != nil {
+		return false, err
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(r.conn, resp[:]); err != nil {
+		return false, err
+	}
+	switch resp[0] {
+	case respStored:
+		return false, nil
+	case respDuplicate:
+		return true, nil
+	case respFailed:
+		return false, fmt.Errorf("sink could not store %v", test.Name)
+	default:
+		return false, fmt.Errorf("unexpected sink response %q", resp[0])
+	}
+}
+
+func (r *Remote) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,85 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package sink stores generated tests: in sharded directories, a pebble
+// database, rolling zstd-compressed tar archives or as newline-delimited JSON.
+// The fuzzing workers run in processes of their own, so a campaign opens its
+// sink once and serves it to them over a Unix socket, see Serve and Dial.
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A Test is a generated state test on its way to a sink.
+type Test struct {
+	Name string `json:"name"`
+	// Hash identifies the content of the test; the directory sink shards by it.
+	Hash []byte `json:"hash"`
+	// JSON is the encoded GeneralStateTest.
+	JSON []byte `json:"test"`
+	// Report says what is wrong with a test that is a finding.
+	Report string `json:"report,omitempty"`
+	// Meta is JSON describing how the test was made, stored next to it.
+	Meta []byte `json:"meta,omitempty"`
+}
+
+// A Sink stores tests. Tests are identified by name: a sink stores a test
+// only once and reports any further ones of the same name as duplicates. A
+// Sink need not be safe for concurrent use.
+type Sink interface {
+	Store(test Test) (duplicate bool, err error)
+	Close() error
+}
+
+// Open opens the sink a command line flag describes:
+//
+//	dir:<path>      sharded directories below path
+//	pebble:<path>   a pebble database at path
+//	tar:<prefix>    rolling archives <prefix>-<n>.tar.zst
+//	ndjson          newline-delimited JSON on stdout
+func Open(spec string) (Sink, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch {
+	case kind == "ndjson" && path == "":
+		return NewNDJSON(os.Stdout), nil
+	case path == "":
+		return nil, fmt.Errorf("invalid sink %q, want dir:<path>, pebble:<path>, tar:<prefix> or ndjson", spec)
+	case kind == "dir":
+		return NewDir(path), nil
+	case kind == "pebble":
+		return OpenPebble(path)
+	case kind == "tar":
+		return NewTar(path, DefaultArchiveSize), nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q", kind)
+	}
+}
+
+// seen tracks the tests a sink that can't look back at what it wrote has
+// stored in this run.
+type seen map[string]struct{}
+
+// add records name and reports whether it was new.
+func (s seen) add(name string) bool {
+	if _, ok := s[name]; ok {
+		return false
+	}
+	s[name] = struct{}{}
+	return true
+}
@@ -0,0 +1,193 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package sink
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	plain   = Test{Name: "plain", Hash: []byte{0xab, 1}, JSON: []byte(`{"plain": {}}`)}
+	finding = Test{Name: "finding", Hash: []byte{0x01, 2}, JSON: []byte("{\n \"finding\": {}\n}"), Report: "broken\n", Meta: []byte(`{"fork": "Prague"}`)}
+)
+
+// storeAll stores both tests twice into s and checks that the second time
+// they are duplicates.
+func storeAll(t *testing.T, s Sink) {
+	t.Helper()
+	for _, want := range []bool{false, true} {
+		for _, test := range []Test{plain, finding} {
+			if dup, err := s.Store(test); err != nil || dup != want {
+				t.Fatalf("storing %v: duplicate %v, err %v, want duplicate %v", test.Name, dup, err, want)
+			}
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	storeAll(t, NewDir(dir))
+	for path, want := range map[string]string{
+		"ab/plain.json":        string(plain.JSON) + "\n",
+		"01/finding.json":      string(finding.JSON) + "\n",
+		"01/finding.txt":       finding.Report,
+		"01/finding.meta.json": string(finding.Meta) + "\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(got) != want {
+			t.Errorf("%v: %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestPebble(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tests.pebble")
+	s, err := OpenPebble(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeAll(t, s)
+	db, err := pebble.Open(path, &pebble.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, want := range map[string][]byte{
+		TestPrefix + "plain":     plain.JSON,
+		ReportPrefix + "finding": []byte(finding.Report),
+		MetaPrefix + "finding":   finding.Meta,
+	} {
+		got, closer, err := db.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("%v: %v", key, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: %q, want %q", key, got, want)
+		}
+		closer.Close()
+	}
+}
+
+func TestTar(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "tests")
+	// Every test fills an archive.
+	storeAll(t, NewTar(prefix, 1))
+	want := [][]string{{"plain.json"}, {"finding.json", "finding.txt", "finding.meta.json"}}
+	for i, names := range want {
+		f, err := os.Open(filepath.Join(filepath.Dir(prefix), []string{"tests-000000.tar.zst", "tests-000001.tar.zst"}[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		tr := tar.NewReader(zr)
+		for _, name := range names {
+			hdr, err := tr.Next()
+			if err != nil || hdr.Name != name {
+				t.Fatalf("archive %d: %v, %v, want %v", i, hdr, err, name)
+			}
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("archive %d: more than %v", i, names)
+		}
+	}
+	// A new run continues after the existing archives.
+	s := NewTar(prefix, DefaultArchiveSize)
+	storeAll(t, s)
+	if _, err := os.Stat(prefix + "-000002.tar.zst"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	storeAll(t, NewNDJSON(&out))
+	scanner := bufio.NewScanner(&out)
+	var lines []Test
+	for scanner.Scan() {
+		var line struct {
+			Name   string          `json:"name"`
+			Test   json.RawMessage `json:"test"`
+			Report string          `json:"report"`
+			Meta   json.RawMessage `json:"meta"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("%q: %v", scanner.Bytes(), err)
+		}
+		lines = append(lines, Test{Name: line.Name, JSON: line.Test, Report: line.Report, Meta: line.Meta})
+	}
+	if len(lines) != 2 || lines[1].Name != "finding" || lines[1].Report != finding.Report || string(lines[1].JSON) != `{"finding":{}}` || string(lines[1].Meta) != `{"fork":"Prague"}` {
+		t.Errorf("unexpected lines %q", out.String())
+	}
+}
+
+func TestRemote(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sink.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	srv := Serve(ln, NewDir(dir))
+	remote, err := Dial(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeAll(t, remote)
+	srv.Shutdown()
+	if _, err := os.Stat(filepath.Join(dir, "01", "finding.txt")); err != nil {
+		t.Error(err)
+	}
+	// A dead server is an error, not a duplicate.
+	if _, err := remote.Store(plain); err == nil {
+		t.Error("stored to a closed connection")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, spec := range []string{"", "dir", "dir:", "zip:out", "ndjson:out"} {
+		if _, err := Open(spec); err == nil {
+			t.Errorf("opened invalid sink %q", spec)
+		}
+	}
+	for _, spec := range []string{"dir:" + t.TempDir(), "ndjson", "tar:" + filepath.Join(t.TempDir(), "x")} {
+		s, err := Open(spec)
+		if err != nil {
+			t.Errorf("%v: %v", spec, err)
+			continue
+		}
+		s.Close()
+	}
+}
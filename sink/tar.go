@@ -0,0 +1,135 @@
+// Copyright 2026 Marius van der Wijden
+// This file is part of the fuzzy-vm library.
+//
+// The fuzzy-vm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The fuzzy-vm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the fuzzy-vm library. If not, see <http://www.gnu.org/licenses/>.
+
+package sink
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultArchiveSize is how many bytes of tests go into one archive before
+// Tar starts the next.
+const DefaultArchiveSize = 256 << 20
+
+// Tar writes tests, as <name>.json with the report of a finding as
+// <name>.txt and the metadata as <name>.meta.json, into zstd-compressed tar
+// archives <prefix>-<n>.tar.zst. Once an archive holds size bytes of tests it
+// is finished and the next one started, so finished archives can be moved away
+// while the campaign runs. Numbering continues after the archives already
+// there. Duplicates are only detected within one run.
+type Tar struct {
+	prefix string
+	size   int64
+	seen   seen
+
+	next    int
+	file    *os.File
+	zw      *zstd.Encoder
+	tw      *tar.Writer
+	written int64
+}
+
+// NewTar returns a sink that writes archives named after prefix, each holding
+// about size bytes of tests.
+func NewTar(prefix string, size int64) *Tar {
+	return &Tar{prefix: prefix, size: size, seen: make(seen)}
+}
+
+func (t *Tar) Store(test Test) (bool, error) {
+	if !t.seen.add(test.Name) {
+		return true, nil
+	}
+	if t.tw == nil {
+		if err := t.open(); err != nil {
+			return false, err
+		}
+	}
+	if err := t.add(test.Name+".json", test.JSON); err != nil {
+		return false, err
+	}
+	if test.Report != "" {
+		if err := t.add(test.Name+".txt", []byte(test.Report)); err != nil {
+			return false, err
+		}
+	}
+	if len(test.Meta) != 0 {
+		if err := t.add(test.Name+".meta.json", test.Meta); err != nil {
+			return false, err
+		}
+	}
+	if t.written >= t.size {
+		return false, t.finish()
+	}
+	return false, nil
+}
+
+// open starts the first archive number that isn't taken yet.
+func (t *Tar) open() error {
+	for {
+		path := fmt.Sprintf("%v-%06d.tar.zst", t.prefix, t.next)
+		t.next++
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		t.file, t.zw, t.tw, t.written = f, zw, tar.NewWriter(zw), 0
+		return nil
+	}
+}
+
+func (t *Tar) add(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := t.tw.Write(data); err != nil {
+		return err
+	}
+	t.written += int64(len(data))
+	return nil
+}
+
+// finish completes the current archive.
+func (t *Tar) finish() error {
+	if t.tw == nil {
+		return nil
+	}
+	err := errors.Join(t.tw.Close(), t.zw.Close(), t.file.Close())
+	t.file, t.zw, t.tw = nil, nil, nil
+	return err
+}
+
+func (t *Tar) Close() error {
+	return t.finish()
+}